@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DataTypeUsage is the ingest of one kind of data.
+type DataTypeUsage struct {
+	DataType      string  `json:"dataType"`
+	Gigabytes     float64 `json:"gigabytes"`
+	EstimatedCost float64 `json:"estimatedCost"`
+}
+
+// AccountUsage is the ingest of an account over a period, with its
+// estimated cost.
+type AccountUsage struct {
+	AccountID         int             `json:"accountId"`
+	Since             string          `json:"since"`
+	DataTypes         []DataTypeUsage `json:"dataTypes"`
+	TotalGigabytes    float64         `json:"totalGigabytes"`
+	BillableGigabytes float64         `json:"billableGigabytes"`
+	EstimatedCost     float64         `json:"estimatedCost"`
+	PricePerGB        float64         `json:"pricePerGB"`
+}
+
+// DesiredState is the keys accounts should have, for Reconcile.
+type DesiredState struct {
+	Accounts []DesiredAccount `json:"accounts"`
+	// Prune deletes live keys that aren't listed.
+	Prune bool `json:"prune,omitempty"`
+}
+
+// DesiredAccount is the keys one account should have.
+type DesiredAccount struct {
+	AccountID int          `json:"accountId"`
+	Keys      []DesiredKey `json:"keys"`
+}
+
+// DesiredKey is a key that should exist. Notes left nil aren't compared.
+type DesiredKey struct {
+	Name       string            `json:"name"`
+	IngestType string            `json:"ingestType"`
+	Notes      *string           `json:"notes,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// ReconcileAction is one step of converging an account to its desired
+// state, and what became of it.
+type ReconcileAction struct {
+	Action            string   `json:"action"`
+	AccountID         int      `json:"accountId"`
+	KeyID             string   `json:"keyId,omitempty"`
+	Name              string   `json:"name"`
+	IngestType        string   `json:"ingestType"`
+	Changes           []string `json:"changes,omitempty"`
+	Status            string   `json:"status,omitempty"`
+	Error             string   `json:"error,omitempty"`
+	Job               string   `json:"job,omitempty"`
+	Approval          string   `json:"approval,omitempty"`
+	CancellationToken string   `json:"cancellationToken,omitempty"`
+}
+
+// DriftReport is how the live keys differ from the configured desired
+// state.
+type DriftReport struct {
+	Source     string            `json:"source"`
+	CheckedAt  time.Time         `json:"checkedAt"`
+	Accounts   []int             `json:"accounts"`
+	InSync     bool              `json:"inSync"`
+	Additions  []ReconcileAction `json:"additions"`
+	Deletions  []ReconcileAction `json:"deletions"`
+	Mismatches []ReconcileAction `json:"mismatches"`
+}
+
+// Usage returns the ingest of an account since an NRQL time such as
+// "7 days ago", or month to date when since is "".
+func (c *Client) Usage(ctx context.Context, account int, since string) (*AccountUsage, error) {
+	query := url.Values{}
+	if since != "" {
+		query.Set("since", since)
+	}
+	var result struct {
+		Usage AccountUsage `json:"usage"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/accounts/"+strconv.Itoa(account)+"/usage", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Usage, nil
+}
+
+// Reconcile converges the accounts' keys to the desired state, or with
+// dryRun only plans how.
+func (c *Client) Reconcile(ctx context.Context, desired DesiredState, dryRun bool) ([]ReconcileAction, error) {
+	query := url.Values{}
+	if dryRun {
+		query.Set("dryRun", "true")
+	}
+	var result struct {
+		Actions []ReconcileAction `json:"actions"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/reconcile", query, desired, &result); err != nil {
+		return nil, err
+	}
+	return result.Actions, nil
+}
+
+// Drift compares the live keys with the desired state the proxy is
+// configured with, without changing anything.
+func (c *Client) Drift(ctx context.Context) (*DriftReport, error) {
+	var report DriftReport
+	if err := c.do(ctx, http.MethodGet, "/drift", nil, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Issue is an alert issue: incidents correlated into one problem. Times are
+// in epoch milliseconds.
+type Issue struct {
+	IssueID        string   `json:"issueId"`
+	Title          []string `json:"title"`
+	Description    []string `json:"description"`
+	Priority       string   `json:"priority"`
+	State          string   `json:"state"`
+	Sources        []string `json:"sources"`
+	EntityGUIDs    []string `json:"entityGuids"`
+	EntityNames    []string `json:"entityNames"`
+	ConditionName  []string `json:"conditionName"`
+	PolicyName     []string `json:"policyName"`
+	TotalIncidents float64  `json:"totalIncidents"`
+	CreatedAt      int64    `json:"createdAt"`
+	ActivatedAt    *int64   `json:"activatedAt"`
+	UpdatedAt      int64    `json:"updatedAt"`
+	ClosedAt       *int64   `json:"closedAt"`
+	DeepLinkURL    string   `json:"deepLinkUrl"`
+}
+
+// IssueQuery filters the issues of an account. Without States only open
+// issues are listed; "all" lists every issue.
+type IssueQuery struct {
+	States      []string
+	EntityGUIDs []string
+	Since       time.Time
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+}
+
+// IssuePage is a page of issues. NextCursor is empty on the last page.
+type IssuePage struct {
+	Issues     []Issue `json:"issues"`
+	NextCursor string  `json:"nextCursor"`
+}
+
+// Issues lists the alert issues of an account.
+func (c *Client) Issues(ctx context.Context, account int, q IssueQuery) (*IssuePage, error) {
+	query := url.Values{}
+	if len(q.States) > 0 {
+		query.Set("state", strings.Join(q.States, ","))
+	}
+	if len(q.EntityGUIDs) > 0 {
+		query.Set("entityGuid", strings.Join(q.EntityGUIDs, ","))
+	}
+	if !q.Since.IsZero() {
+		query.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if q.Cursor != "" {
+		query.Set("cursor", q.Cursor)
+	}
+	var page IssuePage
+	if err := c.do(ctx, http.MethodGet, "/accounts/"+strconv.Itoa(account)+"/issues", query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// MutingRuleCondition matches incidents on one attribute.
+type MutingRuleCondition struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values"`
+}
+
+// MutingRuleConditionGroup combines conditions with AND or OR.
+type MutingRuleConditionGroup struct {
+	Operator   string                `json:"operator"`
+	Conditions []MutingRuleCondition `json:"conditions"`
+}
+
+// MutingRuleSchedule limits a rule to a window, optionally repeating.
+type MutingRuleSchedule struct {
+	StartTime        string   `json:"startTime,omitempty"`
+	EndTime          string   `json:"endTime,omitempty"`
+	TimeZone         string   `json:"timeZone"`
+	Repeat           string   `json:"repeat,omitempty"`
+	EndRepeat        string   `json:"endRepeat,omitempty"`
+	RepeatCount      int      `json:"repeatCount,omitempty"`
+	WeeklyRepeatDays []string `json:"weeklyRepeatDays,omitempty"`
+}
+
+// MutingRule silences alert notifications for matching incidents.
+type MutingRule struct {
+	ID          string                   `json:"id,omitempty"`
+	Name        string                   `json:"name"`
+	Description string                   `json:"description,omitempty"`
+	Enabled     bool                     `json:"enabled"`
+	Condition   MutingRuleConditionGroup `json:"condition"`
+	Schedule    *MutingRuleSchedule      `json:"schedule,omitempty"`
+}
+
+// NrqlConditionTerm is a threshold that opens an incident at a priority.
+type NrqlConditionTerm struct {
+	Operator             string  `json:"operator"`
+	Priority             string  `json:"priority"`
+	Threshold            float64 `json:"threshold"`
+	ThresholdDuration    int     `json:"thresholdDuration"`
+	ThresholdOccurrences string  `json:"thresholdOccurrences"`
+}
+
+// NrqlConditionSignal controls how the query results are aggregated.
+type NrqlConditionSignal struct {
+	AggregationWindow int      `json:"aggregationWindow,omitempty"`
+	AggregationMethod string   `json:"aggregationMethod,omitempty"`
+	AggregationDelay  int      `json:"aggregationDelay,omitempty"`
+	FillOption        string   `json:"fillOption,omitempty"`
+	FillValue         *float64 `json:"fillValue,omitempty"`
+}
+
+// NrqlConditionExpiration controls what happens when the signal stops.
+type NrqlConditionExpiration struct {
+	ExpirationDuration          int  `json:"expirationDuration,omitempty"`
+	OpenViolationOnExpiration   bool `json:"openViolationOnExpiration"`
+	CloseViolationsOnExpiration bool `json:"closeViolationsOnExpiration"`
+}
+
+// NrqlQuery is the query a condition evaluates.
+type NrqlQuery struct {
+	Query string `json:"query"`
+}
+
+// NrqlCondition is a static or baseline NRQL alert condition.
+type NrqlCondition struct {
+	ID                        string                   `json:"id,omitempty"`
+	PolicyID                  string                   `json:"policyId,omitempty"`
+	Name                      string                   `json:"name"`
+	Description               string                   `json:"description,omitempty"`
+	Enabled                   bool                     `json:"enabled"`
+	RunbookURL                string                   `json:"runbookUrl,omitempty"`
+	NRQL                      NrqlQuery                `json:"nrql"`
+	Terms                     []NrqlConditionTerm      `json:"terms"`
+	Signal                    *NrqlConditionSignal     `json:"signal,omitempty"`
+	Expiration                *NrqlConditionExpiration `json:"expiration,omitempty"`
+	ViolationTimeLimitSeconds int                      `json:"violationTimeLimitSeconds,omitempty"`
+	BaselineDirection         string                   `json:"baselineDirection,omitempty"`
+}
+
+// NrqlConditionRequest creates or replaces a condition. Type is static
+// (default) or baseline.
+type NrqlConditionRequest struct {
+	Type      string        `json:"type"`
+	Condition NrqlCondition `json:"condition"`
+}
+
+// AnomalyDetector opens an incident when a query strays from its baseline
+// by Sensitivity standard deviations for DurationSeconds. Zero fields take
+// the proxy's defaults: UPPER_AND_LOWER, 3 and 300.
+type AnomalyDetector struct {
+	Name               string   `json:"name"`
+	Description        string   `json:"description,omitempty"`
+	RunbookURL         string   `json:"runbookUrl,omitempty"`
+	NRQL               string   `json:"nrql"`
+	Direction          string   `json:"direction,omitempty"`
+	Sensitivity        float64  `json:"sensitivity,omitempty"`
+	WarningSensitivity *float64 `json:"warningSensitivity,omitempty"`
+	DurationSeconds    int      `json:"durationSeconds,omitempty"`
+	Enabled            *bool    `json:"enabled,omitempty"`
+}
+
+// AnomalyDetectorUpdate changes the baseline settings of a detector. Nil
+// and zero fields are left as they are; Sensitivity is needed to change
+// WarningSensitivity or DurationSeconds.
+type AnomalyDetectorUpdate struct {
+	Direction          string   `json:"direction,omitempty"`
+	Sensitivity        *float64 `json:"sensitivity,omitempty"`
+	WarningSensitivity *float64 `json:"warningSensitivity,omitempty"`
+	DurationSeconds    int      `json:"durationSeconds,omitempty"`
+	Enabled            *bool    `json:"enabled,omitempty"`
+}
+
+// EventsToMetricsRule turns the results of an NRQL query over events into
+// metrics.
+type EventsToMetricsRule struct {
+	ID          string `json:"id,omitempty"`
+	AccountID   int    `json:"accountId,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	NRQL        string `json:"nrql"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// MetricNormalizationRule renames, ignores or stops new metrics whose names
+// match an expression.
+type MetricNormalizationRule struct {
+	ID              int    `json:"id,omitempty"`
+	Action          string `json:"action"`
+	MatchExpression string `json:"matchExpression"`
+	Replacement     string `json:"replacement,omitempty"`
+	EvalOrder       int    `json:"evalOrder"`
+	TerminateChain  bool   `json:"terminateChain"`
+	Enabled         bool   `json:"enabled"`
+	Notes           string `json:"notes,omitempty"`
+	ApplicationGUID string `json:"applicationGuid,omitempty"`
+}
+
+func accountPath(account int, parts ...string) string {
+	path := "/accounts/" + strconv.Itoa(account)
+	for _, part := range parts {
+		path += "/" + url.PathEscape(part)
+	}
+	return path
+}
+
+// CreateMutingRule creates a muting rule in an account.
+func (c *Client) CreateMutingRule(ctx context.Context, account int, rule MutingRule) (*MutingRule, error) {
+	return c.saveMutingRule(ctx, http.MethodPost, accountPath(account, "mutingRules"), rule)
+}
+
+// UpdateMutingRule replaces a muting rule.
+func (c *Client) UpdateMutingRule(ctx context.Context, account int, id string, rule MutingRule) (*MutingRule, error) {
+	return c.saveMutingRule(ctx, http.MethodPut, accountPath(account, "mutingRules", id), rule)
+}
+
+func (c *Client) saveMutingRule(ctx context.Context, method, path string, rule MutingRule) (*MutingRule, error) {
+	var result struct {
+		Rule MutingRule `json:"muting_rule"`
+	}
+	if err := c.do(ctx, method, path, nil, rule, &result); err != nil {
+		return nil, err
+	}
+	return &result.Rule, nil
+}
+
+// DeleteMutingRule deletes a muting rule.
+func (c *Client) DeleteMutingRule(ctx context.Context, account int, id string) error {
+	err := c.do(ctx, http.MethodDelete, accountPath(account, "mutingRules", id), nil, nil, nil)
+	return err
+}
+
+// CreateNrqlCondition creates an NRQL condition in an alert policy.
+func (c *Client) CreateNrqlCondition(ctx context.Context, account int, policyID string, request NrqlConditionRequest) (*NrqlCondition, error) {
+	return c.saveNrqlCondition(ctx, http.MethodPost, accountPath(account, "policies", policyID, "conditions"), request)
+}
+
+// UpdateNrqlCondition replaces an NRQL condition.
+func (c *Client) UpdateNrqlCondition(ctx context.Context, account int, id string, request NrqlConditionRequest) (*NrqlCondition, error) {
+	return c.saveNrqlCondition(ctx, http.MethodPut, accountPath(account, "conditions", id), request)
+}
+
+func (c *Client) saveNrqlCondition(ctx context.Context, method, path string, request any) (*NrqlCondition, error) {
+	var result struct {
+		Condition NrqlCondition `json:"condition"`
+	}
+	if err := c.do(ctx, method, path, nil, request, &result); err != nil {
+		return nil, err
+	}
+	return &result.Condition, nil
+}
+
+// CreateAnomalyDetector creates an anomaly detector, a baseline NRQL
+// condition, in an alert policy.
+func (c *Client) CreateAnomalyDetector(ctx context.Context, account int, policyID string, detector AnomalyDetector) (*NrqlCondition, error) {
+	return c.saveNrqlCondition(ctx, http.MethodPost, accountPath(account, "policies", policyID, "anomalyDetectors"), detector)
+}
+
+// UpdateAnomalyDetector changes the baseline settings of an anomaly
+// detector. Delete it with DeleteCondition.
+func (c *Client) UpdateAnomalyDetector(ctx context.Context, account int, id string, update AnomalyDetectorUpdate) (*NrqlCondition, error) {
+	return c.saveNrqlCondition(ctx, http.MethodPatch, accountPath(account, "anomalyDetectors", id), update)
+}
+
+// DeleteCondition deletes an alert condition of any type.
+func (c *Client) DeleteCondition(ctx context.Context, account int, id string) error {
+	err := c.do(ctx, http.MethodDelete, accountPath(account, "conditions", id), nil, nil, nil)
+	return err
+}
+
+// CreateEventsToMetricsRule creates an events-to-metrics rule.
+func (c *Client) CreateEventsToMetricsRule(ctx context.Context, account int, rule EventsToMetricsRule) (*EventsToMetricsRule, error) {
+	var result struct {
+		Rule EventsToMetricsRule `json:"rule"`
+	}
+	if err := c.do(ctx, http.MethodPost, accountPath(account, "eventsToMetrics"), nil, rule, &result); err != nil {
+		return nil, err
+	}
+	return &result.Rule, nil
+}
+
+// DeleteEventsToMetricsRule deletes an events-to-metrics rule.
+func (c *Client) DeleteEventsToMetricsRule(ctx context.Context, account int, id string) error {
+	err := c.do(ctx, http.MethodDelete, accountPath(account, "eventsToMetrics", id), nil, nil, nil)
+	return err
+}
+
+// CreateMetricNormalizationRule creates a metric normalization rule.
+func (c *Client) CreateMetricNormalizationRule(ctx context.Context, account int, rule MetricNormalizationRule) (*MetricNormalizationRule, error) {
+	return c.metricNormalizationRule(ctx, http.MethodPost, accountPath(account, "metricNormalizationRules"), rule)
+}
+
+// UpdateMetricNormalizationRule edits a metric normalization rule.
+func (c *Client) UpdateMetricNormalizationRule(ctx context.Context, account, id int, rule MetricNormalizationRule) (*MetricNormalizationRule, error) {
+	return c.metricNormalizationRule(ctx, http.MethodPut, accountPath(account, "metricNormalizationRules", strconv.Itoa(id)), rule)
+}
+
+// SetMetricNormalizationRuleEnabled enables or disables a metric
+// normalization rule. NerdGraph has no way to delete one.
+func (c *Client) SetMetricNormalizationRuleEnabled(ctx context.Context, account, id int, enabled bool) (*MetricNormalizationRule, error) {
+	toggle := "disable"
+	if enabled {
+		toggle = "enable"
+	}
+	return c.metricNormalizationRule(ctx, http.MethodPost, accountPath(account, "metricNormalizationRules", strconv.Itoa(id), toggle), nil)
+}
+
+func (c *Client) metricNormalizationRule(ctx context.Context, method, path string, body any) (*MetricNormalizationRule, error) {
+	var result struct {
+		Rule MetricNormalizationRule `json:"rule"`
+	}
+	if err := c.do(ctx, method, path, nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result.Rule, nil
+}
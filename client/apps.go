@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// BrowserAppRequest creates a browser application and, with CreateKey, the
+// BROWSER ingest key its loader reports with.
+type BrowserAppRequest struct {
+	AccountID                 int               `json:"account_id"`
+	Name                      string            `json:"name"`
+	LoaderType                string            `json:"loaderType,omitempty"`
+	CookiesEnabled            *bool             `json:"cookiesEnabled,omitempty"`
+	DistributedTracingEnabled *bool             `json:"distributedTracingEnabled,omitempty"`
+	CreateKey                 bool              `json:"create_key"`
+	KeyName                   string            `json:"key_name,omitempty"`
+	Notes                     string            `json:"notes,omitempty"`
+	Labels                    map[string]string `json:"labels,omitempty"`
+}
+
+// BrowserApplication is a browser app with the loader script to embed in
+// the site.
+type BrowserApplication struct {
+	GUID     string `json:"guid"`
+	Name     string `json:"name"`
+	Settings struct {
+		CookiesEnabled            bool   `json:"cookiesEnabled"`
+		DistributedTracingEnabled bool   `json:"distributedTracingEnabled"`
+		LoaderScript              string `json:"loaderScript"`
+		LoaderType                string `json:"loaderType"`
+	} `json:"settings"`
+}
+
+// MobileApplication is a mobile app with the token its agent reports with.
+type MobileApplication struct {
+	GUID             string `json:"guid"`
+	Name             string `json:"name"`
+	AccountID        int    `json:"accountId"`
+	ApplicationToken string `json:"applicationToken"`
+}
+
+// DeploymentRequest records a deployment of an entity. Timestamp is in
+// epoch milliseconds; NerdGraph uses the current time when it is 0.
+type DeploymentRequest struct {
+	EntityGUID     string `json:"entityGuid"`
+	Version        string `json:"version"`
+	Changelog      string `json:"changelog,omitempty"`
+	Commit         string `json:"commit,omitempty"`
+	DeepLink       string `json:"deepLink,omitempty"`
+	DeploymentType string `json:"deploymentType,omitempty"`
+	Description    string `json:"description,omitempty"`
+	GroupID        string `json:"groupId,omitempty"`
+	User           string `json:"user,omitempty"`
+	Timestamp      int64  `json:"timestamp,omitempty"`
+}
+
+// Deployment is a recorded deployment marker.
+type Deployment struct {
+	DeploymentID   string `json:"deploymentId"`
+	EntityGUID     string `json:"entityGuid"`
+	Version        string `json:"version"`
+	Timestamp      int64  `json:"timestamp"`
+	Changelog      string `json:"changelog,omitempty"`
+	Commit         string `json:"commit,omitempty"`
+	DeepLink       string `json:"deepLink,omitempty"`
+	DeploymentType string `json:"deploymentType,omitempty"`
+	Description    string `json:"description,omitempty"`
+	GroupID        string `json:"groupId,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+// CreateBrowserApplication creates a browser application, and its ingest key
+// when request.CreateKey is set. When the application was created but its
+// key wasn't, the *Error's body still holds the application.
+func (c *Client) CreateBrowserApplication(ctx context.Context, request BrowserAppRequest) (*BrowserApplication, *Key, error) {
+	var result struct {
+		Application BrowserApplication `json:"application"`
+		Key         *Key               `json:"insert_key"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/apps/browser", nil, request, &result); err != nil {
+		return nil, nil, err
+	}
+	return &result.Application, result.Key, nil
+}
+
+// CreateMobileApplication creates a mobile application.
+func (c *Client) CreateMobileApplication(ctx context.Context, account int, name string) (*MobileApplication, error) {
+	request := map[string]any{"account_id": account, "name": name}
+	return c.mobileApplication(ctx, http.MethodPost, "/apps/mobile", request)
+}
+
+// MobileApplicationToken looks up a mobile application with its token.
+func (c *Client) MobileApplicationToken(ctx context.Context, guid string) (*MobileApplication, error) {
+	return c.mobileApplication(ctx, http.MethodGet, "/apps/mobile/"+url.PathEscape(guid)+"/token", nil)
+}
+
+func (c *Client) mobileApplication(ctx context.Context, method, path string, body any) (*MobileApplication, error) {
+	var result struct {
+		Application MobileApplication `json:"application"`
+	}
+	if err := c.do(ctx, method, path, nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result.Application, nil
+}
+
+// CreateDeployment records a deployment marker.
+func (c *Client) CreateDeployment(ctx context.Context, request DeploymentRequest) (*Deployment, error) {
+	var result struct {
+		Deployment Deployment `json:"deployment"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/deployments", nil, request, &result); err != nil {
+		return nil, err
+	}
+	return &result.Deployment, nil
+}
+
+// GoldenMetric is one of an entity's golden metrics with its value over the
+// summary's window. Value is nil when the query returned no single number;
+// Results then holds what it did return.
+type GoldenMetric struct {
+	Name    string           `json:"name"`
+	Title   string           `json:"title"`
+	Unit    string           `json:"unit"`
+	Query   string           `json:"query"`
+	Value   *float64         `json:"value"`
+	Results []map[string]any `json:"results,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// EntitySummary is whether an entity is reporting, and its golden metrics.
+type EntitySummary struct {
+	GUID          string         `json:"guid"`
+	Name          string         `json:"name"`
+	Type          string         `json:"type"`
+	Domain        string         `json:"domain"`
+	AccountID     int            `json:"accountId"`
+	Reporting     bool           `json:"reporting"`
+	AlertSeverity string         `json:"alertSeverity,omitempty"`
+	Since         string         `json:"since"`
+	GoldenMetrics []GoldenMetric `json:"goldenMetrics"`
+}
+
+// EntitySummary returns an entity's golden metrics since an NRQL time such
+// as "1 hour ago", or over the last 30 minutes when since is "".
+func (c *Client) EntitySummary(ctx context.Context, guid, since string) (*EntitySummary, error) {
+	query := url.Values{}
+	if since != "" {
+		query.Set("since", since)
+	}
+	var result struct {
+		Summary EntitySummary `json:"summary"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/entities/"+url.PathEscape(guid)+"/summary", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Summary, nil
+}
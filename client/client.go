@@ -0,0 +1,228 @@
+// Package client is a Go client for the ingest key proxy. It has a typed
+// method for each endpoint, authenticates with a bearer token, retries
+// reads the proxy or NerdGraph turned away for the moment, and reports
+// failed requests as *Error values.
+//
+// Changes the proxy queues instead of making straight away, because
+// NerdGraph is throttling or unreachable or because they need approval,
+// come back with their Accepted fields set; WaitJob follows a queued job
+// until it finishes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries  = 3
+	defaultMaxWait  = 30 * time.Second
+	initialBackoff  = 500 * time.Millisecond
+	defaultPollWait = 2 * time.Second
+	// maxPageSize is the largest page the proxy serves.
+	maxPageSize = 1000
+)
+
+// PageOptions selects a page of a list. A zero Limit takes the proxy's
+// default of 100; Cursor is the NextCursor of the previous page.
+type PageOptions struct {
+	Limit  int
+	Cursor string
+}
+
+// query adds the page's parameters to query.
+func (p PageOptions) query(query url.Values) url.Values {
+	if p.Limit > 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		query.Set("cursor", p.Cursor)
+	}
+	return query
+}
+
+// Client sends requests to one proxy.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	token      string
+	credential string
+	retries    int
+	maxWait    time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client requests are sent with.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithToken authenticates requests with a caller token or a session token
+// from signing in.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithCredential selects the named NerdGraph credential requests use.
+func WithCredential(name string) Option {
+	return func(c *Client) { c.credential = name }
+}
+
+// WithRetries sets how many times a request is tried again, and the longest
+// the client waits before one retry. A Retry-After longer than maxWait is
+// returned as an error instead of waited out.
+func WithRetries(retries int, maxWait time.Duration) Option {
+	return func(c *Client) { c.retries, c.maxWait = retries, maxWait }
+}
+
+// New returns a client for the proxy at baseURL, such as
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base URL %q must be http or https", baseURL)
+	}
+	c := &Client{baseURL: u, httpClient: http.DefaultClient, retries: defaultRetries, maxWait: defaultMaxWait}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Accepted is set when the proxy queued a change instead of making it: as a
+// job while NerdGraph is throttling or unreachable, or as approvals that
+// need someone else's sign-off.
+type Accepted struct {
+	Job       *Job       `json:"job,omitempty"`
+	Approval  *Approval  `json:"approval,omitempty"`
+	Approvals []Approval `json:"approvals,omitempty"`
+}
+
+// Pending reports whether the change was queued rather than made.
+func (a Accepted) Pending() bool {
+	return a.Job != nil || a.Approval != nil || len(a.Approvals) > 0
+}
+
+// retryableStatus reports whether a response with the status may be tried
+// again. Only reads are retried: a change answered with 429 or a gateway
+// error may still have been partly made, such as some keys of a batch.
+func retryableStatus(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return method == http.MethodGet
+	}
+	return false
+}
+
+// backoff is how long to wait before retry number attempt, counting from 1.
+func (c *Client) backoff(attempt int, err *Error) time.Duration {
+	if err != nil && err.RetryAfter > 0 {
+		return err.RetryAfter
+	}
+	return min(initialBackoff<<(attempt-1), c.maxWait)
+}
+
+// do sends a request with body encoded as JSON and decodes a successful
+// response into out.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("client: encoding request: %w", err)
+		}
+	}
+	return c.send(ctx, method, path, query, "application/json", payload, out)
+}
+
+// send is do with a body that is already encoded.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, contentType string, payload []byte, out any) error {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return fmt.Errorf("client: %w", err)
+		}
+		if payload != nil {
+			req.Body = io.NopCloser(bytes.NewReader(payload))
+			req.ContentLength = int64(len(payload))
+			req.Header.Set("Content-Type", contentType)
+		}
+		req.Header.Set("Accept", "application/json")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if c.credential != "" {
+			req.Header.Set("X-New-Relic-Credential", c.credential)
+		}
+
+		res, err := c.httpClient.Do(req)
+		var apiErr *Error
+		switch {
+		case err != nil && (ctx.Err() != nil || method != http.MethodGet):
+			return fmt.Errorf("client: %s %s: %w", method, path, err)
+		case err != nil:
+		case res.StatusCode < 300:
+			return decodeResponse(res, out)
+		default:
+			apiErr = newError(res)
+			if !retryableStatus(method, res.StatusCode) {
+				return apiErr
+			}
+		}
+		if attempt > c.retries {
+			if apiErr != nil {
+				return apiErr
+			}
+			return fmt.Errorf("client: %s %s: %w", method, path, err)
+		}
+		wait := c.backoff(attempt, apiErr)
+		if wait > c.maxWait {
+			return apiErr
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func decodeResponse(res *http.Response, out any) error {
+	defer res.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decoding response: %w", err)
+	}
+	return nil
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyProxy answers the first failures requests with status, and then
+// with an empty JSON object. It returns the client and the request count.
+func flakyProxy(t *testing.T, failures int, status int, retryAfter string) (*Client, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(requests.Add(1)) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.Header().Set("X-Request-ID", "req-1")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error": "try again"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(proxy.Close)
+	c, err := New(proxy.URL, WithRetries(2, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &requests
+}
+
+func TestRetries(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		method   string
+		failures int
+		status   int
+		want     int32
+		wantErr  error
+	}{
+		{"read after gateway errors", http.MethodGet, 2, http.StatusBadGateway, 3, nil},
+		{"read after rate limit", http.MethodGet, 1, http.StatusTooManyRequests, 2, nil},
+		{"read out of retries", http.MethodGet, 5, http.StatusServiceUnavailable, 3, ErrUnavailable},
+		{"change rate limited", http.MethodPost, 1, http.StatusTooManyRequests, 1, ErrRateLimited},
+		{"change behind a gateway error", http.MethodPost, 1, http.StatusGatewayTimeout, 1, ErrUnavailable},
+		{"read not found", http.MethodGet, 1, http.StatusNotFound, 1, ErrNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, requests := flakyProxy(t, test.failures, test.status, "")
+			err := c.do(context.Background(), test.method, "/keys", nil, nil, nil)
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil) != (err == nil) {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+			if got := requests.Load(); got != test.want {
+				t.Errorf("sent %d requests, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfterLongerThanMaxWait(t *testing.T) {
+	c, requests := flakyProxy(t, 1, http.StatusTooManyRequests, "60")
+	err := c.do(context.Background(), http.MethodGet, "/keys", nil, nil, nil)
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Minute || apiErr.RequestID != "req-1" {
+		t.Fatalf("err = %#v, want the 429 with its Retry-After and request ID", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("sent %d requests, want 1", got)
+	}
+}
+
+func TestNetworkErrors(t *testing.T) {
+	proxy := httptest.NewServer(http.NotFoundHandler())
+	proxy.Close()
+	c, err := New(proxy.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if err := c.do(context.Background(), method, "/keys", nil, nil, nil); err == nil {
+			t.Errorf("%s to a closed proxy succeeded", method)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{maxWait: time.Second}
+	for attempt, want := range map[int]time.Duration{1: 500 * time.Millisecond, 2: time.Second, 5: time.Second} {
+		if got := c.backoff(attempt, nil); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+	if got := c.backoff(1, &Error{RetryAfter: 3 * time.Second}); got != 3*time.Second {
+		t.Errorf("backoff with Retry-After = %s, want 3s", got)
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors that an *Error matches with errors.Is, by status.
+var (
+	ErrUnauthorized = errors.New("client: not authenticated")
+	ErrForbidden    = errors.New("client: not allowed")
+	ErrNotFound     = errors.New("client: not found")
+	ErrConflict     = errors.New("client: conflict")
+	ErrRateLimited  = errors.New("client: rate limited")
+	ErrUnavailable  = errors.New("client: NerdGraph unavailable")
+)
+
+// maxErrorBody bounds how much of an error response is kept.
+const maxErrorBody = 1 << 20
+
+// Error is a response from the proxy that wasn't a success.
+type Error struct {
+	StatusCode int
+	// Message is the error field of a JSON response, or the body otherwise.
+	Message string
+	// Details is the details field of a JSON response, such as the errors
+	// NerdGraph returned.
+	Details json.RawMessage
+	// RequestID identifies the request in the proxy's logs and audit log.
+	RequestID string
+	// RetryAfter is how long the proxy asked to wait, if it did.
+	RetryAfter time.Duration
+	// Body is the whole response body, for the fields specific to an
+	// endpoint, such as the key a partly failed rotation did create.
+	Body []byte
+}
+
+func newError(res *http.Response) *Error {
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	e := &Error{
+		StatusCode: res.StatusCode,
+		RequestID:  res.Header.Get("X-Request-ID"),
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		Body:       body,
+	}
+	var parsed struct {
+		Error   string          `json:"error"`
+		Details json.RawMessage `json:"details"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && parsed.Error != "" {
+		e.Message, e.Details = parsed.Error, parsed.Details
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("client: %d %s", e.StatusCode, e.Message)
+	if len(e.Details) > 0 && string(e.Details) != "null" {
+		message += ": " + string(e.Details)
+	}
+	return message
+}
+
+// Is matches the sentinel error for the status.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+// Decode unmarshals the response body into v, for fields specific to the
+// endpoint that failed.
+func (e *Error) Decode(v any) error {
+	return json.Unmarshal(e.Body, v)
+}
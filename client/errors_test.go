@@ -0,0 +1,57 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	sentinels := []error{ErrUnauthorized, ErrForbidden, ErrNotFound, ErrConflict, ErrRateLimited, ErrUnavailable}
+	for status, want := range map[int]error{
+		http.StatusUnauthorized:        ErrUnauthorized,
+		http.StatusForbidden:           ErrForbidden,
+		http.StatusNotFound:            ErrNotFound,
+		http.StatusConflict:            ErrConflict,
+		http.StatusTooManyRequests:     ErrRateLimited,
+		http.StatusBadGateway:          ErrUnavailable,
+		http.StatusServiceUnavailable:  ErrUnavailable,
+		http.StatusGatewayTimeout:      ErrUnavailable,
+		http.StatusInternalServerError: nil,
+	} {
+		err := &Error{StatusCode: status}
+		for _, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (sentinel == want) {
+				t.Errorf("errors.Is(%d, %v) = %v", status, sentinel, got)
+			}
+		}
+	}
+}
+
+func TestNewError(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusConflict,
+		Header:     http.Header{"X-Request-Id": {"req-1"}},
+		Body:       io.NopCloser(strings.NewReader(`{"error": "Key already exists", "details": [{"id": "key-1"}], "existing": "key-1"}`)),
+	}
+	err := newError(res)
+	if err.Message != "Key already exists" || err.RequestID != "req-1" {
+		t.Errorf("newError = %+v", err)
+	}
+	if got := err.Error(); got != `client: 409 Key already exists: [{"id": "key-1"}]` {
+		t.Errorf("Error() = %q", got)
+	}
+	var body struct {
+		Existing string `json:"existing"`
+	}
+	if err := err.Decode(&body); err != nil || body.Existing != "key-1" {
+		t.Errorf("Decode = %+v, %v", body, err)
+	}
+
+	res = &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("bad gateway\n"))}
+	if err := newError(res); err.Message != "bad gateway" {
+		t.Errorf("plain text message = %q", err.Message)
+	}
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is work the proxy queued, such as a bulk change or a change waiting
+// for NerdGraph. Result holds what the work returned once it succeeded.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Caller      string          `json:"caller,omitempty"`
+	Credential  string          `json:"credential,omitempty"`
+	RequestID   string          `json:"requestId,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// Done reports whether the job has finished, one way or another.
+func (j *Job) Done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// Approval is a change waiting for, or decided by, a second person.
+type Approval struct {
+	ID          string          `json:"id"`
+	Action      string          `json:"action"`
+	KeyID       string          `json:"keyId"`
+	AccountID   int             `json:"accountId"`
+	GraceHours  float64         `json:"graceHours,omitempty"`
+	Status      string          `json:"status"`
+	RequestedBy string          `json:"requestedBy"`
+	RequestedAt time.Time       `json:"requestedAt"`
+	ExpiresAt   time.Time       `json:"expiresAt"`
+	DecidedBy   string          `json:"decidedBy,omitempty"`
+	DecidedAt   *time.Time      `json:"decidedAt,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// AuditEntry is a change recorded in the audit log.
+type AuditEntry struct {
+	ID        string         `json:"id"`
+	Time      time.Time      `json:"time"`
+	Actor     string         `json:"actor"`
+	Action    string         `json:"action"`
+	AccountID int            `json:"accountId,omitempty"`
+	KeyID     string         `json:"keyId,omitempty"`
+	Outcome   string         `json:"outcome"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"requestId,omitempty"`
+}
+
+// AuditQuery filters the audit log. Zero fields match everything; an
+// Action ending in ".*" matches every action with that prefix.
+type AuditQuery struct {
+	Actor    string
+	Action   string
+	KeyID    string
+	Accounts []int
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+}
+
+// AuditPage is a page of the audit log, newest first. NextCursor is empty
+// on the last page.
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"nextCursor"`
+}
+
+// Job looks up a job.
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var result struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Job, nil
+}
+
+// WaitJob polls a job every interval, or every 2 seconds when interval is
+// 0, until it has finished or ctx is done.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	if interval <= 0 {
+		interval = defaultPollWait
+	}
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil || job.Done() {
+			return job, err
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Approval looks up an approval.
+func (c *Client) Approval(ctx context.Context, id string) (*Approval, error) {
+	return c.approval(ctx, http.MethodGet, "/approvals/"+url.PathEscape(id))
+}
+
+// Approve approves a change someone else requested, which the proxy then
+// makes as the requester.
+func (c *Client) Approve(ctx context.Context, id string) (*Approval, error) {
+	return c.approval(ctx, http.MethodPost, "/approvals/"+url.PathEscape(id)+"/approve")
+}
+
+// Reject rejects a pending change.
+func (c *Client) Reject(ctx context.Context, id string) (*Approval, error) {
+	return c.approval(ctx, http.MethodPost, "/approvals/"+url.PathEscape(id)+"/reject")
+}
+
+func (c *Client) approval(ctx context.Context, method, path string) (*Approval, error) {
+	var result struct {
+		Approval Approval `json:"approval"`
+	}
+	if err := c.do(ctx, method, path, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Approval, nil
+}
+
+// AuditLog reads a page of the audit log.
+func (c *Client) AuditLog(ctx context.Context, q AuditQuery) (*AuditPage, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"actor": q.Actor, "action": q.Action, "keyId": q.KeyID, "cursor": q.Cursor} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if len(q.Accounts) > 0 {
+		query.Set("account", joinAccounts(q.Accounts))
+	}
+	if !q.Since.IsZero() {
+		query.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		query.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var page AuditPage
+	if err := c.do(ctx, http.MethodGet, "/audit", query, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
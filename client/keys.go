@@ -0,0 +1,431 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CreateKeyRequest creates an ingest key.
+type CreateKeyRequest struct {
+	AccountID  int               `json:"account_id"`
+	Name       string            `json:"name"`
+	Notes      string            `json:"notes"`
+	IngestType string            `json:"ingestType"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// OnDuplicate overrides what the proxy does when the account already
+	// has a key of the same name and type: allow, reject or return.
+	OnDuplicate string `json:"-"`
+}
+
+// Key is a newly created ingest key, including its value.
+type Key struct {
+	ID         string `json:"id"`
+	Key        string `json:"key"`
+	Name       string `json:"name"`
+	Notes      string `json:"notes"`
+	Type       string `json:"type"`
+	IngestType string `json:"ingestType"`
+}
+
+// IngestKey is an existing ingest key, with the labels the proxy keeps for
+// it.
+type IngestKey struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Notes      string            `json:"notes"`
+	AccountID  int               `json:"accountId"`
+	IngestType string            `json:"ingestType"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// KeyRecord is what the proxy keeps about a key besides NerdGraph.
+type KeyRecord struct {
+	ID        string            `json:"id"`
+	AccountID int               `json:"accountId"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	CreatedBy string            `json:"createdBy,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// CreateKeyResult is the answer to CreateKey. Existing is set when the
+// proxy returned a key of the same name instead of creating one, in which
+// case Key has no value.
+type CreateKeyResult struct {
+	Key      *Key `json:"insert_key"`
+	Existing bool `json:"existing"`
+	Accepted
+}
+
+// DeleteResult is the answer to a deletion: DeletedKey when the key is
+// gone, or ScheduledKey with the job and the token that cancels it while a
+// grace period runs.
+type DeleteResult struct {
+	DeletedKey        string    `json:"deleted_key"`
+	ScheduledKey      string    `json:"scheduled_key"`
+	DeleteAt          time.Time `json:"delete_at"`
+	CancellationToken string    `json:"cancellation_token"`
+	Accepted
+}
+
+// RotateResult is the answer to a rotation.
+type RotateResult struct {
+	Key        *Key   `json:"insert_key"`
+	DeletedKey string `json:"deleted_key"`
+	Accepted
+}
+
+// SearchOptions is a key search.
+type SearchOptions struct {
+	Query    string
+	Accounts []int
+	Fuzzy    bool
+	// Refresh reloads the accounts' keys instead of using the cached index.
+	Refresh bool
+}
+
+// SearchResult is a key matching a search, best matches first.
+type SearchResult struct {
+	IngestKey
+	Matched string `json:"matched"`
+	Score   int    `json:"score"`
+}
+
+// KeyPage is a page of keys. NextCursor is empty on the last page.
+type KeyPage struct {
+	Keys       []IngestKey `json:"keys"`
+	NextCursor string      `json:"nextCursor"`
+}
+
+// SearchPage is a page of search results. NextCursor is empty on the last
+// page.
+type SearchPage struct {
+	Keys       []SearchResult `json:"keys"`
+	NextCursor string         `json:"nextCursor"`
+}
+
+// ValidateKeyRequest checks a key value against NerdGraph.
+type ValidateKeyRequest struct {
+	Key      string `json:"key"`
+	Type     string `json:"type,omitempty"`
+	Accounts []int  `json:"accounts,omitempty"`
+}
+
+// KeyValidation is the outcome of ValidateKey.
+type KeyValidation struct {
+	Valid      bool   `json:"valid"`
+	Type       string `json:"type"`
+	ID         string `json:"id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	AccountID  int    `json:"account_id,omitempty"`
+	IngestType string `json:"ingestType,omitempty"`
+	UserEmail  string `json:"user_email,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// FanOutRequest creates the same key in several accounts.
+type FanOutRequest struct {
+	Accounts   []int  `json:"accounts"`
+	Name       string `json:"name"`
+	Notes      string `json:"notes"`
+	IngestType string `json:"ingestType"`
+}
+
+// BulkRequest names the keys of a bulk change by ID or by label selector,
+// such as "team=payments,env!=prod".
+type BulkRequest struct {
+	IDs      []string `json:"ids,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+}
+
+// ImportResult is the outcome of ImportKeys, row by row.
+type ImportResult struct {
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// ImportRow is the outcome of one row of an import.
+type ImportRow struct {
+	Row    int      `json:"row"`
+	Status string   `json:"status"`
+	Key    *Key     `json:"insert_key,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// joinAccounts formats account IDs for a query string.
+func joinAccounts(accounts []int) string {
+	ids := make([]string, len(accounts))
+	for i, id := range accounts {
+		ids[i] = strconv.Itoa(id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// CreateKey creates an ingest key.
+func (c *Client) CreateKey(ctx context.Context, request CreateKeyRequest) (*CreateKeyResult, error) {
+	query := url.Values{}
+	if request.OnDuplicate != "" {
+		query.Set("onDuplicate", request.OnDuplicate)
+	}
+	var result CreateKeyResult
+	if err := c.do(ctx, http.MethodPost, "/createKey", query, request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListKeys lists the keys of the accounts, optionally only those whose
+// labels match selector, following every page.
+func (c *Client) ListKeys(ctx context.Context, accounts []int, selector string) ([]IngestKey, error) {
+	var keys []IngestKey
+	page := PageOptions{Limit: maxPageSize}
+	for {
+		result, err := c.ListKeysPage(ctx, accounts, selector, page)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, result.Keys...)
+		if result.NextCursor == "" {
+			return keys, nil
+		}
+		page.Cursor = result.NextCursor
+	}
+}
+
+// ListKeysPage lists a page of the keys of the accounts, sorted by ID.
+func (c *Client) ListKeysPage(ctx context.Context, accounts []int, selector string, page PageOptions) (*KeyPage, error) {
+	query := page.query(url.Values{"account": {joinAccounts(accounts)}})
+	if selector != "" {
+		query.Set("selector", selector)
+	}
+	var result KeyPage
+	if err := c.do(ctx, http.MethodGet, "/keys", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SearchKeys searches key names and notes, following every page.
+func (c *Client) SearchKeys(ctx context.Context, options SearchOptions) ([]SearchResult, error) {
+	var results []SearchResult
+	page := PageOptions{Limit: maxPageSize}
+	for {
+		result, err := c.SearchKeysPage(ctx, options, page)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result.Keys...)
+		if result.NextCursor == "" {
+			return results, nil
+		}
+		// Only the first page may refresh the index, or the results could
+		// shift under the cursor.
+		options.Refresh = false
+		page.Cursor = result.NextCursor
+	}
+}
+
+// SearchKeysPage reads a page of the keys matching a search.
+func (c *Client) SearchKeysPage(ctx context.Context, options SearchOptions, page PageOptions) (*SearchPage, error) {
+	query := page.query(url.Values{"q": {options.Query}, "account": {joinAccounts(options.Accounts)}})
+	if options.Fuzzy {
+		query.Set("fuzzy", "true")
+	}
+	if options.Refresh {
+		query.Set("refresh", "true")
+	}
+	var result SearchPage
+	if err := c.do(ctx, http.MethodGet, "/keys/search", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DeleteKey deletes a key, after the proxy's default grace period if one is
+// configured.
+func (c *Client) DeleteKey(ctx context.Context, id string) (*DeleteResult, error) {
+	return c.deleteKey(ctx, "/keys/"+url.PathEscape(id), url.Values{})
+}
+
+// DeleteKeyAfter deletes a key once grace has passed, or straight away when
+// grace is 0.
+func (c *Client) DeleteKeyAfter(ctx context.Context, id string, grace time.Duration) (*DeleteResult, error) {
+	return c.deleteKey(ctx, "/keys/"+url.PathEscape(id), graceQuery(url.Values{}, grace))
+}
+
+// DeleteKeyByName deletes the one key in the account with the name, and
+// of the ingest type when it isn't "". It fails with ErrConflict when
+// several keys match.
+func (c *Client) DeleteKeyByName(ctx context.Context, account int, name, ingestType string) (*DeleteResult, error) {
+	return c.deleteKey(ctx, "/accounts/"+strconv.Itoa(account)+"/keys", nameQuery(name, ingestType))
+}
+
+func (c *Client) deleteKey(ctx context.Context, path string, query url.Values) (*DeleteResult, error) {
+	var result DeleteResult
+	if err := c.do(ctx, http.MethodDelete, path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func graceQuery(query url.Values, grace time.Duration) url.Values {
+	query.Set("grace_hours", strconv.FormatFloat(grace.Hours(), 'f', -1, 64))
+	return query
+}
+
+func nameQuery(name, ingestType string) url.Values {
+	query := url.Values{"name": {name}}
+	if ingestType != "" {
+		query.Set("ingestType", ingestType)
+	}
+	return query
+}
+
+// UndeleteKey cancels a scheduled deletion with the token DeleteKey
+// returned.
+func (c *Client) UndeleteKey(ctx context.Context, id, token string) error {
+	err := c.do(ctx, http.MethodPost, "/keys/"+url.PathEscape(id)+"/undelete", nil, map[string]string{"token": token}, nil)
+	return err
+}
+
+// RotateKey replaces a key with a new one of the same account, name, notes
+// and type, then deletes it.
+func (c *Client) RotateKey(ctx context.Context, id string) (*RotateResult, error) {
+	return c.rotateKey(ctx, "/keys/"+url.PathEscape(id)+"/rotate", nil)
+}
+
+// RotateKeyByName rotates the one key in the account with the name, and of
+// the ingest type when it isn't "".
+func (c *Client) RotateKeyByName(ctx context.Context, account int, name, ingestType string) (*RotateResult, error) {
+	return c.rotateKey(ctx, "/accounts/"+strconv.Itoa(account)+"/keys/rotate", nameQuery(name, ingestType))
+}
+
+func (c *Client) rotateKey(ctx context.Context, path string, query url.Values) (*RotateResult, error) {
+	var result RotateResult
+	if err := c.do(ctx, http.MethodPost, path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SetKeyLabels replaces the labels of a key.
+func (c *Client) SetKeyLabels(ctx context.Context, id string, labels map[string]string) (*KeyRecord, error) {
+	return c.labelKey(ctx, http.MethodPut, id, labels)
+}
+
+// UpdateKeyLabels merges labels into those of a key; a nil value removes
+// the label.
+func (c *Client) UpdateKeyLabels(ctx context.Context, id string, labels map[string]*string) (*KeyRecord, error) {
+	return c.labelKey(ctx, http.MethodPatch, id, labels)
+}
+
+func (c *Client) labelKey(ctx context.Context, method, id string, labels any) (*KeyRecord, error) {
+	var result struct {
+		Key KeyRecord `json:"key"`
+	}
+	if err := c.do(ctx, method, "/keys/"+url.PathEscape(id)+"/labels", nil, map[string]any{"labels": labels}, &result); err != nil {
+		return nil, err
+	}
+	return &result.Key, nil
+}
+
+// TransferKey makes owner the owner of a key, returning its record and
+// updated notes.
+func (c *Client) TransferKey(ctx context.Context, id, owner string) (*KeyRecord, string, error) {
+	var result struct {
+		Key   KeyRecord `json:"key"`
+		Notes string    `json:"notes"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/keys/"+url.PathEscape(id)+"/transfer", nil, map[string]string{"owner": owner}, &result); err != nil {
+		return nil, "", err
+	}
+	return &result.Key, result.Notes, nil
+}
+
+// ValidateKey checks whether a key value is live, and what it belongs to.
+func (c *Client) ValidateKey(ctx context.Context, request ValidateKeyRequest) (*KeyValidation, error) {
+	var result KeyValidation
+	if err := c.do(ctx, http.MethodPost, "/keys/validate", nil, request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FanOutKey creates the same key in several accounts, as a job.
+func (c *Client) FanOutKey(ctx context.Context, request FanOutRequest) (*Job, error) {
+	var result Accepted
+	if err := c.do(ctx, http.MethodPost, "/keys/fanout", nil, request, &result); err != nil {
+		return nil, err
+	}
+	return result.Job, nil
+}
+
+// DeleteKeys deletes several keys, as a job or, when approvals are
+// required, as an approval per key.
+func (c *Client) DeleteKeys(ctx context.Context, request BulkRequest) (*Accepted, error) {
+	return c.bulk(ctx, "/keys/delete", request)
+}
+
+// RotateKeys rotates several keys, as a job or, when approvals are
+// required, as an approval per key.
+func (c *Client) RotateKeys(ctx context.Context, request BulkRequest) (*Accepted, error) {
+	return c.bulk(ctx, "/keys/rotate", request)
+}
+
+func (c *Client) bulk(ctx context.Context, path string, request BulkRequest) (*Accepted, error) {
+	var result Accepted
+	if err := c.do(ctx, http.MethodPost, path, nil, request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ImportKeys creates keys from a list. Nothing is created unless every row
+// is valid; the returned *Error then holds the problems per row.
+func (c *Client) ImportKeys(ctx context.Context, rows []CreateKeyRequest) (*ImportResult, error) {
+	var result ImportResult
+	if err := c.do(ctx, http.MethodPost, "/keys/import", nil, rows, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ImportKeysCSV creates keys from a CSV file with account_id, name, notes,
+// ingestType and labels columns.
+func (c *Client) ImportKeysCSV(ctx context.Context, csv []byte) (*ImportResult, error) {
+	var result ImportResult
+	if err := c.send(ctx, http.MethodPost, "/keys/import", nil, "text/csv", csv, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// LicenseKey is a LICENSE ingest key with its value.
+type LicenseKey struct {
+	IngestKey
+	Key string `json:"key"`
+}
+
+// AccountLicenseKeys is what agents of an account can report with.
+type AccountLicenseKeys struct {
+	AccountID  int          `json:"accountId"`
+	LicenseKey string       `json:"licenseKey"`
+	IngestKeys []LicenseKey `json:"ingestKeys"`
+}
+
+// LicenseKeys returns the account's original license key and its LICENSE
+// ingest keys, with their values.
+func (c *Client) LicenseKeys(ctx context.Context, account int) (*AccountLicenseKeys, error) {
+	var keys AccountLicenseKeys
+	if err := c.do(ctx, http.MethodGet, "/accounts/"+strconv.Itoa(account)+"/licenseKeys", nil, nil, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
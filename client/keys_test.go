@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// pagedProxy serves keys one page per cursor and records the queries.
+func pagedProxy(t *testing.T) (*Client, *[]url.Values) {
+	t.Helper()
+	var queries []url.Values
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"keys": [{"id": "a"}, {"id": "b"}], "nextCursor": "page-2"}`))
+		case "page-2":
+			w.Write([]byte(`{"keys": [{"id": "c"}]}`))
+		default:
+			http.Error(w, `{"error": "invalid cursor"}`, http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(proxy.Close)
+	c, err := New(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &queries
+}
+
+func TestListKeysFollowsPages(t *testing.T) {
+	c, queries := pagedProxy(t)
+	keys, err := c.ListKeys(context.Background(), []int{1, 2}, "team=payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[2].ID != "c" {
+		t.Errorf("keys = %+v, want a, b and c", keys)
+	}
+	for _, query := range *queries {
+		if query.Get("account") != "1,2" || query.Get("selector") != "team=payments" || query.Get("limit") != "1000" {
+			t.Errorf("query = %v, want the same filter on every page", query)
+		}
+	}
+}
+
+func TestSearchKeysRefreshesOnlyTheFirstPage(t *testing.T) {
+	c, queries := pagedProxy(t)
+	results, err := c.SearchKeys(context.Background(), SearchOptions{Query: "pay", Accounts: []int{1}, Refresh: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("got %d results, want 3", len(results))
+	}
+	if len(*queries) != 2 || (*queries)[0].Get("refresh") != "true" || (*queries)[1].Has("refresh") {
+		t.Errorf("queries = %v, want refresh on the first page only", *queries)
+	}
+}
+
+func TestListKeysPage(t *testing.T) {
+	c, queries := pagedProxy(t)
+	page, err := c.ListKeysPage(context.Background(), []int{1}, "", PageOptions{Limit: 2, Cursor: "page-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Keys) != 1 || page.NextCursor != "" {
+		t.Errorf("page = %+v, want the last page", page)
+	}
+	if query := (*queries)[0]; query.Get("limit") != "2" || query.Get("cursor") != "page-2" {
+		t.Errorf("query = %v", query)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAccessLogMaxMB    = 100
+	defaultAccessLogMaxFiles = 5
+)
+
+// AccessLogEntry is one line of the access log.
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	Caller    string    `json:"caller,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	Remote    string    `json:"remote,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// accessLog writes an AccessLogEntry as a JSON line for every request. It
+// is kept apart from the application log so it can be shipped on its own.
+type accessLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// newAccessLogFromEnv returns nil when ACCESS_LOG is unset, turning access
+// logging off.
+func newAccessLogFromEnv() (*accessLog, error) {
+	switch target := os.Getenv("ACCESS_LOG"); target {
+	case "":
+		return nil, nil
+	case "stdout":
+		return &accessLog{out: os.Stdout}, nil
+	default:
+		maxMB, maxFiles := defaultAccessLogMaxMB, defaultAccessLogMaxFiles
+		if v := os.Getenv("ACCESS_LOG_MAX_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid ACCESS_LOG_MAX_MB %q", v)
+			}
+			maxMB = n
+		}
+		if v := os.Getenv("ACCESS_LOG_MAX_FILES"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid ACCESS_LOG_MAX_FILES %q", v)
+			}
+			maxFiles = n
+		}
+		file, err := openRotatingFile(target, int64(maxMB)<<20, maxFiles)
+		if err != nil {
+			return nil, err
+		}
+		return &accessLog{out: file}, nil
+	}
+}
+
+// accessRecord collects what the access log needs to know from further
+// down the chain, such as who the caller turned out to be.
+type accessRecord struct {
+	caller string
+}
+
+type accessRecordKey struct{}
+
+// setAccessCaller names the caller of the request in its access log entry.
+func setAccessCaller(ctx context.Context, name string) {
+	if record, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		record.caller = name
+	}
+}
+
+// Middleware logs every request passed to next. It wraps the whole router
+// so unmatched routes and rejected tokens are logged too.
+func (l *accessLog) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		record := &accessRecord{}
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, record)))
+
+		remote, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remote = r.RemoteAddr
+		}
+		l.write(AccessLogEntry{
+			Time:      start.UTC(),
+			Method:    r.Method,
+			Path:      secrets.Redact(r.URL.Path),
+			Status:    rw.status,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Caller:    record.caller,
+			Bytes:     rw.bytes,
+			Remote:    remote,
+			RequestID: requestIDFromContext(r.Context()),
+		})
+	})
+}
+
+func (l *accessLog) write(entry AccessLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+// Close flushes the access log and closes its file. Requests that still come
+// in afterwards are not logged.
+func (l *accessLog) Close(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := l.out
+	l.out = io.Discard
+	if file, ok := out.(*rotatingFile); ok {
+		return file.Close()
+	}
+	return nil
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// rotatingFile is a log file that is moved aside to path.1, path.2 and so on
+// once it reaches maxSize, keeping at most maxFiles old files.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write is not safe for concurrent use; accessLog serialises the writes.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxFiles == 0 {
+		os.Remove(f.path)
+	}
+	for i := f.maxFiles; i > 0; i-- {
+		from := f.path
+		if i > 1 {
+			from = f.path + "." + strconv.Itoa(i-1)
+		}
+		if err := os.Rename(from, f.path+"."+strconv.Itoa(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
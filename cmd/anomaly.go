@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultAnomalyDirection   = "UPPER_AND_LOWER"
+	defaultAnomalySensitivity = 3
+	defaultAnomalyDuration    = 300
+)
+
+var baselineDirections = map[string]bool{"UPPER_ONLY": true, "LOWER_ONLY": true, "UPPER_AND_LOWER": true}
+
+// AnomalyDetector configures anomaly detection on a query: an incident opens
+// when the query strays from its baseline by Sensitivity standard deviations
+// for DurationSeconds. NerdGraph keeps it as a baseline NRQL condition.
+type AnomalyDetector struct {
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	RunbookURL         string   `json:"runbookUrl"`
+	NRQL               string   `json:"nrql"`
+	Direction          string   `json:"direction"`
+	Sensitivity        float64  `json:"sensitivity"`
+	WarningSensitivity *float64 `json:"warningSensitivity"`
+	DurationSeconds    int      `json:"durationSeconds"`
+	Enabled            *bool    `json:"enabled"`
+}
+
+// AnomalyDetectorUpdate changes the baseline settings of a detector. Unset
+// fields are left as they are; the terms are replaced only when Sensitivity
+// is set.
+type AnomalyDetectorUpdate struct {
+	Direction          string   `json:"direction"`
+	Sensitivity        *float64 `json:"sensitivity"`
+	WarningSensitivity *float64 `json:"warningSensitivity"`
+	DurationSeconds    int      `json:"durationSeconds"`
+	Enabled            *bool    `json:"enabled"`
+}
+
+// validateDirection checks a baseline direction.
+func validateDirection(direction string) error {
+	if !baselineDirections[direction] {
+		return fmt.Errorf("direction must be UPPER_ONLY, LOWER_ONLY or UPPER_AND_LOWER")
+	}
+	return nil
+}
+
+// validateSensitivity checks the thresholds shared by creating and updating
+// a detector.
+func validateSensitivity(sensitivity float64, warning *float64, duration int) error {
+	switch {
+	case sensitivity <= 0:
+		return fmt.Errorf("sensitivity must be positive")
+	case warning != nil && (*warning <= 0 || *warning >= sensitivity):
+		return fmt.Errorf("warningSensitivity must be positive and below sensitivity")
+	case duration <= 0 || duration%60 != 0:
+		return fmt.Errorf("durationSeconds must be a multiple of 60")
+	}
+	return nil
+}
+
+// anomalyTerms are the terms of a detector: a CRITICAL one at sensitivity,
+// and a WARNING one when a warning sensitivity is set.
+func anomalyTerms(sensitivity float64, warning *float64, duration int) []NrqlConditionTerm {
+	terms := []NrqlConditionTerm{{
+		Operator:             "ABOVE",
+		Priority:             "CRITICAL",
+		Threshold:            sensitivity,
+		ThresholdDuration:    duration,
+		ThresholdOccurrences: "ALL",
+	}}
+	if warning != nil {
+		terms = append(terms, NrqlConditionTerm{
+			Operator:             "ABOVE",
+			Priority:             "WARNING",
+			Threshold:            *warning,
+			ThresholdDuration:    duration,
+			ThresholdOccurrences: "ALL",
+		})
+	}
+	return terms
+}
+
+// condition fills in the defaults and turns the detector into the baseline
+// condition request that creates it.
+func (detector *AnomalyDetector) condition() (NrqlConditionRequest, error) {
+	if detector.Direction == "" {
+		detector.Direction = defaultAnomalyDirection
+	}
+	detector.Direction = strings.ToUpper(detector.Direction)
+	if detector.Sensitivity == 0 {
+		detector.Sensitivity = defaultAnomalySensitivity
+	}
+	if detector.DurationSeconds == 0 {
+		detector.DurationSeconds = defaultAnomalyDuration
+	}
+	switch {
+	case detector.Name == "":
+		return NrqlConditionRequest{}, fmt.Errorf("name is required")
+	case strings.TrimSpace(detector.NRQL) == "":
+		return NrqlConditionRequest{}, fmt.Errorf("nrql is required")
+	}
+	if err := validateDirection(detector.Direction); err != nil {
+		return NrqlConditionRequest{}, err
+	}
+	if err := validateSensitivity(detector.Sensitivity, detector.WarningSensitivity, detector.DurationSeconds); err != nil {
+		return NrqlConditionRequest{}, err
+	}
+	return NrqlConditionRequest{
+		Type: conditionBaseline,
+		Condition: NrqlCondition{
+			Name:              detector.Name,
+			Description:       detector.Description,
+			Enabled:           detector.Enabled == nil || *detector.Enabled,
+			RunbookURL:        detector.RunbookURL,
+			NRQL:              NrqlQuery{Query: detector.NRQL},
+			Terms:             anomalyTerms(detector.Sensitivity, detector.WarningSensitivity, detector.DurationSeconds),
+			BaselineDirection: detector.Direction,
+		},
+	}, nil
+}
+
+// input is the partial update input for the detector's baseline condition.
+func (update *AnomalyDetectorUpdate) input() (map[string]any, error) {
+	input := map[string]any{}
+	if update.Direction != "" {
+		update.Direction = strings.ToUpper(update.Direction)
+		if err := validateDirection(update.Direction); err != nil {
+			return nil, err
+		}
+		input["baselineDirection"] = update.Direction
+	}
+	if update.Enabled != nil {
+		input["enabled"] = *update.Enabled
+	}
+	if update.Sensitivity == nil && (update.WarningSensitivity != nil || update.DurationSeconds != 0) {
+		return nil, fmt.Errorf("sensitivity is required to change warningSensitivity or durationSeconds")
+	}
+	if update.Sensitivity != nil {
+		if update.DurationSeconds == 0 {
+			update.DurationSeconds = defaultAnomalyDuration
+		}
+		if err := validateSensitivity(*update.Sensitivity, update.WarningSensitivity, update.DurationSeconds); err != nil {
+			return nil, err
+		}
+		input["terms"] = anomalyTerms(*update.Sensitivity, update.WarningSensitivity, update.DurationSeconds)
+	}
+	if len(input) == 0 {
+		return nil, fmt.Errorf("nothing to update")
+	}
+	return input, nil
+}
+
+// Create an anomaly detector in a policy
+func (s *Server) createAnomalyDetectorHandler(w http.ResponseWriter, r *http.Request) {
+	policyID := mux.Vars(r)["policy"]
+	log.Printf("Received request to create an anomaly detector in policy %s", policyID)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	var detector AnomalyDetector
+	if err := json.NewDecoder(r.Body).Decode(&detector); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	request, err := detector.condition()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid request: "+err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.createNrqlCondition(r.Context(), accountID, policyID, request)
+	if err != nil {
+		writeError(w, "Failed to create anomaly detector", err)
+		return
+	}
+	log.Printf("Successfully created anomaly detector: ID=%s, Name=%s", created.ID, created.Name)
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "condition.create",
+		AccountID: accountID,
+		Details: map[string]any{
+			"condition": created.ID, "policy": policyID, "name": created.Name, "type": conditionBaseline,
+			"direction": detector.Direction, "sensitivity": detector.Sensitivity,
+		},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"condition": created})
+}
+
+// Change the baseline settings of an anomaly detector
+func (s *Server) updateAnomalyDetectorHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to update anomaly detector %s", id)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	var update AnomalyDetectorUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	input, err := update.input()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid request: "+err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.runConditionUpdate(r.Context(), accountID, id, conditionBaseline, input)
+	if err != nil {
+		writeError(w, "Failed to update anomaly detector", err)
+		return
+	}
+	details := map[string]any{"condition": id, "name": updated.Name, "type": conditionBaseline, "enabled": updated.Enabled}
+	if update.Direction != "" {
+		details["direction"] = update.Direction
+	}
+	if update.Sensitivity != nil {
+		details["sensitivity"] = *update.Sensitivity
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "condition.update",
+		AccountID: accountID,
+		Details:   details,
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"condition": updated})
+}
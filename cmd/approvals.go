@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	approvalPending  = "pending"
+	approvalApproved = "approved"
+	approvalRejected = "rejected"
+	approvalExpired  = "expired"
+
+	defaultApprovalTTL = 24 * time.Hour
+)
+
+// Approval is a destructive change waiting for a second person to sign it
+// off before it is carried out.
+type Approval struct {
+	ID        string `json:"id"`
+	Action    string `json:"action"`
+	KeyID     string `json:"keyId"`
+	AccountID int    `json:"accountId"`
+	// GraceHours is how long an approved delete waits before it runs, as
+	// the request asked or DELETE_GRACE_HOURS set.
+	GraceHours  float64    `json:"graceHours,omitempty"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requestedBy"`
+	RequestedAt time.Time  `json:"requestedAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	DecidedBy   string     `json:"decidedBy,omitempty"`
+	DecidedAt   *time.Time `json:"decidedAt,omitempty"`
+	Result      any        `json:"result,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+type approvalStore struct {
+	path string
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	approvals map[string]*Approval
+}
+
+func newApprovalStore(path string) (*approvalStore, error) {
+	ttl := defaultApprovalTTL
+	if v := os.Getenv("APPROVAL_TTL_HOURS"); v != "" {
+		hours, err := strconv.ParseFloat(v, 64)
+		if err != nil || hours <= 0 {
+			return nil, fmt.Errorf("invalid APPROVAL_TTL_HOURS %q", v)
+		}
+		ttl = time.Duration(hours * float64(time.Hour))
+	}
+	s := &approvalStore{path: path, ttl: ttl, approvals: map[string]*Approval{}}
+	if err := loadSealed(path, &s.approvals); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *approvalStore) Create(approval *Approval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.approvals[approval.ID] = approval
+	return saveSealed(s.path, s.approvals)
+}
+
+func (s *approvalStore) Get(id string) *Approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval, ok := s.approvals[id]
+	if !ok {
+		return nil
+	}
+	c := *approval
+	return &c
+}
+
+// Decide moves a pending approval to its final status. It returns nil when
+// the approval is no longer pending, so two approvers can't both act on it.
+func (s *approvalStore) Decide(id, status, actor string) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval, ok := s.approvals[id]
+	if !ok || approval.Status != approvalPending {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	approval.Status = status
+	approval.DecidedBy = actor
+	approval.DecidedAt = &now
+	c := *approval
+	return &c, saveSealed(s.path, s.approvals)
+}
+
+// Finish stores the outcome of carrying out an approved change.
+func (s *approvalStore) Finish(id string, result any, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval, ok := s.approvals[id]
+	if !ok {
+		return
+	}
+	approval.Result = result
+	if err != nil {
+		approval.Error = secrets.Redact(err.Error())
+	}
+	if err := saveSealed(s.path, s.approvals); err != nil {
+		log.Printf("Failed to save approvals: %v", err)
+	}
+}
+
+// Expire marks pending approvals past their expiry and returns them.
+func (s *approvalStore) Expire() []*Approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	var expired []*Approval
+	for _, approval := range s.approvals {
+		if approval.Status == approvalPending && now.After(approval.ExpiresAt) {
+			approval.Status = approvalExpired
+			approval.DecidedAt = &now
+			c := *approval
+			expired = append(expired, &c)
+		}
+	}
+	if len(expired) > 0 {
+		if err := saveSealed(s.path, s.approvals); err != nil {
+			log.Printf("Failed to save approvals: %v", err)
+		}
+	}
+	return expired
+}
+
+// expireApprovals is run in the background so expired requests are audited
+// even if nobody looks at them again.
+func (s *Server) expireApprovals(ctx context.Context) {
+	for _, approval := range s.approvals.Expire() {
+		log.Printf("Approval %s for %s of key %s expired", approval.ID, approval.Action, approval.KeyID)
+		s.audit.Record(ctx, AuditEntry{
+			Actor:     "system",
+			Action:    "approval.expire",
+			AccountID: approval.AccountID,
+			KeyID:     approval.KeyID,
+			Details:   map[string]any{"approval": approval.ID, "requestedBy": approval.RequestedBy},
+		})
+	}
+}
+
+// requestApproval records a pending delete or rotate of a key by the caller
+// in ctx. An approved delete is scheduled after grace, like one that needs
+// no approval.
+func (s *Server) requestApproval(ctx context.Context, action, keyID string, grace time.Duration) (*Approval, error) {
+	key, err := s.getIngestKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccount(ctx, key.AccountID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	approval := &Approval{
+		ID:          newID(),
+		Action:      action,
+		KeyID:       keyID,
+		AccountID:   key.AccountID,
+		GraceHours:  grace.Hours(),
+		Status:      approvalPending,
+		RequestedBy: actorName(ctx),
+		RequestedAt: now,
+		ExpiresAt:   now.Add(s.approvals.ttl),
+	}
+	if err := s.approvals.Create(approval); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Action:    "approval.request",
+		AccountID: key.AccountID,
+		KeyID:     keyID,
+		Details:   map[string]any{"approval": approval.ID, "operation": action},
+	})
+	log.Printf("%s of key %s by %s is waiting for approval %s", action, keyID, approval.RequestedBy, approval.ID)
+	return approval, nil
+}
+
+// requireApproval queues a delete or rotate for sign-off when approval mode
+// is on. It returns true when the request has been answered that way and the
+// handler must not carry out the change itself.
+func (s *Server) requireApproval(w http.ResponseWriter, r *http.Request, action, keyID string, grace time.Duration) bool {
+	if s.approvals == nil {
+		return false
+	}
+	approval, err := s.requestApproval(r.Context(), action, keyID, grace)
+	if err != nil {
+		writeError(w, "Failed to request approval", err)
+		return true
+	}
+	w.Header().Set("Location", "/approvals/"+approval.ID)
+	writeJSON(w, http.StatusAccepted, map[string]any{"approval": approval})
+	return true
+}
+
+// requireApprovals requests a separate sign-off for each key of a bulk
+// change.
+func (s *Server) requireApprovals(w http.ResponseWriter, r *http.Request, action string, keyIDs []string) {
+	approvals := make([]*Approval, 0, len(keyIDs))
+	for _, id := range keyIDs {
+		approval, err := s.requestApproval(r.Context(), action, id, 0)
+		if err != nil {
+			writeError(w, "Failed to request approval for key "+id, err)
+			return
+		}
+		approvals = append(approvals, approval)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"approvals": approvals})
+}
+
+// Get an approval
+func (s *Server) getApproval(w http.ResponseWriter, r *http.Request) {
+	approval := s.approvals.Get(mux.Vars(r)["id"])
+	if approval == nil {
+		http.Error(w, `{"error": "Approval not found"}`, http.StatusNotFound)
+		return
+	}
+	if !authorizeAccount(w, r, approval.AccountID) {
+		return
+	}
+	// The result of a rotation holds the new key, which only the
+	// requester gets to see.
+	if approval.RequestedBy != actorName(r.Context()) {
+		approval.Result = nil
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"approval": approval})
+}
+
+// Approve a pending change and carry it out
+func (s *Server) approveChange(w http.ResponseWriter, r *http.Request) {
+	s.decideChange(w, r, approvalApproved)
+}
+
+// Reject a pending change
+func (s *Server) rejectChange(w http.ResponseWriter, r *http.Request) {
+	s.decideChange(w, r, approvalRejected)
+}
+
+func (s *Server) decideChange(w http.ResponseWriter, r *http.Request, status string) {
+	id := mux.Vars(r)["id"]
+	actor := actorName(r.Context())
+	log.Printf("Received request from %s to mark approval %s %s", actor, id, status)
+
+	approval := s.approvals.Get(id)
+	if approval == nil {
+		http.Error(w, `{"error": "Approval not found"}`, http.StatusNotFound)
+		return
+	}
+	if approval.Status == approvalPending && time.Now().After(approval.ExpiresAt) {
+		s.expireApprovals(r.Context())
+		approval.Status = approvalExpired
+	}
+	if approval.Status != approvalPending {
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":  "Approval is no longer pending",
+			"status": approval.Status,
+		})
+		return
+	}
+	if status == approvalApproved && approval.RequestedBy == actor {
+		log.Printf("%s tried to approve their own change %s, Status Code: %d", actor, id, http.StatusForbidden)
+		http.Error(w, `{"error": "A change must be approved by someone other than the requester"}`, http.StatusForbidden)
+		return
+	}
+	if !authorizeAccount(w, r, approval.AccountID) {
+		return
+	}
+
+	decided, err := s.approvals.Decide(id, status, actor)
+	if err != nil {
+		log.Printf("Failed to save approval: %v", err)
+	}
+	if decided == nil {
+		http.Error(w, `{"error": "Approval is no longer pending"}`, http.StatusConflict)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "approval." + map[string]string{approvalApproved: "approve", approvalRejected: "reject"}[status],
+		AccountID: decided.AccountID,
+		KeyID:     decided.KeyID,
+		Details:   map[string]any{"approval": id, "operation": decided.Action, "requestedBy": decided.RequestedBy},
+	})
+	if status == approvalRejected {
+		writeJSON(w, http.StatusOK, map[string]any{"approval": decided})
+		return
+	}
+
+	// The change is carried out as the requester, so it runs with their
+	// credentials and account restrictions.
+	ctx := context.WithoutCancel(r.Context())
+	caller := s.auth.callerByName(decided.RequestedBy)
+	if caller != nil {
+		ctx = context.WithValue(ctx, callerKey{}, caller)
+	}
+	var result any
+	switch {
+	case caller == nil:
+		err = fmt.Errorf("requester %s is no longer allowed to make changes: %w", decided.RequestedBy, errForbidden)
+	case decided.Action == "delete" && decided.GraceHours > 0:
+		result, err = s.scheduleApprovedDelete(ctx, decided)
+	case decided.Action == "delete":
+		err = s.deleteKey(ctx, decided.KeyID)
+		result = map[string]any{"deleted_key": decided.KeyID}
+	case decided.Action == "rotate":
+		var newKey *CreatedKey
+		newKey, err = s.rotateKey(ctx, decided.KeyID)
+		if newKey != nil {
+			result = map[string]any{"insert_key": newKey, "deleted_key": decided.KeyID}
+		}
+	default:
+		err = errors.New("unknown action " + decided.Action)
+	}
+	s.approvals.Finish(id, result, err)
+	decided = s.approvals.Get(id)
+	decided.Result = nil
+
+	if err != nil {
+		writeError(w, "Approved change failed", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"approval": decided})
+}
+
+// scheduleApprovedDelete queues an approved delete to run once its grace
+// period has passed. The cancellation token goes in the result, which only
+// the requester gets to read.
+func (s *Server) scheduleApprovedDelete(ctx context.Context, approval *Approval) (any, error) {
+	if job, _ := s.pendingDelete(approval.KeyID); job != nil {
+		return map[string]any{"scheduled_key": approval.KeyID, "delete_at": job.RunAt, "job": job.ID}, nil
+	}
+	job, token, err := s.scheduleDelete(ctx, approval.KeyID, time.Duration(approval.GraceHours*float64(time.Hour)))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"scheduled_key":      approval.KeyID,
+		"delete_at":          job.RunAt,
+		"cancellation_token": token,
+		"job":                job.ID,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	alice    = &Caller{Name: "alice"}
+	bob      = &Caller{Name: "bob"}
+	outsider = &Caller{Name: "outsider", Accounts: []int{2}}
+)
+
+// newApprovalServer is a key server that needs a second person to sign off
+// deletes of key-1.
+func newApprovalServer(t *testing.T) *keyServer {
+	t.Helper()
+	ks := newKeyServer(t)
+	approvals, err := newApprovalStore(filepath.Join(t.TempDir(), "approvals.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.approvals = approvals
+	ks.auth = &authenticator{byName: map[string]*Caller{alice.Name: alice, bob.Name: bob, outsider.Name: outsider}}
+	ks.router.HandleFunc("/approvals/{id}", ks.getApproval).Methods("GET")
+	ks.router.HandleFunc("/approvals/{id}/approve", ks.approveChange).Methods("POST")
+	ks.router.HandleFunc("/approvals/{id}/reject", ks.rejectChange).Methods("POST")
+	return ks
+}
+
+// requestDelete asks for key-1 to be deleted as alice and returns the
+// approval's path.
+func requestDelete(t *testing.T, ks *keyServer, query string) string {
+	t.Helper()
+	status, answer := ks.do(alice, http.MethodDelete, "/keys/key-1"+query, "")
+	approval, _ := answer["approval"].(map[string]any)
+	if status != http.StatusAccepted || approval == nil {
+		t.Fatalf("DELETE answered %d: %v", status, answer)
+	}
+	if approval["accountId"] != 1.0 || approval["requestedBy"] != "alice" {
+		t.Errorf("approval = %v, want one for account 1 requested by alice", approval)
+	}
+	return "/approvals/" + approval["id"].(string)
+}
+
+func TestApprovalChecks(t *testing.T) {
+	ks := newApprovalServer(t)
+	path := requestDelete(t, ks, "")
+	if got := ks.deletes.Load(); got != 0 {
+		t.Fatalf("key deleted %d times before approval", got)
+	}
+
+	for _, step := range []struct {
+		name   string
+		caller *Caller
+		method string
+		path   string
+		want   int
+	}{
+		{"requester reads it", alice, http.MethodGet, path, http.StatusOK},
+		{"caller of another account can't read it", outsider, http.MethodGet, path, http.StatusForbidden},
+		{"requester can't approve it", alice, http.MethodPost, path + "/approve", http.StatusForbidden},
+		{"caller of another account can't approve it", outsider, http.MethodPost, path + "/approve", http.StatusForbidden},
+		{"caller of another account can't reject it", outsider, http.MethodPost, path + "/reject", http.StatusForbidden},
+		{"unknown approval", bob, http.MethodPost, "/approvals/unknown/approve", http.StatusNotFound},
+		{"second person approves it", bob, http.MethodPost, path + "/approve", http.StatusOK},
+		{"approved only once", bob, http.MethodPost, path + "/approve", http.StatusConflict},
+		{"rejected after approval", bob, http.MethodPost, path + "/reject", http.StatusConflict},
+	} {
+		if status, answer := ks.do(step.caller, step.method, step.path, ""); status != step.want {
+			t.Fatalf("%s: answered %d, want %d: %v", step.name, status, step.want, answer)
+		}
+	}
+	if got := ks.deletes.Load(); got != 1 {
+		t.Errorf("NerdGraph got %d deletes, want 1", got)
+	}
+	entries, _ := ks.audit.Entries(func(entry *AuditEntry) bool { return entry.Action == "key.delete" })
+	if len(entries) != 1 || entries[0].Actor != "alice" || entries[0].AccountID != 1 {
+		t.Errorf("audit entries = %+v, want the delete by alice in account 1", entries)
+	}
+}
+
+func TestApprovalRejected(t *testing.T) {
+	ks := newApprovalServer(t)
+	path := requestDelete(t, ks, "")
+	if status, _ := ks.do(alice, http.MethodPost, path+"/reject", ""); status != http.StatusOK {
+		t.Fatalf("reject answered %d", status)
+	}
+	if status, _ := ks.do(bob, http.MethodPost, path+"/approve", ""); status != http.StatusConflict {
+		t.Errorf("approve after reject answered %d, want 409", status)
+	}
+	if got := ks.deletes.Load(); got != 0 {
+		t.Errorf("rejected delete ran %d times", got)
+	}
+}
+
+func TestApprovalExpired(t *testing.T) {
+	ks := newApprovalServer(t)
+	path := requestDelete(t, ks, "")
+	ks.approvals.mu.Lock()
+	for _, approval := range ks.approvals.approvals {
+		approval.ExpiresAt = time.Now().Add(-time.Minute)
+	}
+	ks.approvals.mu.Unlock()
+
+	if status, answer := ks.do(bob, http.MethodPost, path+"/approve", ""); status != http.StatusConflict || answer["status"] != approvalExpired {
+		t.Errorf("approve after expiry answered %d: %v", status, answer)
+	}
+	if got := ks.deletes.Load(); got != 0 {
+		t.Errorf("expired delete ran %d times", got)
+	}
+}
+
+func TestApprovedDeleteKeepsGrace(t *testing.T) {
+	ks := newApprovalServer(t)
+	path := requestDelete(t, ks, "?grace_hours=1")
+	if status, answer := ks.do(bob, http.MethodPost, path+"/approve", ""); status != http.StatusOK {
+		t.Fatalf("approve answered %d: %v", status, answer)
+	}
+	if got := ks.deletes.Load(); got != 0 {
+		t.Fatalf("approved delete ran %d times before its grace period ended", got)
+	}
+
+	// Only the requester sees the token that cancels the delete.
+	_, answer := ks.do(alice, http.MethodGet, path, "")
+	result, _ := answer["approval"].(map[string]any)["result"].(map[string]any)
+	if result["cancellation_token"] == nil {
+		t.Fatalf("requester sees approval %v, want the cancellation token", answer)
+	}
+	if _, answer := ks.do(bob, http.MethodGet, path, ""); answer["approval"].(map[string]any)["result"] != nil {
+		t.Errorf("approver sees the result %v", answer)
+	}
+	if status, _ := ks.do(alice, http.MethodPost, "/keys/key-1/undelete", `{"token": "`+result["cancellation_token"].(string)+`"}`); status != http.StatusOK {
+		t.Errorf("undelete answered %d", status)
+	}
+	ks.runScheduled()
+	if got := ks.deletes.Load(); got != 0 {
+		t.Errorf("undeleted key was deleted %d times", got)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records who did what to which key.
+type AuditEntry struct {
+	ID        string         `json:"id"`
+	Time      time.Time      `json:"time"`
+	Actor     string         `json:"actor"`
+	Action    string         `json:"action"`
+	AccountID int            `json:"accountId,omitempty"`
+	KeyID     string         `json:"keyId,omitempty"`
+	Outcome   string         `json:"outcome"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"requestId,omitempty"`
+}
+
+// auditLog appends entries to a file, one JSON document per line. Each line
+// is sealed on its own when encryption is configured, so the file can be
+// appended to without rewriting it.
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return &auditLog{path: path}, nil
+}
+
+// actorName is how the caller in ctx appears in the audit log.
+func actorName(ctx context.Context) string {
+	if caller := callerFromContext(ctx); caller != nil {
+		return caller.Name
+	}
+	return "anonymous"
+}
+
+// Record appends an entry for the caller in ctx. Failing to write the audit
+// log is logged rather than failing the operation that was audited.
+func (a *auditLog) Record(ctx context.Context, entry AuditEntry) {
+	entry.ID = newID()
+	entry.Time = time.Now().UTC()
+	if entry.Actor == "" {
+		entry.Actor = actorName(ctx)
+	}
+	if entry.Outcome == "" {
+		entry.Outcome = "success"
+	}
+	if entry.RequestID == "" {
+		entry.RequestID = requestIDFromContext(ctx)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode audit entry: %v", err)
+		return
+	}
+	// Key values must never reach the audit log.
+	data = []byte(secrets.Redact(string(data)))
+	if dataSealer != nil {
+		data = dataSealer.Seal(data)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Failed to write audit entry: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write audit entry: %v", err)
+	}
+}
+
+// Entries reads the audit log back, oldest first, keeping the entries match
+// accepts. Lines that can't be decrypted or parsed are skipped and logged.
+func (a *auditLog) Entries(match func(entry *AuditEntry) bool) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.Open(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if strings.HasPrefix(string(data), sealedPrefix) {
+			if dataSealer == nil {
+				return nil, fmt.Errorf("%s is encrypted but ENCRYPTION_MASTER_KEY is not set", a.path)
+			}
+			if data, err = dataSealer.Open(data); err != nil {
+				log.Printf("Skipping audit entry on line %d: %v", line, err)
+				continue
+			}
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Skipping audit entry on line %d: %v", line, err)
+			continue
+		}
+		if match == nil || match(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Sync waits for any entry being written and makes sure the entries so far
+// have reached the disk.
+func (a *auditLog) Sync(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// auditCursorTime formats entry times in cursors at a fixed width, so that
+// they sort as strings.
+const auditCursorTime = "2006-01-02T15:04:05.000000000Z"
+
+// auditFilter is the query of GET /audit. Zero fields match everything.
+type auditFilter struct {
+	actor    string
+	action   string
+	keyID    string
+	accounts []int
+	since    time.Time
+	until    time.Time
+}
+
+// parseAuditFilter reads the filter from the query string.
+func parseAuditFilter(query url.Values) (*auditFilter, error) {
+	f := &auditFilter{actor: query.Get("actor"), action: query.Get("action"), keyID: query.Get("keyId")}
+	var err error
+	if f.accounts, err = parseAccountIDs(query.Get("account")); err != nil {
+		return nil, err
+	}
+	for name, t := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		if v := query.Get(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("invalid %s %q, expected an RFC 3339 time", name, v)
+			}
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether an entry passes the filter. An action ending in
+// ".*" matches every action with that prefix, so "key.*" finds all key
+// changes.
+func (f *auditFilter) matches(entry *AuditEntry) bool {
+	switch {
+	case f.actor != "" && entry.Actor != f.actor,
+		f.keyID != "" && entry.KeyID != f.keyID,
+		len(f.accounts) > 0 && !slices.Contains(f.accounts, entry.AccountID),
+		!f.since.IsZero() && entry.Time.Before(f.since),
+		!f.until.IsZero() && !entry.Time.Before(f.until):
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(f.action, "*"); ok {
+		return strings.HasPrefix(entry.Action, prefix)
+	}
+	return f.action == "" || entry.Action == f.action
+}
+
+// List the audit log, newest first. Callers limited to accounts only see
+// the entries for those accounts. Pass nextCursor back as cursor for the
+// next page.
+func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request for the audit log")
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	for _, accountID := range filter.accounts {
+		if !authorizeAccount(w, r, accountID) {
+			return
+		}
+	}
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	caller := callerFromContext(r.Context())
+	entries, err := s.audit.Entries(func(entry *AuditEntry) bool {
+		if caller != nil && caller.restricted() && (entry.AccountID == 0 || !caller.CanAccess(entry.AccountID)) {
+			return false
+		}
+		return filter.matches(entry)
+	})
+	if err != nil {
+		log.Printf("Failed to read audit log: %v, Status Code: %d", err, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to read audit log"}`, http.StatusInternalServerError)
+		return
+	}
+	// The log is appended in order, but concurrent requests can record a
+	// little out of it, so sort rather than reverse.
+	newestFirst := func(a, b []string) int { return slices.Compare(b, a) }
+	slices.SortFunc(entries, func(a, b AuditEntry) int { return newestFirst(auditEntryKey(a), auditEntryKey(b)) })
+
+	entries, nextCursor := paginate(entries, page, auditEntryKey, newestFirst)
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	response := map[string]any{"entries": entries}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// auditEntryKey is the sort key of an audit entry: its time and ID.
+func auditEntryKey(entry AuditEntry) []string {
+	return []string{entry.Time.UTC().Format(auditCursorTime), entry.ID}
+}
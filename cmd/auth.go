@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Caller is the authenticated client making a request.
+type Caller struct {
+	Name        string
+	Accounts    []int
+	Tenant      *Tenant
+	Credentials []string
+}
+
+// CanAccess reports whether the caller may act on the given account. When
+// the caller belongs to a tenant the tenant's accounts bound it as well.
+func (c *Caller) CanAccess(accountID int) bool {
+	if c.Tenant != nil && !c.Tenant.CanAccess(accountID) {
+		return false
+	}
+	return len(c.Accounts) == 0 || slices.Contains(c.Accounts, accountID)
+}
+
+// CanUseCredential reports whether the caller may select the named
+// credential. Callers bound to a tenant may not select any, and callers
+// with no credentials listed may select all of them.
+func (c *Caller) CanUseCredential(name string) bool {
+	if c.Tenant != nil {
+		return false
+	}
+	return len(c.Credentials) == 0 || slices.Contains(c.Credentials, name)
+}
+
+// restricted reports whether the caller is limited to a set of accounts.
+func (c *Caller) restricted() bool {
+	return len(c.Accounts) > 0 || (c.Tenant != nil && len(c.Tenant.Accounts) > 0)
+}
+
+type callerKey struct{}
+
+// callerFromContext returns the caller for a request, or nil when proxy
+// authentication is not configured.
+func callerFromContext(ctx context.Context) *Caller {
+	caller, _ := ctx.Value(callerKey{}).(*Caller)
+	return caller
+}
+
+// authenticator checks the bearer token on each request against the callers
+// in the config. Tokens are looked up by hash so the comparison doesn't leak
+// timing information about the configured tokens.
+type authenticator struct {
+	tokens      map[*secretValue]*Caller
+	byName      map[string]*Caller
+	users       map[string]*Caller
+	credentials map[string]*Credential
+	// policy is set when POLICY_FILE is.
+	policy *policyFile
+	// oidc is set when OIDC_ISSUER is, and accepts session cookies and
+	// tokens for the users.
+	oidc *oidcProvider
+
+	mu      sync.RWMutex
+	callers map[[32]byte]*Caller
+}
+
+func newAuthenticator(config *Config, tenants map[string]*Tenant, credentials map[string]*Credential) (*authenticator, error) {
+	a := &authenticator{tokens: map[*secretValue]*Caller{}, byName: map[string]*Caller{}, users: map[string]*Caller{}, credentials: credentials}
+	for _, c := range config.Callers {
+		caller := &Caller{
+			Name:        c.Name,
+			Accounts:    c.Accounts,
+			Tenant:      tenants[c.Tenant],
+			Credentials: c.Credentials,
+		}
+		a.byName[c.Name] = caller
+		if c.OIDC {
+			a.users[c.Name] = caller
+		}
+		if c.Token == "" && c.TokenFile == "" {
+			continue
+		}
+		token, err := newSecretValue("token of caller "+c.Name, c.Token, c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens[token] = caller
+	}
+	a.rebuild()
+	return a, nil
+}
+
+// rebuild indexes the callers by the current value of their tokens, after
+// token files have been reloaded.
+func (a *authenticator) rebuild() {
+	callers := make(map[[32]byte]*Caller, len(a.tokens))
+	for token, caller := range a.tokens {
+		callers[sha256.Sum256([]byte(token.Get()))] = caller
+	}
+	a.mu.Lock()
+	a.callers = callers
+	a.mu.Unlock()
+}
+
+// secrets lists the caller tokens, for watchSecrets.
+func (a *authenticator) secrets() []*secretValue {
+	values := make([]*secretValue, 0, len(a.tokens))
+	for token := range a.tokens {
+		values = append(values, token)
+	}
+	return values
+}
+
+// callerByName finds a configured caller, for work done on its behalf
+// outside of a request. With a policy, the caller is limited to the
+// accounts of its roles, and nil when they leave it none.
+func (a *authenticator) callerByName(name string) *Caller {
+	caller := a.byName[name]
+	if caller == nil || a.policy == nil {
+		return caller
+	}
+	return narrowCaller(caller, a.policy.Get().accounts(name))
+}
+
+// userByName finds the caller a person signing in through OIDC acts as.
+func (a *authenticator) userByName(name string) *Caller {
+	return a.users[name]
+}
+
+// enabled reports whether callers are configured, so requests and jobs
+// carry one.
+func (a *authenticator) enabled() bool {
+	return len(a.byName) > 0
+}
+
+// credentialByName finds a named credential, for work done with it outside
+// of a request.
+func (a *authenticator) credentialByName(name string) *Credential {
+	return a.credentials[name]
+}
+
+func (a *authenticator) Middleware(next http.Handler) http.Handler {
+	if !a.enabled() {
+		log.Println("Warning: no callers configured, proxy authentication is disabled")
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Deferred requests are replayed as the caller that queued them.
+		if replaying(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		a.mu.RLock()
+		caller := a.callers[sha256.Sum256([]byte(token))]
+		a.mu.RUnlock()
+		if caller == nil && a.oidc != nil {
+			caller = a.userByName(a.oidc.sessionCaller(r))
+			ok = caller != nil
+		}
+		if !ok || caller == nil {
+			log.Printf("Rejected request with missing or unknown token, Status Code: %d", http.StatusUnauthorized)
+			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		setAccessCaller(r.Context(), caller.Name)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	})
+}
+
+// authorizeAccount rejects the request with 403 when the caller may not act
+// on the account. It returns false when the request has been answered.
+func authorizeAccount(w http.ResponseWriter, r *http.Request, accountID int) bool {
+	if err := checkAccount(r.Context(), accountID); err != nil {
+		writeError(w, fmt.Sprintf("Caller %s denied account %d", callerFromContext(r.Context()).Name, accountID), err)
+		return false
+	}
+	return true
+}
+
+// checkAccount returns errForbidden when the caller may not act on the
+// account.
+func checkAccount(ctx context.Context, accountID int) error {
+	caller := callerFromContext(ctx)
+	if caller == nil || caller.CanAccess(accountID) {
+		return nil
+	}
+	return errForbidden
+}
+
+// accountFromPath reads and authorizes the {account} path variable. It
+// returns false when the request has been answered.
+func accountFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	accountID, err := strconv.Atoi(mux.Vars(r)["account"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid account ID"}`, http.StatusBadRequest)
+		return 0, false
+	}
+	return accountID, authorizeAccount(w, r, accountID)
+}
+
+// authorizeKey checks that the caller may act on the account an existing key
+// belongs to. It returns false when the request has been answered.
+func (s *Server) authorizeKey(w http.ResponseWriter, r *http.Request, id string) bool {
+	if err := s.checkKeyAccess(r.Context(), id); err != nil {
+		writeError(w, "Failed to authorize key", err)
+		return false
+	}
+	return true
+}
+
+// checkKeyAccess returns errForbidden when the caller may not act on the
+// account the key belongs to. Unrestricted callers skip the lookup.
+func (s *Server) checkKeyAccess(ctx context.Context, id string) error {
+	caller := callerFromContext(ctx)
+	if caller == nil || !caller.restricted() {
+		return nil
+	}
+	key, err := s.getIngestKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	return checkAccount(ctx, key.AccountID)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backgroundJob is periodic work that only one replica should be doing,
+// unless everyReplica is set for work on state each replica keeps itself.
+type backgroundJob struct {
+	name         string
+	interval     time.Duration
+	run          func(ctx context.Context)
+	everyReplica bool
+}
+
+// runBackgroundJobs starts the jobs and runs each one on its interval for as
+// long as this replica is the leader. Followers keep ticking but skip the
+// work, so they pick it up as soon as they win an election; only jobs for
+// every replica run on followers as well. The returned
+// channel is closed once ctx is done and every job, and the election, has
+// stopped.
+func runBackgroundJobs(ctx context.Context, leader elector, jobs []backgroundJob) <-chan struct{} {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		leader.Run(ctx)
+	}()
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if !job.everyReplica && !leader.IsLeader() {
+					continue
+				}
+				job.run(ctx)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fixedElector is an elector whose outcome never changes.
+type fixedElector bool
+
+func (e fixedElector) IsLeader() bool        { return bool(e) }
+func (fixedElector) Run(ctx context.Context) {}
+
+func TestRunBackgroundJobs(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		leader       bool
+		everyReplica bool
+		wantRun      bool
+	}{
+		{"leader", true, false, true},
+		{"follower", false, false, false},
+		{"follower, every replica", false, true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var runs atomic.Int32
+			job := backgroundJob{name: "test", interval: time.Millisecond, everyReplica: test.everyReplica, run: func(ctx context.Context) { runs.Add(1) }}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := runBackgroundJobs(ctx, fixedElector(test.leader), []backgroundJob{job})
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+			<-done
+			if got := runs.Load() > 0; got != test.wantRun {
+				t.Errorf("ran = %v, want %v", got, test.wantRun)
+			}
+		})
+	}
+}
+
+func TestJobQueuedOnFollower(t *testing.T) {
+	q, err := newJobQueue(t.TempDir()+"/jobs.json", &authenticator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Register("test", func(ctx context.Context, job *Job) (any, error) { return "done", nil })
+	job, err := q.Enqueue(context.Background(), "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runBackgroundJobs(ctx, fixedElector(false), []backgroundJob{q.backgroundJob()})
+	defer func() { cancel(); <-done }()
+	waitFor(t, func() bool { return q.Get(job.ID).Status == jobSucceeded })
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+const (
+	defaultBatchSize = 10
+	maxBatchSize     = 50
+)
+
+// batchMutation is a mutation run for several inputs in one NerdGraph
+// request, each under its own alias, so a bulk flow costs a handful of
+// round trips instead of one per item. Document is rendered with a
+// batchDocument naming each alias and its input variable.
+type batchMutation struct {
+	Document string
+	Field    string
+}
+
+// batchSize is how many inputs go into one request.
+func batchSize() int {
+	if n, err := strconv.Atoi(os.Getenv("NERDGRAPH_BATCH_SIZE")); err == nil && n > 0 {
+		return min(n, maxBatchSize)
+	}
+	return defaultBatchSize
+}
+
+// errMaybeApplied marks a mutation that failed in a way that doesn't tell
+// whether NerdGraph applied it, such as a lost response, so that it isn't
+// retried into a duplicate.
+var errMaybeApplied = errors.New("the change may have been applied")
+
+// maybeAppliedHeader marks a response to a change that failed with
+// errMaybeApplied, so that neither callers nor replays send it again
+// without checking first.
+const maybeAppliedHeader = "X-Change-Maybe-Applied"
+
+// runBatch runs the mutation for every input and returns each one's raw
+// result and error, in input order. An input with a result succeeded, even
+// when the response also carries errors. An input without one gets the
+// GraphQL errors that point at its alias, or failing that the errors that
+// point at none. Failures of the request as a whole are handed to every
+// input of that request, marked errMaybeApplied unless the request
+// certainly wasn't applied.
+func (s *Server) runBatch(ctx context.Context, m batchMutation, inputs []any) ([]json.RawMessage, []error) {
+	results := make([]json.RawMessage, len(inputs))
+	errs := make([]error, len(inputs))
+	size := batchSize()
+	for start := 0; start < len(inputs); start += size {
+		chunk := inputs[start:min(start+size, len(inputs))]
+		document := batchDocument{Items: make([]batchItem, len(chunk))}
+		for i := range chunk {
+			document.Items[i] = batchItem{Alias: "m" + strconv.Itoa(i), Variable: "input" + strconv.Itoa(i)}
+		}
+		req, err := newRequest(m.Document, document)
+		if err != nil {
+			for i := range chunk {
+				errs[start+i] = err
+			}
+			continue
+		}
+		for i, input := range chunk {
+			req.Var(document.Items[i].Variable, input)
+		}
+
+		var data map[string]json.RawMessage
+		err = s.runGraphQL(ctx, req, &data)
+		var graphqlErrs graphql.Errors
+		if err != nil && !errors.As(err, &graphqlErrs) {
+			if !notApplied(err) {
+				err = fmt.Errorf("%w: %w", errMaybeApplied, err)
+			}
+			for i := range chunk {
+				errs[start+i] = err
+			}
+			continue
+		}
+
+		placed := make([]graphql.Errors, len(chunk))
+		var unplaced graphql.Errors
+		for _, e := range graphqlErrs {
+			if i, ok := aliasIndex(e, len(chunk)); ok {
+				placed[i] = append(placed[i], e)
+			} else {
+				unplaced = append(unplaced, e)
+			}
+		}
+		for i := range chunk {
+			switch result := data[document.Items[i].Alias]; {
+			case len(result) > 0 && string(result) != "null":
+				results[start+i] = result
+			case len(placed[i]) > 0:
+				errs[start+i] = placed[i]
+			case len(unplaced) > 0:
+				errs[start+i] = unplaced
+			default:
+				errs[start+i] = fmt.Errorf("no result for %s", m.Field)
+			}
+		}
+	}
+	return results, errs
+}
+
+// aliasIndex returns which input of a request of count an error points at.
+func aliasIndex(e *graphql.Error, count int) (int, bool) {
+	if len(e.Path) == 0 {
+		return 0, false
+	}
+	alias, _ := e.Path[0].(string)
+	i, err := strconv.Atoi(strings.TrimPrefix(alias, "m"))
+	if err != nil || !strings.HasPrefix(alias, "m") || i < 0 || i >= count {
+		return 0, false
+	}
+	return i, true
+}
+
+// notApplied reports whether a request that failed with err certainly
+// didn't reach NerdGraph or was turned away before running: it was
+// rate limited, failed schema validation, or was refused as a client error.
+func notApplied(err error) bool {
+	var httpErr *graphql.HTTPError
+	switch {
+	case errors.As(err, new(*RateLimitError)), errors.As(err, new(*SchemaError)):
+		return true
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode < 500
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+// batchServer returns a server whose NerdGraph answers every request with
+// status and body.
+func batchServer(t *testing.T, status int, body string) *Server {
+	t.Helper()
+	nerdGraph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(nerdGraph.Close)
+	apiKey, _ := newSecretValue("NEW_RELIC_API_KEY", "NRAK-TESTTESTTEST", "")
+	client := graphql.NewClient(nerdGraph.URL, graphql.WithHTTPClient(&http.Client{Transport: newRateLimitTransport(http.DefaultTransport)}))
+	return &Server{client: client, apiKey: apiKey}
+}
+
+func TestRunBatchKeepsResultsAlongsideErrors(t *testing.T) {
+	s := batchServer(t, http.StatusOK, `{
+		"data": {"m0": {"createdKeys": [{"id": "a"}]}, "m1": null, "m2": null},
+		"errors": [
+			{"message": "invalid name", "path": ["m1"]},
+			{"message": "something else"}
+		]
+	}`)
+	results, errs := s.runBatch(context.Background(), createKeysMutation, []any{map[string]any{}, map[string]any{}, map[string]any{}})
+
+	if errs[0] != nil || len(results[0]) == 0 {
+		t.Fatalf("item 0 = %s, %v; want its result", results[0], errs[0])
+	}
+	if errs[1] == nil || errs[1].Error() != "graphql: invalid name" {
+		t.Errorf("item 1 error = %v, want only the error placed at its alias", errs[1])
+	}
+	if errs[2] == nil || errs[2].Error() != "graphql: something else" {
+		t.Errorf("item 2 error = %v, want the unplaced error", errs[2])
+	}
+}
+
+func TestRunBatchKeepsResultsWhenRateLimited(t *testing.T) {
+	s := batchServer(t, http.StatusOK, `{
+		"data": {"m0": {"createdKeys": [{"id": "a", "key": "NRII-created"}]}, "m1": null},
+		"errors": [{"message": "slow down", "path": ["m1"], "extensions": {"errorClass": "TOO_MANY_REQUESTS"}}]
+	}`)
+	results, errs := s.runBatch(context.Background(), createKeysMutation, []any{map[string]any{}, map[string]any{}})
+
+	if errs[0] != nil || len(results[0]) == 0 {
+		t.Fatalf("item 0 = %s, %v; want the created key", results[0], errs[0])
+	}
+	if !retryable(errs[1]) {
+		t.Errorf("item 1 error %v should be retryable: it wasn't applied", errs[1])
+	}
+}
+
+func TestRunBatchRequestFailures(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		status    int
+		body      string
+		retryable bool
+	}{
+		{"rate limited", http.StatusTooManyRequests, ``, true},
+		{"rate limited in body", http.StatusOK, `{"errors": [{"message": "slow down", "extensions": {"errorClass": "TOO_MANY_REQUESTS"}}]}`, true},
+		{"gateway timeout", http.StatusGatewayTimeout, `upstream timed out`, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := batchServer(t, test.status, test.body)
+			_, errs := s.runBatch(context.Background(), createKeysMutation, []any{map[string]any{}, map[string]any{}})
+			for i, err := range errs {
+				if err == nil {
+					t.Fatalf("item %d succeeded", i)
+				}
+				if retryable(err) != test.retryable {
+					t.Errorf("retryable(%v) = %v, want %v", err, !test.retryable, test.retryable)
+				}
+				if !test.retryable && !errors.Is(err, errMaybeApplied) {
+					t.Errorf("item %d error %v isn't marked errMaybeApplied", i, err)
+				}
+			}
+		})
+	}
+}
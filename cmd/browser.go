@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// BrowserAppRequest creates a browser application and, with create_key, the
+// BROWSER ingest key its loader reports with, so onboarding a site is one
+// call.
+type BrowserAppRequest struct {
+	AccountID                 int               `json:"account_id"`
+	Name                      string            `json:"name"`
+	LoaderType                string            `json:"loaderType,omitempty"`
+	CookiesEnabled            *bool             `json:"cookiesEnabled,omitempty"`
+	DistributedTracingEnabled *bool             `json:"distributedTracingEnabled,omitempty"`
+	CreateKey                 bool              `json:"create_key"`
+	KeyName                   string            `json:"key_name,omitempty"`
+	Notes                     string            `json:"notes,omitempty"`
+	Labels                    map[string]string `json:"labels,omitempty"`
+}
+
+// BrowserApplication is a browser app as agentApplicationCreateBrowser
+// returns it, including the loader script to embed in the site.
+type BrowserApplication struct {
+	GUID     string `json:"guid"`
+	Name     string `json:"name"`
+	Settings struct {
+		CookiesEnabled            bool   `json:"cookiesEnabled"`
+		DistributedTracingEnabled bool   `json:"distributedTracingEnabled"`
+		LoaderScript              string `json:"loaderScript"`
+		LoaderType                string `json:"loaderType"`
+	} `json:"settings"`
+}
+
+// createBrowserApp runs the agentApplicationCreateBrowser mutation.
+func (s *Server) createBrowserApp(ctx context.Context, request BrowserAppRequest) (*BrowserApplication, error) {
+	req, err := newRequest("createBrowserApp", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("accountId", request.AccountID)
+	req.Var("name", request.Name)
+	settings := map[string]any{}
+	if request.LoaderType != "" {
+		settings["loaderType"] = request.LoaderType
+	}
+	if request.CookiesEnabled != nil {
+		settings["cookiesEnabled"] = *request.CookiesEnabled
+	}
+	if request.DistributedTracingEnabled != nil {
+		settings["distributedTracingEnabled"] = *request.DistributedTracingEnabled
+	}
+	if len(settings) > 0 {
+		req.Var("settings", settings)
+	}
+
+	var responseData struct {
+		AgentApplicationCreateBrowser *BrowserApplication `json:"agentApplicationCreateBrowser"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if responseData.AgentApplicationCreateBrowser == nil {
+		return nil, fmt.Errorf("no browser application was created")
+	}
+	return responseData.AgentApplicationCreateBrowser, nil
+}
+
+// Create a browser application, optionally with its ingest key
+func (s *Server) createBrowserApplication(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to create a browser application")
+
+	var request BrowserAppRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	if request.AccountID <= 0 || request.Name == "" {
+		http.Error(w, `{"error": "Invalid request: account_id and name are required"}`, http.StatusBadRequest)
+		return
+	}
+	if err := validateLabels(request.Labels); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if !authorizeAccount(w, r, request.AccountID) {
+		return
+	}
+
+	app, err := s.createBrowserApp(r.Context(), request)
+	if err != nil {
+		writeError(w, "Failed to create browser application", err)
+		return
+	}
+	log.Printf("Successfully created browser application: GUID=%s, Name=%s", app.GUID, app.Name)
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "app.create",
+		AccountID: request.AccountID,
+		Details:   map[string]any{"guid": app.GUID, "name": app.Name, "type": "browser"},
+	})
+	if !request.CreateKey {
+		writeJSON(w, http.StatusOK, map[string]any{"application": app})
+		return
+	}
+
+	keyName := request.KeyName
+	if keyName == "" {
+		keyName = request.Name
+	}
+	created, err := s.createIngestKey(r.Context(), InsertKeyRequest{
+		AccountID:  request.AccountID,
+		Name:       keyName,
+		Notes:      request.Notes,
+		IngestType: "BROWSER",
+		Labels:     request.Labels,
+	})
+	if err == nil && len(created.APIAccessCreateKeys.CreatedKeys) == 0 {
+		err = KeyErrors(created.APIAccessCreateKeys.Errors)
+	}
+	if err != nil {
+		// The application exists either way, so hand it back.
+		log.Printf("Created browser application %s but failed to create its key: %v, Status Code: %d", app.GUID, err, http.StatusBadGateway)
+		writeJSON(w, http.StatusBadGateway, map[string]any{
+			"error":       "Created browser application but failed to create its key",
+			"details":     secrets.Redact(err.Error()),
+			"application": app,
+		})
+		return
+	}
+	createdKey := created.APIAccessCreateKeys.CreatedKeys[0]
+	s.audit.Record(r.Context(), AuditEntry{Action: "key.create", AccountID: request.AccountID, KeyID: createdKey.ID})
+	writeJSON(w, http.StatusOK, map[string]any{
+		"application": app,
+		"insert_key":  createdKey,
+	})
+}
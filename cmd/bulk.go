@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// itemResult is the outcome for one item of a bulk job.
+type itemResult struct {
+	Item   string `json:"item"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+var errItemsPending = errors.New("some items failed and will be retried")
+
+// runItems works through the items of a bulk job, skipping any finished by
+// an earlier attempt. Items that fail with a retryable error are left
+// pending and errItemsPending is returned so the job is attempted again;
+// on the last attempt they are marked failed instead.
+func runItems(ctx context.Context, job *Job, items []string, do func(ctx context.Context, item string) (any, error)) ([]itemResult, error) {
+	return runItemBatch(ctx, job, items, func(ctx context.Context, items []string) ([]any, []error) {
+		values := make([]any, len(items))
+		errs := make([]error, len(items))
+		for i, item := range items {
+			values[i], errs[i] = do(ctx, item)
+		}
+		return values, errs
+	})
+}
+
+// runItemBatch is runItems for work that handles all the unfinished items
+// in one call, returning each one's result and error in order.
+func runItemBatch(ctx context.Context, job *Job, items []string, do func(ctx context.Context, items []string) ([]any, []error)) ([]itemResult, error) {
+	var previous []itemResult
+	if len(job.Result) > 0 {
+		json.Unmarshal(job.Result, &previous)
+	}
+	done := map[string]itemResult{}
+	for _, result := range previous {
+		if result.Status != jobQueued {
+			done[result.Item] = result
+		}
+	}
+
+	var todo []string
+	for _, item := range items {
+		if _, ok := done[item]; !ok {
+			todo = append(todo, item)
+		}
+	}
+	if len(todo) > 0 {
+		values, errs := do(ctx, todo)
+		for i, item := range todo {
+			result := itemResult{Item: item, Status: jobSucceeded, Result: values[i]}
+			if err := errs[i]; err != nil {
+				result.Error = secrets.Redact(err.Error())
+				result.Status = jobFailed
+				if retryable(err) && job.Attempts < job.MaxAttempts {
+					result.Status = jobQueued
+				}
+			}
+			done[item] = result
+		}
+	}
+
+	results := make([]itemResult, 0, len(items))
+	pending := false
+	for _, item := range items {
+		result := done[item]
+		pending = pending || result.Status == jobQueued
+		results = append(results, result)
+	}
+	if pending {
+		return results, errItemsPending
+	}
+	return results, nil
+}
+
+// FanOutRequest creates the same ingest key in several accounts.
+type FanOutRequest struct {
+	Accounts   []int  `json:"accounts"`
+	Name       string `json:"name"`
+	Notes      string `json:"notes"`
+	IngestType string `json:"ingestType"`
+}
+
+// BulkRotateRequest rotates several ingest keys, named by ID or by selector.
+type BulkRotateRequest struct {
+	IDs      []string `json:"ids,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+}
+
+func (s *Server) registerBulkJobs() {
+	s.jobs.Register("fanout", func(ctx context.Context, job *Job) (any, error) {
+		var request FanOutRequest
+		if err := json.Unmarshal(job.Payload, &request); err != nil {
+			return nil, err
+		}
+		items := make([]string, len(request.Accounts))
+		for i, accountID := range request.Accounts {
+			items[i] = strconv.Itoa(accountID)
+		}
+		return runItemBatch(ctx, job, items, func(ctx context.Context, items []string) ([]any, []error) {
+			values := make([]any, len(items))
+			errs := make([]error, len(items))
+			var requests []InsertKeyRequest
+			var indexes []int
+			for i, item := range items {
+				accountID, _ := strconv.Atoi(item)
+				if errs[i] = checkAccount(ctx, accountID); errs[i] != nil {
+					continue
+				}
+				requests = append(requests, InsertKeyRequest{
+					AccountID:  accountID,
+					Name:       request.Name,
+					Notes:      request.Notes,
+					IngestType: request.IngestType,
+				})
+				indexes = append(indexes, i)
+			}
+
+			responses, createErrs := s.createIngestKeys(ctx, requests)
+			for j, i := range indexes {
+				if errs[i] = createErrs[j]; errs[i] != nil {
+					continue
+				}
+				created := responses[j].APIAccessCreateKeys
+				if len(created.CreatedKeys) == 0 {
+					errs[i] = KeyErrors(created.Errors)
+					continue
+				}
+				createdKey := created.CreatedKeys[0]
+				s.audit.Record(ctx, AuditEntry{Action: "key.create", AccountID: requests[j].AccountID, KeyID: createdKey.ID})
+				values[i] = createdKey
+			}
+			return values, errs
+		})
+	})
+
+	s.jobs.Register("rotate", func(ctx context.Context, job *Job) (any, error) {
+		var request BulkRotateRequest
+		if err := json.Unmarshal(job.Payload, &request); err != nil {
+			return nil, err
+		}
+		return runItems(ctx, job, request.IDs, func(ctx context.Context, id string) (any, error) {
+			newKey, err := s.rotateKey(ctx, id)
+			if err != nil && newKey != nil {
+				// Retrying would create yet another replacement, so report
+				// the partial rotation instead.
+				return newKey, fmt.Errorf("%w: %v", errPartialRotation, err)
+			}
+			return newKey, err
+		})
+	})
+}
+
+// Create the same key in several accounts
+func (s *Server) fanOutApiKey(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to create a key in several accounts")
+
+	var request FanOutRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Accounts) == 0 {
+		http.Error(w, `{"error": "Invalid request: accounts are required"}`, http.StatusBadRequest)
+		return
+	}
+	for _, accountID := range request.Accounts {
+		if !authorizeAccount(w, r, accountID) {
+			return
+		}
+	}
+
+	job, err := s.jobs.Enqueue(r.Context(), "fanout", request)
+	if err != nil {
+		log.Printf("Failed to queue job: %v, Status Code: %d", err, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to queue job"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJobAccepted(w, job)
+}
+
+// Rotate several keys
+func (s *Server) bulkRotateApiKeys(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to rotate several keys")
+
+	var request BulkRotateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	ids, err := s.resolveKeyIDs(r.Context(), request.IDs, request.Selector)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, `{"error": "No keys matched"}`, http.StatusNotFound)
+		return
+	}
+	request = BulkRotateRequest{IDs: ids}
+
+	// With approvals required every key in the batch needs its own sign-off.
+	if s.approvals != nil {
+		s.requireApprovals(w, r, "rotate", request.IDs)
+		return
+	}
+
+	job, err := s.jobs.Enqueue(r.Context(), "rotate", request)
+	if err != nil {
+		log.Printf("Failed to queue job: %v, Status Code: %d", err, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to queue job"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJobAccepted(w, job)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// keysNamed lists the keys in an account with exactly the given name and,
+// unless it is empty, ingest type. Keys already scheduled for deletion
+// don't count.
+func (s *Server) keysNamed(ctx context.Context, accountID int, name, ingestType string) ([]IngestKey, error) {
+	keys, err := s.searchIngestKeys(ctx, []int{accountID})
+	if err != nil {
+		return nil, err
+	}
+	matches := []IngestKey{}
+	for _, key := range keys {
+		if key.Name != name || (ingestType != "" && key.IngestType != ingestType) {
+			continue
+		}
+		if job, _ := s.pendingDelete(key.ID); job != nil {
+			continue
+		}
+		matches = append(matches, key)
+	}
+	return matches, nil
+}
+
+// keyFromName resolves the ?name= (and optional ?ingestType=) of a request
+// to the one key in the {account} with exactly that name. It returns false
+// when the request has been answered: 404 when no key matches and 409, listing the matches, when
+// several do.
+func (s *Server) keyFromName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return "", false
+	}
+	name, ingestType := r.URL.Query().Get("name"), r.URL.Query().Get("ingestType")
+	if name == "" {
+		http.Error(w, `{"error": "Invalid request: name is required"}`, http.StatusBadRequest)
+		return "", false
+	}
+	if ingestType != "" && !validIngestTypes[ingestType] {
+		http.Error(w, `{"error": "Invalid request: ingestType must be LICENSE or BROWSER"}`, http.StatusBadRequest)
+		return "", false
+	}
+
+	matches, err := s.keysNamed(r.Context(), accountID, name, ingestType)
+	if err != nil {
+		writeError(w, "Failed to list keys", err)
+		return "", false
+	}
+	switch len(matches) {
+	case 0:
+		log.Printf("No key named %q in account %d, Status Code: %d", name, accountID, http.StatusNotFound)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, fmt.Sprintf("No key named %q in account %d", name, accountID)), http.StatusNotFound)
+		return "", false
+	case 1:
+		log.Printf("Resolved key %q in account %d to %s", name, accountID, matches[0].ID)
+		return matches[0].ID, true
+	}
+	log.Printf("%d keys named %q in account %d, Status Code: %d", len(matches), name, accountID, http.StatusConflict)
+	writeJSON(w, http.StatusConflict, map[string]any{
+		"error":   fmt.Sprintf("%d keys are named %q in account %d; pass ingestType or use the key ID", len(matches), name, accountID),
+		"matches": matches,
+	})
+	return "", false
+}
+
+// Delete the key with a given name
+func (s *Server) deleteKeyByName(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received request to delete key %q", r.URL.Query().Get("name"))
+
+	id, ok := s.keyFromName(w, r)
+	if !ok {
+		return
+	}
+	s.deleteKeyWithGrace(w, r, id)
+}
+
+// Rotate the key with a given name
+func (s *Server) rotateKeyByName(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received request to rotate key %q", r.URL.Query().Get("name"))
+
+	id, ok := s.keyFromName(w, r)
+	if !ok {
+		return
+	}
+	s.rotateKeyWithApproval(w, r, id)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	conditionStatic   = "static"
+	conditionBaseline = "baseline"
+)
+
+// NrqlConditionTerm is a threshold that opens an incident at a priority.
+type NrqlConditionTerm struct {
+	Operator             string  `json:"operator"`
+	Priority             string  `json:"priority"`
+	Threshold            float64 `json:"threshold"`
+	ThresholdDuration    int     `json:"thresholdDuration"`
+	ThresholdOccurrences string  `json:"thresholdOccurrences"`
+}
+
+// NrqlConditionSignal controls how the query results are aggregated.
+type NrqlConditionSignal struct {
+	AggregationWindow int      `json:"aggregationWindow,omitempty"`
+	AggregationMethod string   `json:"aggregationMethod,omitempty"`
+	AggregationDelay  int      `json:"aggregationDelay,omitempty"`
+	FillOption        string   `json:"fillOption,omitempty"`
+	FillValue         *float64 `json:"fillValue,omitempty"`
+}
+
+// NrqlConditionExpiration controls what happens when the signal stops.
+type NrqlConditionExpiration struct {
+	ExpirationDuration          int  `json:"expirationDuration,omitempty"`
+	OpenViolationOnExpiration   bool `json:"openViolationOnExpiration"`
+	CloseViolationsOnExpiration bool `json:"closeViolationsOnExpiration"`
+}
+
+// NrqlQuery is the query a condition evaluates.
+type NrqlQuery struct {
+	Query string `json:"query"`
+}
+
+// NrqlCondition is a static or baseline NRQL alert condition.
+type NrqlCondition struct {
+	ID                        string                   `json:"id,omitempty"`
+	PolicyID                  string                   `json:"policyId,omitempty"`
+	Name                      string                   `json:"name"`
+	Description               string                   `json:"description,omitempty"`
+	Enabled                   bool                     `json:"enabled"`
+	RunbookURL                string                   `json:"runbookUrl,omitempty"`
+	NRQL                      NrqlQuery                `json:"nrql"`
+	Terms                     []NrqlConditionTerm      `json:"terms"`
+	Signal                    *NrqlConditionSignal     `json:"signal,omitempty"`
+	Expiration                *NrqlConditionExpiration `json:"expiration,omitempty"`
+	ViolationTimeLimitSeconds int                      `json:"violationTimeLimitSeconds,omitempty"`
+	BaselineDirection         string                   `json:"baselineDirection,omitempty"`
+}
+
+// NrqlConditionRequest creates or replaces a condition. Type is static
+// (default) or baseline and picks the mutation used.
+type NrqlConditionRequest struct {
+	Type      string        `json:"type"`
+	Condition NrqlCondition `json:"condition"`
+}
+
+// validate checks a condition before it is sent.
+func (request *NrqlConditionRequest) validate() error {
+	if request.Type == "" {
+		request.Type = conditionStatic
+	}
+	condition := request.Condition
+	switch {
+	case request.Type != conditionStatic && request.Type != conditionBaseline:
+		return fmt.Errorf("type must be static or baseline")
+	case condition.Name == "":
+		return fmt.Errorf("condition.name is required")
+	case strings.TrimSpace(condition.NRQL.Query) == "":
+		return fmt.Errorf("condition.nrql.query is required")
+	case len(condition.Terms) == 0:
+		return fmt.Errorf("condition.terms needs at least one term")
+	case request.Type == conditionBaseline && condition.BaselineDirection == "":
+		return fmt.Errorf("condition.baselineDirection is required for baseline conditions")
+	case request.Type == conditionStatic && condition.BaselineDirection != "":
+		return fmt.Errorf("condition.baselineDirection only applies to baseline conditions")
+	}
+	for i, term := range condition.Terms {
+		if term.Priority != "CRITICAL" && term.Priority != "WARNING" {
+			return fmt.Errorf("condition.terms[%d].priority must be CRITICAL or WARNING", i)
+		}
+		if term.Operator == "" || term.ThresholdDuration <= 0 || term.ThresholdOccurrences == "" {
+			return fmt.Errorf("condition.terms[%d] needs an operator, thresholdDuration and thresholdOccurrences", i)
+		}
+	}
+	return nil
+}
+
+// input is the condition as the create and update input types take it.
+func (condition NrqlCondition) input() map[string]any {
+	input := map[string]any{
+		"name":        condition.Name,
+		"description": condition.Description,
+		"enabled":     condition.Enabled,
+		"nrql":        map[string]any{"query": condition.NRQL.Query},
+		"terms":       condition.Terms,
+	}
+	if condition.RunbookURL != "" {
+		input["runbookUrl"] = condition.RunbookURL
+	}
+	if condition.Signal != nil {
+		input["signal"] = condition.Signal
+	}
+	if condition.Expiration != nil {
+		input["expiration"] = condition.Expiration
+	}
+	if condition.ViolationTimeLimitSeconds > 0 {
+		input["violationTimeLimitSeconds"] = condition.ViolationTimeLimitSeconds
+	}
+	if condition.BaselineDirection != "" {
+		input["baselineDirection"] = condition.BaselineDirection
+	}
+	return input
+}
+
+// createNrqlCondition runs alertsNrqlConditionStaticCreate or
+// alertsNrqlConditionBaselineCreate in a policy.
+func (s *Server) createNrqlCondition(ctx context.Context, accountID int, policyID string, request NrqlConditionRequest) (*NrqlCondition, error) {
+	mutation, inputType := "alertsNrqlConditionStaticCreate", "AlertsNrqlConditionStaticInput"
+	if request.Type == conditionBaseline {
+		mutation, inputType = "alertsNrqlConditionBaselineCreate", "AlertsNrqlConditionBaselineInput"
+	}
+	req, err := newRequest("createNrqlCondition", mutationDocument{Mutation: mutation, InputType: inputType})
+	if err != nil {
+		return nil, err
+	}
+	req.Var("accountId", accountID)
+	req.Var("policyId", policyID)
+	req.Var("condition", request.Condition.input())
+
+	var responseData struct {
+		Result *NrqlCondition `json:"result"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if responseData.Result == nil {
+		return nil, fmt.Errorf("no condition was created")
+	}
+	return responseData.Result, nil
+}
+
+// updateNrqlCondition runs alertsNrqlConditionStaticUpdate or
+// alertsNrqlConditionBaselineUpdate.
+func (s *Server) updateNrqlCondition(ctx context.Context, accountID int, id string, request NrqlConditionRequest) (*NrqlCondition, error) {
+	return s.runConditionUpdate(ctx, accountID, id, request.Type, request.Condition.input())
+}
+
+// runConditionUpdate sends an update input, which only changes the fields
+// it sets, to the update mutation for the condition type.
+func (s *Server) runConditionUpdate(ctx context.Context, accountID int, id, conditionType string, input map[string]any) (*NrqlCondition, error) {
+	mutation, inputType := "alertsNrqlConditionStaticUpdate", "AlertsNrqlConditionUpdateStaticInput"
+	if conditionType == conditionBaseline {
+		mutation, inputType = "alertsNrqlConditionBaselineUpdate", "AlertsNrqlConditionUpdateBaselineInput"
+	}
+	req, err := newRequest("updateNrqlCondition", mutationDocument{Mutation: mutation, InputType: inputType})
+	if err != nil {
+		return nil, err
+	}
+	req.Var("accountId", accountID)
+	req.Var("id", id)
+	req.Var("condition", input)
+
+	var responseData struct {
+		Result *NrqlCondition `json:"result"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if responseData.Result == nil {
+		return nil, fmt.Errorf("condition %w", errNotFound)
+	}
+	return responseData.Result, nil
+}
+
+// deleteCondition runs alertsConditionDelete, which removes a condition of
+// any type.
+func (s *Server) deleteCondition(ctx context.Context, accountID int, id string) error {
+	req, err := newRequest("deleteCondition", nil)
+	if err != nil {
+		return err
+	}
+	req.Var("accountId", accountID)
+	req.Var("id", id)
+
+	var responseData struct {
+		AlertsConditionDelete *struct {
+			ID string `json:"id"`
+		} `json:"alertsConditionDelete"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return err
+	}
+	if responseData.AlertsConditionDelete == nil {
+		return fmt.Errorf("condition %w", errNotFound)
+	}
+	return nil
+}
+
+// decodeNrqlCondition reads and validates a condition from the request body.
+// It returns false when the request has been answered.
+func decodeNrqlCondition(w http.ResponseWriter, r *http.Request) (NrqlConditionRequest, bool) {
+	var request NrqlConditionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return request, false
+	}
+	if err := request.validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid request: "+err.Error()), http.StatusBadRequest)
+		return request, false
+	}
+	return request, true
+}
+
+// Create an NRQL condition in a policy
+func (s *Server) createNrqlConditionHandler(w http.ResponseWriter, r *http.Request) {
+	policyID := mux.Vars(r)["policy"]
+	log.Printf("Received request to create an NRQL condition in policy %s", policyID)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	request, ok := decodeNrqlCondition(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := s.createNrqlCondition(r.Context(), accountID, policyID, request)
+	if err != nil {
+		writeError(w, "Failed to create condition", err)
+		return
+	}
+	log.Printf("Successfully created %s condition: ID=%s, Name=%s", request.Type, created.ID, created.Name)
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "condition.create",
+		AccountID: accountID,
+		Details:   map[string]any{"condition": created.ID, "policy": policyID, "name": created.Name, "type": request.Type},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"condition": created})
+}
+
+// Update an NRQL condition
+func (s *Server) updateNrqlConditionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to update NRQL condition %s", id)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	request, ok := decodeNrqlCondition(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := s.updateNrqlCondition(r.Context(), accountID, id, request)
+	if err != nil {
+		writeError(w, "Failed to update condition", err)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "condition.update",
+		AccountID: accountID,
+		Details:   map[string]any{"condition": id, "name": updated.Name, "type": request.Type, "enabled": updated.Enabled},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"condition": updated})
+}
+
+// Delete an alert condition
+func (s *Server) deleteConditionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to delete condition %s", id)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.deleteCondition(r.Context(), accountID, id); err != nil {
+		writeError(w, "Failed to delete condition", err)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "condition.delete",
+		AccountID: accountID,
+		Details:   map[string]any{"condition": id},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"deleted_condition": id})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config holds the settings that don't fit in a single environment
+// variable. It is read from the JSON file named by CONFIG_FILE.
+type Config struct {
+	Callers     []CallerConfig              `json:"callers"`
+	Tenants     map[string]TenantConfig     `json:"tenants"`
+	Credentials map[string]CredentialConfig `json:"credentials"`
+	Transport   TransportConfig             `json:"transport"`
+	Naming      NamingConfig                `json:"naming"`
+}
+
+// CallerConfig binds a proxy auth token to the New Relic accounts it may
+// touch. A caller with no accounts listed is not restricted. Callers that
+// name a tenant use that tenant's credential instead of NEW_RELIC_API_KEY.
+// The token can be read from TokenFile instead, which is re-read when it
+// changes. Credentials limits which named credentials the caller may select.
+// A caller marked OIDC is a person who signs in through OIDC_ISSUER; its
+// name is their identity there and it needs no token.
+type CallerConfig struct {
+	Name        string   `json:"name"`
+	Token       string   `json:"token"`
+	TokenFile   string   `json:"tokenFile"`
+	OIDC        bool     `json:"oidc"`
+	Tenant      string   `json:"tenant"`
+	Accounts    []int    `json:"accounts"`
+	Credentials []string `json:"credentials"`
+}
+
+// CredentialConfig is a New Relic key and its region. The key can be read
+// from APIKeyFile instead, which is re-read when it changes.
+type CredentialConfig struct {
+	APIKey     string `json:"apiKey"`
+	APIKeyFile string `json:"apiKeyFile"`
+	Region     string `json:"region"`
+}
+
+// TenantConfig is the upstream credential for one business unit.
+type TenantConfig struct {
+	CredentialConfig
+	Accounts []int `json:"accounts"`
+}
+
+func loadConfig() (*Config, error) {
+	config := &Config{}
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, caller := range config.Callers {
+		if caller.Name == "" || (caller.Token == "" && caller.TokenFile == "" && !caller.OIDC) {
+			return nil, fmt.Errorf("caller %d in %s needs a name and a token, tokenFile or oidc", i, path)
+		}
+		if _, ok := config.Tenants[caller.Tenant]; caller.Tenant != "" && !ok {
+			return nil, fmt.Errorf("caller %s in %s uses unknown tenant %s", caller.Name, path, caller.Tenant)
+		}
+		for _, name := range caller.Credentials {
+			if _, ok := config.Credentials[name]; !ok {
+				return nil, fmt.Errorf("caller %s in %s uses unknown credential %s", caller.Name, path, name)
+			}
+		}
+	}
+	return config, nil
+}
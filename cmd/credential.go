@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+// What to do at startup when NerdGraph rejects a configured credential.
+const (
+	credentialCheckFail     = "fail"
+	credentialCheckReadOnly = "readonly"
+	credentialCheckOff      = "off"
+)
+
+const credentialCheckTimeout = 30 * time.Second
+
+// CredentialIdentity is the user a New Relic key belongs to and the
+// accounts it can see.
+type CredentialIdentity struct {
+	User struct {
+		ID    int    `json:"id"`
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	} `json:"user"`
+	Accounts []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"accounts"`
+}
+
+// whoAmI looks up who a user key belongs to.
+func (s *Server) whoAmI(ctx context.Context, client *graphql.Client, apiKey string) (*CredentialIdentity, error) {
+	req, err := newRequest("whoAmI", nil)
+	if err != nil {
+		return nil, err
+	}
+	var responseData struct {
+		Actor CredentialIdentity `json:"actor"`
+	}
+	if err := s.runGraphQLWithKey(ctx, client, apiKey, req, &responseData); err != nil {
+		return nil, err
+	}
+	return &responseData.Actor, nil
+}
+
+// credentialRejected reports whether err means NerdGraph refused the key
+// itself, as opposed to NerdGraph being unreachable.
+func credentialRejected(err error) bool {
+	var httpErr *graphql.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden
+	}
+	var graphqlErr *graphql.Error
+	if errors.As(err, &graphqlErr) {
+		switch graphqlErr.Extension("errorClass") {
+		case "UNAUTHENTICATED", "UNAUTHORIZED", "FORBIDDEN":
+			return true
+		}
+	}
+	return false
+}
+
+// checkCredentials resolves the server's key, every tenant's key and every
+// named credential to the user they belong to and logs it. It returns an
+// error naming the first key NerdGraph rejected; keys that can't be checked
+// because NerdGraph is unreachable are only logged, so an upstream outage
+// doesn't stop startup.
+func (s *Server) checkCredentials(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, credentialCheckTimeout)
+	defer cancel()
+
+	credentials := map[string]*Credential{"default": {APIKey: s.apiKey, Client: s.client}}
+	for name, tenant := range s.tenants {
+		credentials["tenant "+name] = &tenant.Credential
+	}
+	for name, credential := range s.credentials {
+		credentials["credential "+name] = credential
+	}
+	names := make([]string, 0, len(credentials))
+	for name := range credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rejected error
+	for _, name := range names {
+		credential := credentials[name]
+		identity, err := s.whoAmI(ctx, credential.Client, credential.APIKey.Get())
+		switch {
+		case err == nil:
+			log.Printf("Credential %s belongs to %s (user %d) with access to %d accounts", name, identity.User.Email, identity.User.ID, len(identity.Accounts))
+		case credentialRejected(err):
+			log.Printf("Credential %s was rejected by NerdGraph: %v", name, err)
+			if rejected == nil {
+				rejected = fmt.Errorf("credential %s was rejected: %w", name, err)
+			}
+		default:
+			log.Printf("Warning: could not check credential %s: %v", name, err)
+		}
+	}
+	return rejected
+}
+
+// readOnlyMiddleware refuses anything but reads once the server has
+// degraded to read-only because its credential was rejected.
+func (s *Server) readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead && !readPaths[r.URL.Path] {
+			log.Printf("Rejected %s %s in read-only mode, Status Code: %d", r.Method, r.URL.Path, http.StatusServiceUnavailable)
+			http.Error(w, `{"error": "Server is read-only because its New Relic credential was rejected"}`, http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// applyCredentialCheck runs checkCredentials as CREDENTIAL_CHECK asks:
+// exiting, degrading to read-only or not checking at all.
+func (s *Server) applyCredentialCheck(ctx context.Context) error {
+	mode := os.Getenv("CREDENTIAL_CHECK")
+	switch mode {
+	case "":
+		mode = credentialCheckFail
+	case credentialCheckFail, credentialCheckReadOnly:
+	case credentialCheckOff:
+		return nil
+	default:
+		return fmt.Errorf("invalid CREDENTIAL_CHECK %q, use fail, readonly or off", mode)
+	}
+
+	err := s.checkCredentials(ctx)
+	if err == nil || mode == credentialCheckFail {
+		return err
+	}
+	log.Printf("Warning: %v; serving read-only", err)
+	s.readOnly = true
+	return nil
+}
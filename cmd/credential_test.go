@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	for _, test := range []struct {
+		method   string
+		path     string
+		readOnly bool
+		want     int
+	}{
+		{http.MethodGet, "/keys", true, http.StatusOK},
+		{http.MethodHead, "/keys", true, http.StatusOK},
+		{http.MethodPost, "/keys/validate", true, http.StatusOK},
+		{http.MethodPost, "/createKey", true, http.StatusServiceUnavailable},
+		{http.MethodDelete, "/keys/key-1", true, http.StatusServiceUnavailable},
+		{http.MethodPost, "/createKey", false, http.StatusOK},
+	} {
+		s := &Server{readOnly: test.readOnly}
+		handler := s.readOnlyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.want {
+			t.Errorf("%s %s with readOnly %v answered %d, want %d", test.method, test.path, test.readOnly, w.Code, test.want)
+		}
+	}
+}
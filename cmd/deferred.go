@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultDeferredQueueSize = 100
+	defaultDeferredDeadline  = 15 * time.Minute
+	maxDeferredBodySize      = 1 << 20
+)
+
+// DeferredRequest is the payload of a "deferred" job: a change that came in
+// while NerdGraph was throttling us, kept to be replayed once the budget
+// returns.
+type DeferredRequest struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Header   map[string]string `json:"header,omitempty"`
+	Body     []byte            `json:"body,omitempty"`
+	Deadline time.Time         `json:"deadline"`
+}
+
+// DeferredResponse is the result of a deferred job: what the caller would
+// have been answered had the request not been queued.
+type DeferredResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// deferredHeaders are the request headers a replay needs. The token is not
+// among them; replays run as the caller the job was queued for.
+var deferredHeaders = []string{"Content-Type", "X-New-Relic-Credential"}
+
+// readPaths are POSTs that only read, and so are never queued and are
+// still served in read-only mode. Key validation also carries a key that
+// mustn't be stored in a job.
+var readPaths = map[string]bool{"/keys/validate": true}
+
+var errDeferredExpired = errors.New("request expired in the rate limit queue")
+
+// deferredFailure is a replayed request that was answered with an error.
+// It is not retried, since the change may have been partly made.
+type deferredFailure struct {
+	status int
+}
+
+func (e *deferredFailure) Error() string {
+	return fmt.Sprintf("replayed request answered %d", e.status)
+}
+
+// deferredQueue decides which throttled mutations are queued.
+type deferredQueue struct {
+	size     int
+	deadline time.Duration
+}
+
+// newDeferredQueueFromEnv returns nil when RATE_LIMIT_QUEUE_SIZE is 0,
+// turning queueing off.
+func newDeferredQueueFromEnv() (*deferredQueue, error) {
+	q := &deferredQueue{size: defaultDeferredQueueSize, deadline: defaultDeferredDeadline}
+	if v := os.Getenv("RATE_LIMIT_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_QUEUE_SIZE %q", v)
+		}
+		q.size = n
+	}
+	if v := os.Getenv("RATE_LIMIT_QUEUE_DEADLINE_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_QUEUE_DEADLINE_SECONDS %q", v)
+		}
+		q.deadline = time.Duration(seconds) * time.Second
+	}
+	if q.size == 0 {
+		return nil, nil
+	}
+	return q, nil
+}
+
+type replayKey struct{}
+
+// replaying reports whether a request is a deferred one being replayed.
+func replaying(ctx context.Context) bool {
+	return ctx.Value(replayKey{}) != nil
+}
+
+// deferMiddleware queues changes that arrive while the credential they
+// would use is throttled for longer than we'd hold a request, answering
+// 202 with the job instead of 429. Reads, oversized bodies and requests
+// beyond the queue size are left to fail as before.
+func (s *Server) deferMiddleware(next http.Handler) http.Handler {
+	if s.deferred == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || readPaths[r.URL.Path] || replaying(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, _ := s.credentialFor(r.Context())
+		resetAt, throttled := throttledUntil(client)
+		if !throttled || s.jobs.Count(func(job *Job) bool {
+			return job.Kind == "deferred" && (job.Status == jobQueued || job.Status == jobRunning)
+		}) >= s.deferred.size {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		request, err := captureRequest(r, s.deferred.deadline)
+		if errors.Is(err, errBodyTooLarge) {
+			writeRateLimitError(w, &RateLimitError{ResetAt: resetAt})
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
+			return
+		}
+		job, err := s.jobs.EnqueueAt(r.Context(), "deferred", request, resetAt)
+		if err != nil {
+			log.Printf("Failed to queue job: %v, Status Code: %d", err, http.StatusInternalServerError)
+			http.Error(w, `{"error": "Failed to queue job"}`, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("NerdGraph rate limited, deferred %s %s as job %s until %s", r.Method, r.URL.Path, job.ID, resetAt.UTC().Format(time.RFC3339))
+		writeJobAccepted(w, job)
+	})
+}
+
+var errBodyTooLarge = errors.New("request body is too large to queue")
+
+// captureRequest keeps what a replay of r needs, to be given up after
+// maxAge. Bodies over maxDeferredBodySize are refused with errBodyTooLarge.
+func captureRequest(r *http.Request, maxAge time.Duration) (*DeferredRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDeferredBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDeferredBodySize {
+		return nil, errBodyTooLarge
+	}
+	request := &DeferredRequest{
+		Method:   r.Method,
+		Path:     r.URL.RequestURI(),
+		Header:   map[string]string{},
+		Body:     body,
+		Deadline: time.Now().Add(maxAge).UTC(),
+	}
+	for _, name := range deferredHeaders {
+		if value := r.Header.Get(name); value != "" {
+			request.Header[name] = value
+		}
+	}
+	return request, nil
+}
+
+func (s *Server) registerDeferredJobs() {
+	s.jobs.Register("deferred", func(ctx context.Context, job *Job) (any, error) {
+		var request DeferredRequest
+		if err := json.Unmarshal(job.Payload, &request); err != nil {
+			return nil, err
+		}
+		if time.Now().After(request.Deadline) {
+			return nil, errDeferredExpired
+		}
+		if job.Caller != "" && callerFromContext(ctx) == nil {
+			return nil, fmt.Errorf("caller %s is no longer configured: %w", job.Caller, errForbidden)
+		}
+		return s.replay(ctx, request)
+	})
+}
+
+// replay runs a deferred request through the router as the job's caller.
+// A 429 is handed back as a RateLimitError so the job waits for the reset.
+func (s *Server) replay(ctx context.Context, request DeferredRequest) (*DeferredResponse, error) {
+	r, err := http.NewRequestWithContext(context.WithValue(ctx, replayKey{}, true), request.Method, request.Path, bytes.NewReader(request.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range request.Header {
+		r.Header.Set(name, value)
+	}
+	rec := &replayRecorder{header: http.Header{}, status: http.StatusOK}
+	s.router.ServeHTTP(rec, r)
+
+	response := &DeferredResponse{Status: rec.status}
+	if json.Valid(rec.body.Bytes()) {
+		response.Body = rec.body.Bytes()
+	} else if rec.body.Len() > 0 {
+		response.Body, _ = json.Marshal(rec.body.String())
+	}
+	switch {
+	case rec.header.Get(maybeAppliedHeader) != "":
+		return response, fmt.Errorf("%w: %w", errMaybeApplied, &deferredFailure{status: rec.status})
+	case rec.status == http.StatusTooManyRequests:
+		return response, &RateLimitError{ResetAt: time.Now().Add(parseRetryAfter(rec.header.Get("Retry-After")))}
+	case rec.status >= 400:
+		return response, &deferredFailure{status: rec.status}
+	}
+	return response, nil
+}
+
+// replayRecorder keeps the response to a replayed request.
+type replayRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *replayRecorder) Header() http.Header { return w.header }
+
+func (w *replayRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+func (w *replayRecorder) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(p)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DeploymentRequest records a deployment of an entity.
+type DeploymentRequest struct {
+	EntityGUID     string `json:"entityGuid"`
+	Version        string `json:"version"`
+	Changelog      string `json:"changelog,omitempty"`
+	Commit         string `json:"commit,omitempty"`
+	DeepLink       string `json:"deepLink,omitempty"`
+	DeploymentType string `json:"deploymentType,omitempty"`
+	Description    string `json:"description,omitempty"`
+	GroupID        string `json:"groupId,omitempty"`
+	User           string `json:"user,omitempty"`
+	// Timestamp is in epoch milliseconds; NerdGraph uses the current time
+	// when it is left out.
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// Deployment is a recorded deployment marker.
+type Deployment struct {
+	DeploymentID   string `json:"deploymentId"`
+	EntityGUID     string `json:"entityGuid"`
+	Version        string `json:"version"`
+	Timestamp      int64  `json:"timestamp"`
+	Changelog      string `json:"changelog,omitempty"`
+	Commit         string `json:"commit,omitempty"`
+	DeepLink       string `json:"deepLink,omitempty"`
+	DeploymentType string `json:"deploymentType,omitempty"`
+	Description    string `json:"description,omitempty"`
+	GroupID        string `json:"groupId,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+// accountFromGUID reads the account ID out of an entity GUID, which is the
+// base64 of "<account>|<domain>|<type>|<id>".
+func accountFromGUID(guid string) (int, error) {
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(guid, "="))
+	if err != nil {
+		return 0, fmt.Errorf("invalid entity GUID")
+	}
+	account, _, ok := strings.Cut(string(decoded), "|")
+	accountID, err := strconv.Atoi(account)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid entity GUID")
+	}
+	return accountID, nil
+}
+
+// createDeployment runs the changeTrackingCreateDeployment mutation.
+func (s *Server) createDeployment(ctx context.Context, request DeploymentRequest) (*Deployment, error) {
+	req, err := newRequest("createDeployment", nil)
+	if err != nil {
+		return nil, err
+	}
+	deployment := map[string]any{
+		"entityGuid": request.EntityGUID,
+		"version":    request.Version,
+	}
+	for name, value := range map[string]string{
+		"changelog":      request.Changelog,
+		"commit":         request.Commit,
+		"deepLink":       request.DeepLink,
+		"deploymentType": request.DeploymentType,
+		"description":    request.Description,
+		"groupId":        request.GroupID,
+		"user":           request.User,
+	} {
+		if value != "" {
+			deployment[name] = value
+		}
+	}
+	if request.Timestamp != 0 {
+		deployment["timestamp"] = request.Timestamp
+	}
+	req.Var("deployment", deployment)
+
+	var responseData struct {
+		ChangeTrackingCreateDeployment *Deployment `json:"changeTrackingCreateDeployment"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if responseData.ChangeTrackingCreateDeployment == nil {
+		return nil, fmt.Errorf("no deployment was recorded")
+	}
+	return responseData.ChangeTrackingCreateDeployment, nil
+}
+
+// Record a deployment
+func (s *Server) createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to record a deployment")
+
+	var request DeploymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	if request.EntityGUID == "" || request.Version == "" {
+		http.Error(w, `{"error": "Invalid request: entityGuid and version are required"}`, http.StatusBadRequest)
+		return
+	}
+	accountID, err := accountFromGUID(request.EntityGUID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid request: "+err.Error()), http.StatusBadRequest)
+		return
+	}
+	if !authorizeAccount(w, r, accountID) {
+		return
+	}
+	if request.User == "" {
+		request.User = actorName(r.Context())
+	}
+
+	deployment, err := s.createDeployment(r.Context(), request)
+	if err != nil {
+		writeError(w, "Failed to record deployment", err)
+		return
+	}
+	log.Printf("Recorded deployment %s of %s version %s", deployment.DeploymentID, deployment.EntityGUID, deployment.Version)
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "deployment.create",
+		AccountID: accountID,
+		Details:   map[string]any{"deployment": deployment.DeploymentID, "entityGuid": deployment.EntityGUID, "version": deployment.Version},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"deployment": deployment})
+}
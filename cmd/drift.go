@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const maxDesiredStateSize = 10 << 20
+
+// DriftReport lists how the live keys differ from the desired state.
+type DriftReport struct {
+	Source     string            `json:"source"`
+	CheckedAt  time.Time         `json:"checkedAt"`
+	Accounts   []int             `json:"accounts"`
+	InSync     bool              `json:"inSync"`
+	Additions  []ReconcileAction `json:"additions"`
+	Deletions  []ReconcileAction `json:"deletions"`
+	Mismatches []ReconcileAction `json:"mismatches"`
+}
+
+// desiredStateSource is where GET /drift reads the desired state from: a
+// file, or a URL such as the raw view of a file in a Git repository.
+type desiredStateSource struct {
+	path   string
+	url    string
+	token  string
+	client *http.Client
+}
+
+// newDesiredStateSourceFromEnv returns nil when neither DESIRED_STATE_FILE
+// nor DESIRED_STATE_URL is set.
+func newDesiredStateSourceFromEnv() (*desiredStateSource, error) {
+	path, url := os.Getenv("DESIRED_STATE_FILE"), os.Getenv("DESIRED_STATE_URL")
+	switch {
+	case path != "" && url != "":
+		return nil, fmt.Errorf("set DESIRED_STATE_FILE or DESIRED_STATE_URL, not both")
+	case path == "" && url == "":
+		return nil, nil
+	}
+	token := os.Getenv("DESIRED_STATE_TOKEN")
+	secrets.Add(token)
+	return &desiredStateSource{path: path, url: url, token: token, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (d *desiredStateSource) String() string {
+	if d.path != "" {
+		return d.path
+	}
+	return d.url
+}
+
+// Load reads and validates the desired state.
+func (d *desiredStateSource) Load(ctx context.Context) (*DesiredState, error) {
+	var data []byte
+	var err error
+	if d.path != "" {
+		data, err = os.ReadFile(d.path)
+	} else {
+		data, err = d.fetch(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var desired DesiredState
+	if err := json.Unmarshal(data, &desired); err != nil {
+		return nil, fmt.Errorf("parsing desired state from %s: %w", d, err)
+	}
+	if problems := validateDesiredState(desired); len(problems) > 0 {
+		return nil, fmt.Errorf("invalid desired state in %s: %v", d, problems)
+	}
+	return &desired, nil
+}
+
+func (d *desiredStateSource) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching desired state from %s: %s", d.url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDesiredStateSize))
+}
+
+// driftReport compares the live keys of the accounts in desired with it.
+func (s *Server) driftReport(ctx context.Context, desired DesiredState) (*DriftReport, error) {
+	actions, err := s.planReconcile(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	report := &DriftReport{
+		CheckedAt:  time.Now().UTC(),
+		Additions:  []ReconcileAction{},
+		Deletions:  []ReconcileAction{},
+		Mismatches: []ReconcileAction{},
+	}
+	for _, account := range desired.Accounts {
+		report.Accounts = append(report.Accounts, account.AccountID)
+	}
+	for _, action := range actions {
+		action.Status = ""
+		switch action.Action {
+		case reconcileCreate:
+			report.Additions = append(report.Additions, action)
+		case reconcileDelete:
+			report.Deletions = append(report.Deletions, action)
+		case reconcileUpdate:
+			report.Mismatches = append(report.Mismatches, action)
+		}
+	}
+	report.InSync = len(actions) == 0
+	return report, nil
+}
+
+// Report how the live keys differ from the desired state, without changing
+// anything. Accounts the caller may not access are left out.
+func (s *Server) getDrift(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request for a drift report")
+
+	if s.desiredState == nil {
+		http.Error(w, `{"error": "No desired state source is configured"}`, http.StatusNotFound)
+		return
+	}
+	desired, err := s.desiredState.Load(r.Context())
+	if err != nil {
+		log.Printf("Failed to load desired state: %v, Status Code: %d", err, http.StatusBadGateway)
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": "Failed to load desired state", "details": secrets.Redact(err.Error())})
+		return
+	}
+
+	var accounts []DesiredAccount
+	for _, account := range desired.Accounts {
+		if checkAccount(r.Context(), account.AccountID) == nil {
+			accounts = append(accounts, account)
+		}
+	}
+	if len(accounts) == 0 {
+		log.Printf("Caller %s may access none of the desired state's accounts, Status Code: %d", actorName(r.Context()), http.StatusForbidden)
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return
+	}
+	desired.Accounts = accounts
+
+	report, err := s.driftReport(r.Context(), *desired)
+	if err != nil {
+		writeError(w, "Failed to read live keys", err)
+		return
+	}
+	report.Source = s.desiredState.String()
+	log.Printf("Drift report for %d accounts: %d additions, %d deletions, %d mismatches", len(report.Accounts), len(report.Additions), len(report.Deletions), len(report.Mismatches))
+	writeJSON(w, http.StatusOK, report)
+}
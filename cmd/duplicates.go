@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// What POST /createKey does when the account already has a key of the same
+// name and ingest type.
+const (
+	duplicateAllow  = "allow"
+	duplicateReject = "reject"
+	duplicateReturn = "return"
+)
+
+var duplicateModes = map[string]bool{duplicateAllow: true, duplicateReject: true, duplicateReturn: true}
+
+// duplicateModeFromEnv reads DUPLICATE_KEY_NAMES, which defaults to allow.
+func duplicateModeFromEnv() (string, error) {
+	mode := os.Getenv("DUPLICATE_KEY_NAMES")
+	if mode == "" {
+		return duplicateAllow, nil
+	}
+	if !duplicateModes[mode] {
+		return "", fmt.Errorf("invalid DUPLICATE_KEY_NAMES %q, expected allow, reject or return", mode)
+	}
+	return mode, nil
+}
+
+// checkDuplicate looks for a key the create request would duplicate,
+// according to the onDuplicate query parameter or DUPLICATE_KEY_NAMES. It
+// answers 409 with the existing key when rejecting, and the existing key in
+// place of a new one when returning, without its value, which is never
+// fetched. The returned unlock, which may be nil, must be called once the
+// key has been created, so concurrent requests can't both create it. It
+// returns false when the request has been answered.
+func (s *Server) checkDuplicate(w http.ResponseWriter, r *http.Request, request *InsertKeyRequest) (func(), bool) {
+	mode := s.duplicates
+	if v := r.URL.Query().Get("onDuplicate"); v != "" {
+		if !duplicateModes[v] {
+			http.Error(w, `{"error": "Invalid onDuplicate: expected allow, reject or return"}`, http.StatusBadRequest)
+			return nil, false
+		}
+		mode = v
+	}
+	if mode == duplicateAllow {
+		return nil, true
+	}
+	// The name the key would get has to be known to compare it.
+	if err := s.naming.apply(r.Context(), request); err != nil {
+		writeError(w, "Failed to name key", err)
+		return nil, false
+	}
+	if request.Name == "" {
+		return nil, true
+	}
+
+	unlock, ok := s.lock(w, r, "keyname/"+strconv.Itoa(request.AccountID)+"/"+request.IngestType+"/"+request.Name)
+	if !ok {
+		return nil, false
+	}
+	existing, err := s.keysNamed(r.Context(), request.AccountID, request.Name, request.IngestType)
+	if err != nil {
+		unlock()
+		writeError(w, "Failed to check for duplicate keys", err)
+		return nil, false
+	}
+	if len(existing) == 0 {
+		return unlock, true
+	}
+	unlock()
+
+	key := existing[0]
+	if mode == duplicateReturn {
+		log.Printf("Returning existing key %s named %q instead of creating one", key.ID, key.Name)
+		writeJSON(w, http.StatusOK, map[string]any{"insert_key": key, "existing": true})
+		return nil, false
+	}
+	log.Printf("Key %q already exists in account %d as %s, Status Code: %d", key.Name, key.AccountID, key.ID, http.StatusConflict)
+	writeJSON(w, http.StatusConflict, map[string]any{
+		"error":           fmt.Sprintf("A %s key named %q already exists in account %d", key.IngestType, key.Name, key.AccountID),
+		"existing_key_id": key.ID,
+		"existing_keys":   existing,
+	})
+	return nil, false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSummarySince = "30 minutes ago"
+	// goldenMetricWorkers bounds the golden metric queries run at once.
+	goldenMetricWorkers = 4
+)
+
+// nrqlTimeClauses matches the clauses of a golden metric query that are
+// replaced to get one value over the summary's window.
+var nrqlTimeClauses = regexp.MustCompile(`(?is)\s+(TIMESERIES|SINCE|UNTIL)\b.*$`)
+
+// GoldenMetric is one of an entity's golden metrics with its value over the
+// summary's window. Value is nil when the query returned no single number;
+// Results then holds what it did return.
+type GoldenMetric struct {
+	Name    string           `json:"name"`
+	Title   string           `json:"title"`
+	Unit    string           `json:"unit"`
+	Query   string           `json:"query"`
+	Value   *float64         `json:"value"`
+	Results []map[string]any `json:"results,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// EntitySummary is whether an entity is reporting, and its golden metrics.
+type EntitySummary struct {
+	GUID          string         `json:"guid"`
+	Name          string         `json:"name"`
+	Type          string         `json:"type"`
+	Domain        string         `json:"domain"`
+	AccountID     int            `json:"accountId"`
+	Reporting     bool           `json:"reporting"`
+	AlertSeverity string         `json:"alertSeverity,omitempty"`
+	Since         string         `json:"since"`
+	GoldenMetrics []GoldenMetric `json:"goldenMetrics"`
+}
+
+// getEntitySummary looks up an entity and its golden metric definitions.
+func (s *Server) getEntitySummary(ctx context.Context, guid string) (*EntitySummary, error) {
+	req, err := newRequest("getEntitySummary", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("guid", guid)
+
+	var responseData struct {
+		Actor struct {
+			Entity *struct {
+				EntitySummary
+				GoldenMetrics struct {
+					Metrics []GoldenMetric `json:"metrics"`
+				} `json:"goldenMetrics"`
+			} `json:"entity"`
+		} `json:"actor"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	entity := responseData.Actor.Entity
+	if entity == nil {
+		return nil, fmt.Errorf("entity %w", errNotFound)
+	}
+	summary := entity.EntitySummary
+	summary.GoldenMetrics = entity.GoldenMetrics.Metrics
+	if summary.GoldenMetrics == nil {
+		summary.GoldenMetrics = []GoldenMetric{}
+	}
+	return &summary, nil
+}
+
+// measure runs a golden metric's query over the window since.
+func (s *Server) measure(ctx context.Context, accountID int, metric *GoldenMetric, since string) {
+	query := nrqlTimeClauses.ReplaceAllString(strings.TrimSpace(metric.Query), "") + " SINCE " + since
+	rows, err := s.runNRQL(ctx, accountID, query)
+	if err != nil {
+		metric.Error = secrets.Redact(err.Error())
+		return
+	}
+	if len(rows) == 1 {
+		var values []float64
+		for name, value := range rows[0] {
+			if number, ok := value.(float64); ok && name != "beginTimeSeconds" && name != "endTimeSeconds" {
+				values = append(values, number)
+			}
+		}
+		if len(values) == 1 {
+			metric.Value = &values[0]
+			return
+		}
+	}
+	metric.Results = rows
+}
+
+// Get an entity's golden metrics, to check that it is reporting
+func (s *Server) getEntitySummaryHandler(w http.ResponseWriter, r *http.Request) {
+	guid := mux.Vars(r)["guid"]
+	log.Printf("Received request for the summary of entity %s", guid)
+
+	accountID, err := accountFromGUID(guid)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid request: "+err.Error()), http.StatusBadRequest)
+		return
+	}
+	if !authorizeAccount(w, r, accountID) {
+		return
+	}
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		since = defaultSummarySince
+	} else if !nrqlSince.MatchString(since) {
+		http.Error(w, `{"error": "Invalid since"}`, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := s.getEntitySummary(r.Context(), guid)
+	if err != nil {
+		writeError(w, "Failed to look up entity", err)
+		return
+	}
+	summary.Since = since
+	runPool(r.Context(), goldenMetricWorkers, len(summary.GoldenMetrics), func(ctx context.Context, i int) {
+		s.measure(ctx, summary.AccountID, &summary.GoldenMetrics[i], since)
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"summary": summary})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+var (
+	errForbidden = errors.New("caller is not allowed to access this account")
+	errLocked    = errors.New("another operation on this resource is in progress")
+	errNotFound  = errors.New("not found")
+
+	errPartialRotation = errors.New("replacement created but original not deleted")
+)
+
+// KeyError is an entry in the errors list of an apiAccess mutation.
+type KeyError struct {
+	Message    string `json:"message"`
+	Type       string `json:"type"`
+	ID         string `json:"id,omitempty"`
+	AccountID  int    `json:"accountId,omitempty"`
+	ErrorType  string `json:"errorType,omitempty"`
+	IngestType string `json:"ingestType,omitempty"`
+}
+
+// KeyErrors carries the errors list of a mutation as a Go error. Besides
+// apiAccess key errors it carries the errors of the rule mutations, which
+// have the same shape.
+type KeyErrors []KeyError
+
+func (e KeyErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, keyError := range e {
+		messages[i] = keyError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// statusForErrorType translates a NerdGraph errorType into the HTTP status we
+// hand back to the caller. Unknown types get the fallback.
+func statusForErrorType(errorType string, fallback int) int {
+	switch errorType {
+	case "FORBIDDEN", "USER_NOT_AUTHORIZED":
+		return http.StatusForbidden
+	case "NOT_FOUND", "RULE_NOT_FOUND":
+		return http.StatusNotFound
+	case "INVALID", "INVALID_INPUT":
+		return http.StatusBadRequest
+	case "TOO_MANY_REQUESTS", "RATE_LIMITED":
+		return http.StatusTooManyRequests
+	}
+	return fallback
+}
+
+// statusForKeyErrors picks the status for a list of mutation errors, using
+// the first one NerdGraph gave us a recognised errorType for.
+func statusForKeyErrors(keyErrors []KeyError, fallback int) int {
+	for _, e := range keyErrors {
+		if status := statusForErrorType(e.ErrorType, 0); status != 0 {
+			return status
+		}
+	}
+	return fallback
+}
+
+// writeKeyErrors reports the errors from an apiAccess mutation to the caller.
+func writeKeyErrors(w http.ResponseWriter, message string, keyErrors []KeyError, fallback int) {
+	status := statusForKeyErrors(keyErrors, fallback)
+	for i := range keyErrors {
+		keyErrors[i].Message = secrets.Redact(keyErrors[i].Message)
+	}
+	log.Printf("%s: %v, Status Code: %d", message, keyErrors, status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   message,
+		"details": keyErrors,
+	})
+}
+
+// writeUpstreamError reports a failed NerdGraph call to the caller, passing
+// rate limiting through as a 429.
+func writeUpstreamError(w http.ResponseWriter, message string, err error) {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		writeRateLimitError(w, rateLimitErr)
+		return
+	}
+	var schemaErr *SchemaError
+	if errors.As(err, &schemaErr) {
+		log.Printf("%s: %v, Status Code: %d", message, err, http.StatusBadGateway)
+		writeJSON(w, http.StatusBadGateway, map[string]any{
+			"error":   message + ": the NerdGraph schema has changed",
+			"details": schemaErr.Problems,
+		})
+		return
+	}
+	if errors.Is(err, errMaybeApplied) {
+		// Not an outage, whatever the transport said: sending the change
+		// again could make it twice.
+		log.Printf("%s: %v, Status Code: %d", message, err, http.StatusBadGateway)
+		w.Header().Set(maybeAppliedHeader, "true")
+		httpError(w, fmt.Sprintf(`{"error": %q}`, message+": NerdGraph did not answer, and the change may have been made"), http.StatusBadGateway)
+		return
+	}
+	if status := statusForUnavailable(err); status != 0 {
+		log.Printf("%s: %v, Status Code: %d", message, err, status)
+		httpError(w, fmt.Sprintf(`{"error": %q}`, message+": NerdGraph is unavailable"), status)
+		return
+	}
+	if status := statusForGraphQLError(err); status != 0 {
+		log.Printf("%s: %v, Status Code: %d", message, err, status)
+		httpError(w, fmt.Sprintf(`{"error": %q}`, message), status)
+		return
+	}
+	log.Printf("%s: %v, Status Code: %d", message, err, http.StatusInternalServerError)
+	httpError(w, fmt.Sprintf(`{"error": %q}`, message), http.StatusInternalServerError)
+}
+
+// statusForGraphQLError picks a status for a GraphQL error from the
+// errorClass NerdGraph puts in its extensions, or a gateway error when
+// NerdGraph didn't answer with GraphQL at all. It returns 0 otherwise.
+func statusForGraphQLError(err error) int {
+	var graphqlErr *graphql.Error
+	if errors.As(err, &graphqlErr) {
+		return statusForErrorType(graphqlErr.Extension("errorClass"), 0)
+	}
+	var httpErr *graphql.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode >= 500 {
+		return http.StatusBadGateway
+	}
+	return 0
+}
+
+// statusForUnavailable picks a gateway status for a call that never got an
+// answer from NerdGraph, so that callers and the offline queue can tell an
+// outage from a failed change. It returns 0 otherwise, including for a
+// change that may have been made before the call failed.
+func statusForUnavailable(err error) int {
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, errMaybeApplied):
+		return 0
+	case errors.Is(err, errUpstreamUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &urlErr) && urlErr.Timeout():
+		return http.StatusGatewayTimeout
+	case errors.As(err, &urlErr) && !errors.Is(err, context.Canceled):
+		return http.StatusBadGateway
+	}
+	return 0
+}
+
+// writeLockConflict tells the caller another operation on the same resource
+// is already running.
+func writeLockConflict(w http.ResponseWriter, name string) {
+	log.Printf("Lock %s is held by another operation, Status Code: %d", name, http.StatusConflict)
+	http.Error(w, `{"error": "Another operation on this resource is in progress"}`, http.StatusConflict)
+}
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError reports err to the caller with the status that fits it.
+func writeError(w http.ResponseWriter, message string, err error) {
+	var keyErrors KeyErrors
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		http.Error(w, `{"error": "Key not found"}`, http.StatusNotFound)
+	case errors.Is(err, errNotFound):
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusNotFound)
+	case errors.Is(err, errForbidden):
+		log.Printf("%s: %v, Status Code: %d", message, err, http.StatusForbidden)
+		http.Error(w, `{"error": "Caller is not allowed to access this account"}`, http.StatusForbidden)
+	case errors.Is(err, errLocked):
+		log.Printf("%s: %v, Status Code: %d", message, err, http.StatusConflict)
+		http.Error(w, `{"error": "Another operation on this resource is in progress"}`, http.StatusConflict)
+	case errors.As(err, &keyErrors):
+		writeKeyErrors(w, message, keyErrors, http.StatusInternalServerError)
+	default:
+		writeUpstreamError(w, message, err)
+	}
+}
+
+// retryable reports whether an operation that failed with err is worth
+// trying again later: NerdGraph or the network in front of it failed, it
+// was rate limited, or the failure was marked temporary. GraphQL errors
+// NerdGraph doesn't classify as one of those are not retried, as the
+// operation may have been a mutation that took effect.
+func retryable(err error) bool {
+	var keyErrors KeyErrors
+	switch {
+	case errors.Is(err, errKeyNotFound), errors.Is(err, errNotFound), errors.Is(err, errForbidden), errors.Is(err, errPartialRotation), errors.Is(err, errMaybeApplied):
+		return false
+	case errors.As(err, new(*SchemaError)), errors.As(err, new(*deferredFailure)), errors.Is(err, errDeferredExpired):
+		return false
+	case errors.As(err, new(*RateLimitError)), errors.As(err, new(temporaryError)):
+		return true
+	case errors.Is(err, errUpstreamUnavailable), errors.Is(err, errLocked), errors.Is(err, errItemsPending):
+		return true
+	case errors.As(err, &keyErrors):
+		status := statusForKeyErrors(keyErrors, 0)
+		return status == http.StatusTooManyRequests || status >= 500
+	case statusForUnavailable(err) != 0:
+		return true
+	}
+	status := statusForGraphQLError(err)
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// temporaryError marks a failure outside NerdGraph, such as a webhook
+// delivery, that is worth trying again.
+type temporaryError struct {
+	err error
+}
+
+func (e temporaryError) Error() string {
+	return e.err.Error()
+}
+
+func (e temporaryError) Unwrap() error {
+	return e.err
+}
+
+// retryLater marks err as temporary, for retryable.
+func retryLater(err error) error {
+	if err == nil {
+		return nil
+	}
+	return temporaryError{err: err}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+func TestWriteUpstreamErrorOutages(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"connection refused", &url.Error{Op: "Post", URL: "https://api.newrelic.com/graphql", Err: errors.New("connection refused")}, http.StatusBadGateway},
+		{"timeout", &url.Error{Op: "Post", URL: "https://api.newrelic.com/graphql", Err: timeoutError{}}, http.StatusGatewayTimeout},
+		{"marked unavailable", fmt.Errorf("down since then: %w", errUpstreamUnavailable), http.StatusServiceUnavailable},
+		{"maybe applied", fmt.Errorf("%w: %w", errMaybeApplied, &url.Error{Op: "Post", Err: errors.New("EOF")}), http.StatusBadGateway},
+		{"cancelled", &url.Error{Op: "Post", Err: context.Canceled}, http.StatusInternalServerError},
+		{"other", errors.New("boom"), http.StatusInternalServerError},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, "Failed to create insert key", test.err)
+			if w.Code != test.want {
+				t.Errorf("status = %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	graphqlError := func(errorClass string) error {
+		e := &graphql.Error{Message: "failed"}
+		if errorClass != "" {
+			e.Extensions = map[string]any{"errorClass": errorClass}
+		}
+		return graphql.Errors{e}
+	}
+	for _, test := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unclassified GraphQL error", graphqlError(""), false},
+		{"unknown errorClass", graphqlError("SOMETHING_NEW"), false},
+		{"GraphQL rate limit", graphqlError("TOO_MANY_REQUESTS"), true},
+		{"rate limited", &RateLimitError{}, true},
+		{"NerdGraph 503", &graphql.HTTPError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"NerdGraph 400", &graphql.HTTPError{StatusCode: http.StatusBadRequest}, false},
+		{"connection refused", &url.Error{Op: "Post", Err: errors.New("connection refused")}, true},
+		{"maybe applied", fmt.Errorf("%w: %w", errMaybeApplied, &url.Error{Op: "Post", Err: errors.New("EOF")}), false},
+		{"unknown key error", KeyErrors{{Message: "failed", ErrorType: "SOMETHING_NEW"}}, false},
+		{"key rate limit", KeyErrors{{Message: "slow down", ErrorType: "TOO_MANY_REQUESTS"}}, true},
+		{"marked temporary", retryLater(errors.New("webhook answered 500")), true},
+		{"plain error", errors.New("boom"), false},
+		{"not found", errKeyNotFound, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retryable(test.err); got != test.want {
+				t.Errorf("retryable(%v) = %v, want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
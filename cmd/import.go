@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	maxImportSize         = 10 << 20
+	defaultImportWorkers  = 4
+	maxImportWorkerCount  = 32
+	importFormFileName    = "file"
+	importCSVContentType  = "text/csv"
+	importJSONContentType = "application/json"
+)
+
+// Ingest types NerdGraph accepts for ingest keys.
+var validIngestTypes = map[string]bool{"LICENSE": true, "BROWSER": true}
+
+// importRowResult is the report line for one row of an import.
+type importRowResult struct {
+	Row    int         `json:"row"`
+	Status string      `json:"status"`
+	Key    *CreatedKey `json:"insert_key,omitempty"`
+	Errors []string    `json:"errors,omitempty"`
+}
+
+// runPool calls work for every index from 0 to count-1 using at most
+// workers goroutines, and waits for them all.
+func runPool(ctx context.Context, workers, count int, work func(ctx context.Context, i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				work(ctx, i)
+			}
+		}()
+	}
+	for i := range count {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// importWorkers is how many batches of keys an import creates at once.
+func importWorkers() int {
+	if n, err := strconv.Atoi(os.Getenv("IMPORT_WORKERS")); err == nil && n > 0 {
+		return min(n, maxImportWorkerCount)
+	}
+	return defaultImportWorkers
+}
+
+// readImport reads key specs from the request, either as a multipart upload
+// in the "file" field or as the raw body. The format is taken from the file
+// extension or content type.
+func readImport(r *http.Request) ([]InsertKeyRequest, error) {
+	body := io.Reader(r.Body)
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == "multipart/form-data" {
+		file, header, err := r.FormFile(importFormFileName)
+		if err != nil {
+			return nil, fmt.Errorf("reading upload: %w", err)
+		}
+		defer file.Close()
+		body = file
+		contentType = header.Header.Get("Content-Type")
+		switch strings.ToLower(filepath.Ext(header.Filename)) {
+		case ".csv":
+			contentType = importCSVContentType
+		case ".json":
+			contentType = importJSONContentType
+		}
+	}
+
+	switch contentType {
+	case importCSVContentType:
+		return readImportCSV(body)
+	case importJSONContentType, "":
+		var rows []InsertKeyRequest
+		if err := json.NewDecoder(body).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unsupported content type %q, use CSV or JSON", contentType)
+}
+
+// readImportCSV reads rows with a header line naming the columns. Column
+// names follow the JSON fields, with a few spellings accepted.
+func readImportCSV(body io.Reader) ([]InsertKeyRequest, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("CSV has no header row")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "account_id", "accountid", "account":
+			columns["account"] = i
+		case "name":
+			columns["name"] = i
+		case "notes":
+			columns["notes"] = i
+		case "ingesttype", "ingest_type":
+			columns["ingestType"] = i
+		case "labels":
+			columns["labels"] = i
+		}
+	}
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := make([]InsertKeyRequest, 0, len(records)-1)
+	for _, record := range records[1:] {
+		// An unparseable account is left as 0 and reported by validation.
+		accountID, _ := strconv.Atoi(field(record, "account"))
+		rows = append(rows, InsertKeyRequest{
+			AccountID:  accountID,
+			Name:       field(record, "name"),
+			Notes:      field(record, "notes"),
+			IngestType: strings.ToUpper(field(record, "ingestType")),
+			Labels:     parseCSVLabels(field(record, "labels")),
+		})
+	}
+	return rows, nil
+}
+
+// parseCSVLabels reads labels written as "team=payments;env=prod".
+func parseCSVLabels(value string) map[string]string {
+	if value == "" {
+		return nil
+	}
+	labels := map[string]string{}
+	for _, pair := range strings.Split(value, ";") {
+		name, labelValue, _ := strings.Cut(pair, "=")
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(labelValue)
+	}
+	return labels
+}
+
+// validateKeySpec lists what is wrong with a key spec for the caller in ctx.
+func validateKeySpec(ctx context.Context, spec InsertKeyRequest) []string {
+	var problems []string
+	if spec.AccountID <= 0 {
+		problems = append(problems, "account_id must be a New Relic account ID")
+	} else if checkAccount(ctx, spec.AccountID) != nil {
+		problems = append(problems, "caller is not allowed to access this account")
+	}
+	if spec.Name == "" {
+		problems = append(problems, "name is required")
+	}
+	if !validIngestTypes[spec.IngestType] {
+		problems = append(problems, "ingestType must be LICENSE or BROWSER")
+	}
+	if err := validateLabels(spec.Labels); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// Import keys from a CSV or JSON file
+func (s *Server) importApiKeys(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to import keys")
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	rows, err := readImport(r)
+	if err != nil {
+		log.Printf("Invalid import: %v, Status Code: %d", err, http.StatusBadRequest)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid import", "details": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		http.Error(w, `{"error": "Import has no rows"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Every row is checked before anything is created, so a bad file
+	// never leaves a half-finished import behind.
+	results := make([]importRowResult, len(rows))
+	invalid := 0
+	for i := range rows {
+		results[i] = importRowResult{Row: i + 1, Status: "valid"}
+		if err := s.naming.apply(r.Context(), &rows[i]); err != nil {
+			results[i] = importRowResult{Row: i + 1, Status: "invalid", Errors: []string{err.Error()}}
+			invalid++
+			continue
+		}
+		if problems := validateKeySpec(r.Context(), rows[i]); len(problems) > 0 {
+			results[i] = importRowResult{Row: i + 1, Status: "invalid", Errors: problems}
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		log.Printf("Import rejected: %d of %d rows invalid, Status Code: %d", invalid, len(rows), http.StatusBadRequest)
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error": "Import has invalid rows, no keys were created",
+			"rows":  results,
+		})
+		return
+	}
+
+	// Rows are created a batch per request, with several requests in flight.
+	size := batchSize()
+	batches := (len(rows) + size - 1) / size
+	runPool(r.Context(), importWorkers(), batches, func(ctx context.Context, batch int) {
+		start := batch * size
+		batchRows := rows[start:min(start+size, len(rows))]
+		responses, errs := s.createIngestKeys(ctx, batchRows)
+		for j, row := range batchRows {
+			i, err := start+j, errs[j]
+			if err == nil && len(responses[j].APIAccessCreateKeys.CreatedKeys) == 0 {
+				err = KeyErrors(responses[j].APIAccessCreateKeys.Errors)
+			}
+			if err != nil {
+				results[i] = importRowResult{Row: i + 1, Status: "failed", Errors: []string{secrets.Redact(err.Error())}}
+				continue
+			}
+			key := responses[j].APIAccessCreateKeys.CreatedKeys[0]
+			s.audit.Record(ctx, AuditEntry{Action: "key.create", AccountID: row.AccountID, KeyID: key.ID, Details: map[string]any{"import": true}})
+			results[i] = importRowResult{Row: i + 1, Status: "created", Key: &key}
+		}
+	})
+
+	created := 0
+	for i, result := range results {
+		switch result.Status {
+		case "created":
+			created++
+		case "valid":
+			// The request was cancelled before this row was reached.
+			results[i].Status = "skipped"
+		}
+	}
+	log.Printf("Imported %d of %d keys", created, len(rows))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"created": created,
+		"failed":  len(rows) - created,
+		"rows":    results,
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// issueStates are the states of an issue; an issue is open until it is
+// closed or deactivated.
+var issueStates = map[string]bool{"CREATED": true, "ACTIVATED": true, "DEACTIVATED": true, "CLOSED": true}
+
+// Issue is an alert issue: incidents correlated into one problem. Times are
+// in epoch milliseconds.
+type Issue struct {
+	IssueID        string   `json:"issueId"`
+	Title          []string `json:"title"`
+	Description    []string `json:"description"`
+	Priority       string   `json:"priority"`
+	State          string   `json:"state"`
+	Sources        []string `json:"sources"`
+	EntityGUIDs    []string `json:"entityGuids"`
+	EntityNames    []string `json:"entityNames"`
+	ConditionName  []string `json:"conditionName"`
+	PolicyName     []string `json:"policyName"`
+	TotalIncidents float64  `json:"totalIncidents"`
+	CreatedAt      int64    `json:"createdAt"`
+	ActivatedAt    *int64   `json:"activatedAt"`
+	UpdatedAt      int64    `json:"updatedAt"`
+	ClosedAt       *int64   `json:"closedAt"`
+	DeepLinkURL    string   `json:"deepLinkUrl"`
+}
+
+// IssueFilter narrows the issues of an account. Empty fields match
+// everything; a zero Since leaves the time window to NerdGraph.
+type IssueFilter struct {
+	States      []string
+	EntityGUIDs []string
+	Since       time.Time
+	Cursor      string
+}
+
+// listIssues reads a page of an account's issues from aiIssues.
+func (s *Server) listIssues(ctx context.Context, accountID int, filter IssueFilter) ([]Issue, string, error) {
+	req, err := newRequest("listIssues", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Var("accountId", accountID)
+	issueFilter := map[string]any{}
+	if len(filter.States) > 0 {
+		issueFilter["states"] = filter.States
+	}
+	if len(filter.EntityGUIDs) > 0 {
+		issueFilter["entityGuids"] = filter.EntityGUIDs
+	}
+	req.Var("filter", issueFilter)
+	if !filter.Since.IsZero() {
+		req.Var("timeWindow", map[string]any{"startTime": filter.Since.UnixMilli(), "endTime": time.Now().UnixMilli()})
+	}
+	if filter.Cursor != "" {
+		req.Var("cursor", filter.Cursor)
+	}
+
+	var responseData struct {
+		Actor struct {
+			Account struct {
+				AIIssues struct {
+					Issues struct {
+						NextCursor *string `json:"nextCursor"`
+						Issues     []Issue `json:"issues"`
+					} `json:"issues"`
+				} `json:"aiIssues"`
+			} `json:"account"`
+		} `json:"actor"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, "", err
+	}
+	page := responseData.Actor.Account.AIIssues.Issues
+	var nextCursor string
+	if page.NextCursor != nil {
+		nextCursor = *page.NextCursor
+	}
+	return page.Issues, nextCursor, nil
+}
+
+// parseIssueFilter reads the filter from the query string. Without a state,
+// only open issues are listed; state=all lists every issue.
+func parseIssueFilter(r *http.Request) (IssueFilter, error) {
+	query := r.URL.Query()
+	filter := IssueFilter{States: []string{"CREATED", "ACTIVATED"}, Cursor: query.Get("cursor")}
+	if v := query.Get("state"); strings.EqualFold(v, "all") {
+		filter.States = nil
+	} else if v != "" {
+		filter.States = nil
+		for _, state := range strings.Split(v, ",") {
+			state = strings.ToUpper(strings.TrimSpace(state))
+			if !issueStates[state] {
+				return filter, fmt.Errorf("invalid state %q, expected CREATED, ACTIVATED, DEACTIVATED, CLOSED or all", state)
+			}
+			filter.States = append(filter.States, state)
+		}
+	}
+	for _, guid := range strings.Split(query.Get("entityGuid"), ",") {
+		if guid = strings.TrimSpace(guid); guid != "" {
+			filter.EntityGUIDs = append(filter.EntityGUIDs, guid)
+		}
+	}
+	if v := query.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since %q, expected an RFC 3339 time", v)
+		}
+		filter.Since = since
+	}
+	return filter, nil
+}
+
+// List the alert issues of an account, open ones unless state says
+// otherwise. Pass nextCursor back as cursor for the next page.
+func (s *Server) getAccountIssues(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received request for the issues of account %s", mux.Vars(r)["account"])
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseIssueFilter(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	issues, nextCursor, err := s.listIssues(r.Context(), accountID, filter)
+	if err != nil {
+		writeError(w, "Failed to list issues", err)
+		return
+	}
+	if issues == nil {
+		issues = []Issue{}
+	}
+	response := map[string]any{"issues": issues}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+
+	defaultJobAttempts = 5
+	jobRetention       = 7 * 24 * time.Hour
+	maxJobBackoff      = 5 * time.Minute
+)
+
+// Job is a long-running operation executed in the background. Jobs are
+// persisted so they survive restarts and can be polled via GET /jobs/{id}.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Caller      string          `json:"caller,omitempty"`
+	Credential  string          `json:"credential,omitempty"`
+	RequestID   string          `json:"requestId,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// jobHandler executes one attempt of a job. The result is stored even when
+// an error is returned, so handlers can record progress between attempts.
+// Returning a retryable error schedules another attempt with backoff.
+type jobHandler func(ctx context.Context, job *Job) (result any, err error)
+
+type jobQueue struct {
+	path     string
+	auth     *authenticator
+	handlers map[string]jobHandler
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	paused  bool
+	running sync.WaitGroup
+}
+
+func newJobQueue(path string, auth *authenticator) (*jobQueue, error) {
+	q := &jobQueue{
+		path:     path,
+		auth:     auth,
+		handlers: map[string]jobHandler{},
+		jobs:     map[string]*Job{},
+	}
+	if err := loadSealed(path, &q.jobs); err != nil {
+		return nil, err
+	}
+	// Anything still marked running was interrupted by a restart.
+	for _, job := range q.jobs {
+		if job.Status == jobRunning {
+			job.Status = jobQueued
+		}
+	}
+	return q, nil
+}
+
+// Register adds the handler for a kind of job.
+func (q *jobQueue) Register(kind string, handler jobHandler) {
+	q.handlers[kind] = handler
+}
+
+// Enqueue persists a new job for the caller in ctx and returns it.
+func (q *jobQueue) Enqueue(ctx context.Context, kind string, payload any) (*Job, error) {
+	return q.EnqueueAt(ctx, kind, payload, time.Now())
+}
+
+// EnqueueAt is Enqueue for a job that must not start before runAt.
+func (q *jobQueue) EnqueueAt(ctx context.Context, kind string, payload any, runAt time.Time) (*Job, error) {
+	return q.enqueue(ctx, kind, payload, runAt, defaultJobAttempts)
+}
+
+// EnqueueAttempts is Enqueue for a job that may be attempted up to
+// maxAttempts times rather than the default.
+func (q *jobQueue) EnqueueAttempts(ctx context.Context, kind string, payload any, maxAttempts int) (*Job, error) {
+	return q.enqueue(ctx, kind, payload, time.Now(), maxAttempts)
+}
+
+func (q *jobQueue) enqueue(ctx context.Context, kind string, payload any, runAt time.Time, maxAttempts int) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	job := &Job{
+		ID:          newID(),
+		Kind:        kind,
+		Status:      jobQueued,
+		Payload:     data,
+		MaxAttempts: maxAttempts,
+		RunAt:       runAt.UTC(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if caller := callerFromContext(ctx); caller != nil {
+		job.Caller = caller.Name
+	}
+	if credential := credentialFromContext(ctx); credential != nil {
+		job.Credential = credential.Name
+	}
+	job.RequestID = requestIDFromContext(ctx)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[job.ID] = job
+	if err := q.save(); err != nil {
+		delete(q.jobs, job.ID)
+		return nil, err
+	}
+	log.Printf("Queued %s job %s", kind, job.ID)
+	c := *job
+	return &c, nil
+}
+
+// Get returns a copy of the job, or nil when it doesn't exist.
+func (q *jobQueue) Get(id string) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil
+	}
+	c := *job
+	return &c
+}
+
+// Find returns a copy of the first job matching, or nil.
+func (q *jobQueue) Find(match func(job *Job) bool) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if match(job) {
+			c := *job
+			return &c
+		}
+	}
+	return nil
+}
+
+// Count returns how many jobs match.
+func (q *jobQueue) Count(match func(job *Job) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, job := range q.jobs {
+		if match(job) {
+			n++
+		}
+	}
+	return n
+}
+
+// Cancel stops a job that hasn't started yet. It reports false when the job
+// is missing or already running or finished.
+func (q *jobQueue) Cancel(id string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok || job.Status != jobQueued {
+		return false, nil
+	}
+	job.Status = jobCancelled
+	job.UpdatedAt = time.Now().UTC()
+	log.Printf("Cancelled job %s", id)
+	return true, q.save()
+}
+
+// RunDue executes every queued job whose time has come, oldest first. Jobs
+// it claimed but didn't get to start are put back when it is stopped early.
+func (q *jobQueue) RunDue(ctx context.Context) {
+	q.mu.Lock()
+	if q.paused {
+		q.mu.Unlock()
+		return
+	}
+	q.running.Add(1)
+	q.mu.Unlock()
+	defer q.running.Done()
+
+	due := q.claimDue()
+	for i, job := range due {
+		if ctx.Err() != nil || q.isPaused() {
+			q.requeue(due[i:])
+			return
+		}
+		q.run(ctx, job)
+	}
+}
+
+// backgroundJob runs the queue every second. Jobs are kept on the replica
+// that queued them, so every replica runs its own rather than only the
+// leader.
+func (q *jobQueue) backgroundJob() backgroundJob {
+	return backgroundJob{name: "job-queue", interval: time.Second, run: q.RunDue, everyReplica: true}
+}
+
+func (q *jobQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// requeue returns claimed jobs that never started to the queue.
+func (q *jobQueue) requeue(jobs []*Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range jobs {
+		job.Status = jobQueued
+		job.Attempts--
+	}
+	if err := q.save(); err != nil {
+		log.Printf("Failed to save jobs: %v", err)
+	}
+}
+
+// Pause stops jobs from starting and waits for the running ones to finish.
+// Queued jobs, including pending webhook deliveries, stay on disk and run
+// after the next start.
+func (q *jobQueue) Pause(ctx context.Context) error {
+	q.mu.Lock()
+	q.paused = true
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+	return waitDone(ctx, done, "running jobs")
+}
+
+func (q *jobQueue) claimDue() []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var due []*Job
+	for _, job := range q.jobs {
+		if job.Status == jobQueued && !job.RunAt.After(now) {
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	for _, job := range due {
+		job.Status = jobRunning
+		job.Attempts++
+		job.UpdatedAt = now.UTC()
+	}
+	if len(due) > 0 {
+		if err := q.save(); err != nil {
+			log.Printf("Failed to save jobs: %v", err)
+		}
+	}
+	return due
+}
+
+func (q *jobQueue) run(ctx context.Context, job *Job) {
+	handler, ok := q.handlers[job.Kind]
+	var result any
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler for job kind %s", job.Kind)
+	} else {
+		// Jobs run with the identity, and so the credentials and account
+		// restrictions, of the caller that queued them.
+		jobCtx := ctx
+		if job.RequestID != "" {
+			jobCtx = withRequestID(jobCtx, job.RequestID)
+		}
+		caller := q.auth.callerByName(job.Caller)
+		if caller != nil {
+			jobCtx = context.WithValue(jobCtx, callerKey{}, caller)
+		}
+		if job.Caller != "" && caller == nil && q.auth.enabled() {
+			err = fmt.Errorf("caller %s is no longer allowed to make changes: %w", job.Caller, errForbidden)
+		} else if job.Credential == "" {
+			result, err = handler(jobCtx, job)
+		} else if credential := q.auth.credentialByName(job.Credential); credential != nil {
+			result, err = handler(withCredential(jobCtx, credential), job)
+		} else {
+			err = fmt.Errorf("credential %s is no longer configured", job.Credential)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if result != nil {
+		if data, marshalErr := json.Marshal(result); marshalErr == nil {
+			job.Result = data
+		}
+	}
+	job.UpdatedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		job.Status = jobSucceeded
+		job.Error = ""
+		log.Printf("Job %s succeeded", job.ID)
+	case ok && retryable(err) && job.Attempts < job.MaxAttempts:
+		job.Status = jobQueued
+		job.Error = secrets.Redact(err.Error())
+		job.RunAt = time.Now().Add(jobBackoff(job.Attempts, err)).UTC()
+		log.Printf("Job %s attempt %d failed, retrying at %s: %v", job.ID, job.Attempts, job.RunAt.Format(time.RFC3339), err)
+	default:
+		job.Status = jobFailed
+		job.Error = secrets.Redact(err.Error())
+		log.Printf("Job %s failed: %v", job.ID, err)
+	}
+	if err := q.save(); err != nil {
+		log.Printf("Failed to save jobs: %v", err)
+	}
+}
+
+// jobBackoff doubles the wait after each failed attempt, or waits for the
+// upstream rate limit to reset when that is what we hit. Jobs waiting for
+// NerdGraph to come back check again on a short interval.
+func jobBackoff(attempts int, err error) time.Duration {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return time.Until(rateLimitErr.ResetAt)
+	}
+	if errors.Is(err, errUpstreamUnavailable) {
+		return offlineRetryInterval
+	}
+	backoff := 5 * time.Second << (attempts - 1)
+	if backoff > maxJobBackoff || backoff <= 0 {
+		backoff = maxJobBackoff
+	}
+	return backoff
+}
+
+// save writes the jobs to disk, dropping finished jobs past retention. The
+// caller must hold q.mu.
+func (q *jobQueue) save() error {
+	cutoff := time.Now().Add(-jobRetention)
+	for id, job := range q.jobs {
+		finished := job.Status == jobSucceeded || job.Status == jobFailed || job.Status == jobCancelled
+		if finished && job.UpdatedAt.Before(cutoff) {
+			delete(q.jobs, id)
+		}
+	}
+	return saveSealed(q.path, q.jobs)
+}
+
+// writeJobAccepted answers a request whose work was queued as a job.
+func writeJobAccepted(w http.ResponseWriter, job *Job) {
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, map[string]any{"job": job})
+}
+
+// Get the status of a job
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job := s.jobs.Get(mux.Vars(r)["id"])
+	// Callers only get to see their own jobs.
+	if caller := callerFromContext(r.Context()); job != nil && caller != nil && job.Caller != caller.Name {
+		job = nil
+	}
+	if job == nil {
+		http.Error(w, `{"error": "Job not found"}`, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"job": job})
+}
+
+// newID returns a random identifier for jobs and other local records.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
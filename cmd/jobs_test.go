@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobBackoff(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		attempts int
+		err      error
+		want     time.Duration
+	}{
+		{"first retry", 1, errLocked, 5 * time.Second},
+		{"doubles", 3, errLocked, 20 * time.Second},
+		{"capped", 10, errLocked, maxJobBackoff},
+		{"overflow", 80, errLocked, maxJobBackoff},
+		{"upstream down", 7, errUpstreamUnavailable, offlineRetryInterval},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := jobBackoff(test.attempts, test.err); got != test.want {
+				t.Errorf("jobBackoff(%d, %v) = %s, want %s", test.attempts, test.err, got, test.want)
+			}
+		})
+	}
+	resetAt := time.Now().Add(time.Minute)
+	if got := jobBackoff(1, &RateLimitError{ResetAt: resetAt}); got < 59*time.Second || got > time.Minute {
+		t.Errorf("jobBackoff for a rate limit = %s, want until the reset", got)
+	}
+}
+
+// runJob queues one job whose handler fails with errs in turn, runs it
+// attempts times regardless of its backoff, and returns it.
+func runJob(t *testing.T, q *jobQueue, maxAttempts, attempts int, errs ...error) *Job {
+	t.Helper()
+	calls := 0
+	q.Register("test", func(ctx context.Context, job *Job) (any, error) {
+		err := errs[min(calls, len(errs)-1)]
+		calls++
+		return map[string]int{"calls": calls}, err
+	})
+	job, err := q.EnqueueAttempts(context.Background(), "test", nil, maxAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range attempts {
+		q.mu.Lock()
+		q.jobs[job.ID].RunAt = time.Now()
+		q.mu.Unlock()
+		q.RunDue(context.Background())
+	}
+	return q.Get(job.ID)
+}
+
+func newTestJobQueue(t *testing.T) *jobQueue {
+	t.Helper()
+	q, err := newJobQueue(t.TempDir()+"/jobs.json", &authenticator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestJobRetries(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		maxAttempts  int
+		errs         []error
+		wantStatus   string
+		wantAttempts int
+	}{
+		{"succeeds", 5, []error{nil}, jobSucceeded, 1},
+		{"retried until it succeeds", 5, []error{errLocked, errLocked, nil}, jobSucceeded, 3},
+		{"out of attempts", 2, []error{errLocked}, jobFailed, 2},
+		{"not retryable", 5, []error{errForbidden}, jobFailed, 1},
+		{"maybe applied", 5, []error{errMaybeApplied}, jobFailed, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			job := runJob(t, newTestJobQueue(t), test.maxAttempts, 5, test.errs...)
+			if job.Status != test.wantStatus || job.Attempts != test.wantAttempts {
+				t.Errorf("job is %s after %d attempts, want %s after %d", job.Status, job.Attempts, test.wantStatus, test.wantAttempts)
+			}
+			if test.wantStatus == jobFailed && job.Error == "" {
+				t.Error("failed job has no error")
+			}
+			if len(job.Result) == 0 {
+				t.Error("result of the last attempt was not kept")
+			}
+		})
+	}
+}
+
+func TestJobRetryWaitsForBackoff(t *testing.T) {
+	q := newTestJobQueue(t)
+	q.Register("test", func(ctx context.Context, job *Job) (any, error) { return nil, errLocked })
+	job, _ := q.Enqueue(context.Background(), "test", nil)
+	q.RunDue(context.Background())
+	q.RunDue(context.Background())
+
+	job = q.Get(job.ID)
+	if job.Status != jobQueued || job.Attempts != 1 {
+		t.Fatalf("job is %s after %d attempts, want queued after 1", job.Status, job.Attempts)
+	}
+	if wait := time.Until(job.RunAt); wait < 4*time.Second || wait > 5*time.Second {
+		t.Errorf("next attempt in %s, want 5s", wait)
+	}
+}
+
+func TestJobRunsAsItsCaller(t *testing.T) {
+	alice := &Caller{Name: "alice"}
+	q, err := newJobQueue(t.TempDir()+"/jobs.json", &authenticator{byName: map[string]*Caller{"alice": alice}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranAs *Caller
+	q.Register("test", func(ctx context.Context, job *Job) (any, error) {
+		ranAs = callerFromContext(ctx)
+		return nil, nil
+	})
+	for _, test := range []struct {
+		caller     *Caller
+		wantStatus string
+	}{
+		{alice, jobSucceeded},
+		{&Caller{Name: "removed"}, jobFailed},
+	} {
+		ranAs = nil
+		job, _ := q.Enqueue(context.WithValue(context.Background(), callerKey{}, test.caller), "test", nil)
+		q.RunDue(context.Background())
+		if job = q.Get(job.ID); job.Status != test.wantStatus {
+			t.Errorf("job of %s is %s, want %s", test.caller.Name, job.Status, test.wantStatus)
+		}
+		if test.wantStatus == jobSucceeded && ranAs != alice {
+			t.Errorf("job ran as %v, want alice", ranAs)
+		}
+		if test.wantStatus == jobFailed && ranAs != nil {
+			t.Error("job of a removed caller ran")
+		}
+	}
+}
+
+func TestJobsSurviveRestart(t *testing.T) {
+	path := t.TempDir() + "/jobs.json"
+	q, _ := newJobQueue(path, &authenticator{})
+	queued, _ := q.Enqueue(context.Background(), "test", nil)
+	cancelled, _ := q.Enqueue(context.Background(), "test", nil)
+	if ok, err := q.Cancel(cancelled.ID); !ok || err != nil {
+		t.Fatalf("Cancel = %v, %v", ok, err)
+	}
+	running, _ := q.Enqueue(context.Background(), "test", nil)
+	q.mu.Lock()
+	q.jobs[running.ID].Status = jobRunning
+	q.save()
+	q.mu.Unlock()
+	if ok, _ := q.Cancel(running.ID); ok {
+		t.Error("cancelled a running job")
+	}
+
+	// A job that was running when the replica stopped is run again.
+	restarted, err := newJobQueue(path, &authenticator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{queued.ID: jobQueued, running.ID: jobQueued, cancelled.ID: jobCancelled} {
+		if got := restarted.Get(id); got == nil || got.Status != want {
+			t.Errorf("job %s after restart = %+v, want %s", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultKeyMetricsInterval = 5 * time.Minute
+
+var activeKeys = newGaugeVec("ingest_keys_active",
+	"Ingest keys per account and type, as of the last count.", "account", "ingest_type")
+
+// Metric API endpoints by New Relic region, for pushing the key counts.
+var newRelicMetricEndpoints = map[string]string{
+	"US": "https://metric-api.newrelic.com/metric/v1",
+	"EU": "https://metric-api.eu.newrelic.com/metric/v1",
+}
+
+// keyCounter counts the ingest keys of KEY_METRICS_ACCOUNTS on an interval
+// and exports the counts as gauges, and to New Relic as metrics when a
+// license key is given.
+type keyCounter struct {
+	accounts   []int
+	interval   time.Duration
+	licenseKey string
+	endpoint   string
+	client     *http.Client
+}
+
+// newKeyCounterFromEnv returns nil when KEY_METRICS_ACCOUNTS is unset.
+func newKeyCounterFromEnv() (*keyCounter, error) {
+	accounts, err := parseAccountIDs(os.Getenv("KEY_METRICS_ACCOUNTS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KEY_METRICS_ACCOUNTS: %w", err)
+	}
+	if len(accounts) == 0 {
+		return nil, nil
+	}
+	c := &keyCounter{accounts: accounts, interval: defaultKeyMetricsInterval, client: &http.Client{Timeout: 30 * time.Second}}
+	if v := os.Getenv("KEY_METRICS_INTERVAL_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid KEY_METRICS_INTERVAL_SECONDS %q", v)
+		}
+		c.interval = time.Duration(seconds) * time.Second
+	}
+	if c.licenseKey = os.Getenv("KEY_METRICS_LICENSE_KEY"); c.licenseKey != "" {
+		secrets.Add(c.licenseKey)
+		region := os.Getenv("NEW_RELIC_REGION")
+		if region == "" {
+			region = "EU"
+		}
+		var ok bool
+		if c.endpoint, ok = newRelicMetricEndpoints[strings.ToUpper(region)]; !ok {
+			return nil, fmt.Errorf("unknown New Relic region %q", region)
+		}
+	}
+	return c, nil
+}
+
+// countKeys counts the keys and updates the gauges, then pushes the counts
+// when configured. It runs as a background job on the leader.
+func (s *Server) countKeys(ctx context.Context) {
+	c := s.keyCounter
+	keys, err := s.searchIngestKeys(ctx, c.accounts)
+	if err != nil {
+		log.Printf("Failed to count keys: %v", err)
+		return
+	}
+	counts := map[int]map[string]int{}
+	for _, accountID := range c.accounts {
+		// Types with no keys are reported as 0 rather than left at their
+		// last value.
+		counts[accountID] = map[string]int{}
+		for ingestType := range validIngestTypes {
+			counts[accountID][ingestType] = 0
+		}
+	}
+	for _, key := range keys {
+		if counts[key.AccountID] != nil {
+			counts[key.AccountID][key.IngestType]++
+		}
+	}
+	for accountID, byType := range counts {
+		for ingestType, n := range byType {
+			activeKeys.Set(float64(n), strconv.Itoa(accountID), ingestType)
+		}
+	}
+
+	if c.licenseKey != "" {
+		if err := c.push(ctx, counts); err != nil {
+			log.Printf("Failed to push key counts to New Relic: %v", err)
+		}
+	}
+}
+
+// push sends the counts to the Metric API as gauges named
+// ingestKeys.active.
+func (c *keyCounter) push(ctx context.Context, counts map[int]map[string]int) error {
+	now := time.Now().UnixMilli()
+	var gauges []map[string]any
+	for accountID, byType := range counts {
+		for ingestType, n := range byType {
+			gauges = append(gauges, map[string]any{
+				"name":      "ingestKeys.active",
+				"type":      "gauge",
+				"value":     n,
+				"timestamp": now,
+				"attributes": map[string]any{
+					"accountId":  accountID,
+					"ingestType": ingestType,
+				},
+			})
+		}
+	}
+	body, err := json.Marshal([]map[string]any{{"metrics": gauges}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", c.licenseKey)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Metric API answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ListedKey is an ingest key as returned by GET /keys, with its local labels.
+type ListedKey struct {
+	IngestKey
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BulkDeleteRequest deletes several ingest keys, named by ID or by selector.
+type BulkDeleteRequest struct {
+	IDs      []string `json:"ids,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+}
+
+// parseAccountIDs reads a comma separated list of account IDs.
+func parseAccountIDs(value string) ([]int, error) {
+	var accountIDs []int
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		accountID, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account ID %q", part)
+		}
+		accountIDs = append(accountIDs, accountID)
+	}
+	return accountIDs, nil
+}
+
+// resolveKeyIDs returns the keys a bulk request applies to: the IDs given,
+// or every registered key matching the selector that the caller may access.
+func (s *Server) resolveKeyIDs(ctx context.Context, ids []string, selector string) ([]string, error) {
+	if selector == "" {
+		return ids, nil
+	}
+	if len(ids) > 0 {
+		return nil, fmt.Errorf("give either ids or a selector, not both")
+	}
+	parsed, err := parseLabelSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("selector is empty")
+	}
+	for _, record := range s.keys.Select(parsed) {
+		if checkAccount(ctx, record.AccountID) == nil {
+			ids = append(ids, record.ID)
+		}
+	}
+	return ids, nil
+}
+
+// List keys in accounts, optionally filtered by label selector. Keys are
+// sorted by ID and paged; pass nextCursor back as cursor for the next page.
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to list keys")
+
+	accountIDs, err := parseAccountIDs(r.URL.Query().Get("account"))
+	if err != nil || len(accountIDs) == 0 {
+		http.Error(w, `{"error": "Invalid request: account is required"}`, http.StatusBadRequest)
+		return
+	}
+	selector, err := parseLabelSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	for _, accountID := range accountIDs {
+		if !authorizeAccount(w, r, accountID) {
+			return
+		}
+	}
+
+	found, err := s.searchIngestKeys(r.Context(), accountIDs)
+	if err != nil {
+		writeError(w, "Failed to list keys", err)
+		return
+	}
+	keys := []ListedKey{}
+	for _, key := range found {
+		listed := ListedKey{IngestKey: key}
+		if record := s.keys.Get(key.ID); record != nil {
+			listed.Labels = record.Labels
+		}
+		if selector.Matches(listed.Labels) {
+			keys = append(keys, listed)
+		}
+	}
+	slices.SortFunc(keys, func(a, b ListedKey) int { return strings.Compare(a.ID, b.ID) })
+	keys, nextCursor := paginate(keys, page, func(key ListedKey) []string { return []string{key.ID} }, slices.Compare[[]string])
+	response := map[string]any{"keys": keys}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Set the labels on a key. PUT replaces them; PATCH merges, with a null
+// value removing a label.
+func (s *Server) setKeyLabels(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to label key %s", id)
+
+	var request struct {
+		Labels map[string]*string `json:"labels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	labels := map[string]string{}
+	for name, value := range request.Labels {
+		if value != nil {
+			labels[name] = *value
+		}
+	}
+	if err := validateLabels(labels); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	// The key has to exist upstream, and we need its account to authorize
+	// the caller and to register it if this is the first label.
+	key, err := s.getIngestKey(r.Context(), id)
+	if err != nil {
+		writeError(w, "Failed to look up key", err)
+		return
+	}
+	if !authorizeAccount(w, r, key.AccountID) {
+		return
+	}
+
+	record := s.keys.Update(KeyRecord{ID: key.ID, AccountID: key.AccountID, Name: key.Name}, func(record *KeyRecord) {
+		if r.Method == http.MethodPut {
+			record.Labels = map[string]string{}
+		}
+		for name, value := range request.Labels {
+			if value == nil {
+				delete(record.Labels, name)
+			} else {
+				record.Labels[name] = *value
+			}
+		}
+	})
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "key.label",
+		AccountID: key.AccountID,
+		KeyID:     key.ID,
+		Details:   map[string]any{"labels": record.Labels},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"key": record})
+}
+
+func (s *Server) registerBulkDeleteJobs() {
+	s.jobs.Register("delete-bulk", func(ctx context.Context, job *Job) (any, error) {
+		var request BulkDeleteRequest
+		if err := json.Unmarshal(job.Payload, &request); err != nil {
+			return nil, err
+		}
+		return runItems(ctx, job, request.IDs, func(ctx context.Context, id string) (any, error) {
+			if err := s.checkKeyAccess(ctx, id); err != nil {
+				return nil, err
+			}
+			return nil, s.deleteKey(ctx, id)
+		})
+	})
+}
+
+// Delete several keys
+func (s *Server) bulkDeleteApiKeys(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to delete several keys")
+
+	var request BulkDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	ids, err := s.resolveKeyIDs(r.Context(), request.IDs, request.Selector)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, `{"error": "No keys matched"}`, http.StatusNotFound)
+		return
+	}
+
+	if s.approvals != nil {
+		s.requireApprovals(w, r, "delete", ids)
+		return
+	}
+
+	job, err := s.jobs.Enqueue(r.Context(), "delete-bulk", BulkDeleteRequest{IDs: ids})
+	if err != nil {
+		log.Printf("Failed to queue job: %v, Status Code: %d", err, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to queue job"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJobAccepted(w, job)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+	"github.com/gorilla/mux"
+)
+
+func TestPatchLabelsAfterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	registry, err := newKeyRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Put(KeyRecord{ID: "key-1", AccountID: 1, Name: "payments"})
+
+	// A record saved without labels comes back with a nil map.
+	registry, err = newKeyRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nerdGraph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"actor": {"apiAccess": {"key": {"id": "key-1", "name": "payments", "accountId": 1, "ingestType": "LICENSE"}}}}}`))
+	}))
+	defer nerdGraph.Close()
+	apiKey, _ := newSecretValue("NEW_RELIC_API_KEY", "NRAK-TESTTESTTEST", "")
+	audit, _ := newAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	s := &Server{client: graphql.NewClient(nerdGraph.URL), apiKey: apiKey, keys: registry, audit: audit}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/keys/{id}/labels", s.setKeyLabels).Methods("PUT", "PATCH")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/keys/key-1/labels", strings.NewReader(`{"labels": {"team": "payments"}}`)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH answered %d: %s", w.Code, w.Body)
+	}
+	if got := registry.Get("key-1").Labels["team"]; got != "payments" {
+		t.Errorf("team label = %q, want payments", got)
+	}
+}
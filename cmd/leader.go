@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	serviceAccountDir    = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultLeaseDuration = 15 * time.Second
+	leaseTimeFormat      = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// elector decides whether this replica should run the background jobs.
+// Every replica serves HTTP traffic regardless of the outcome.
+type elector interface {
+	IsLeader() bool
+	Run(ctx context.Context)
+}
+
+// soloElector is used when leader election is off: a single replica is
+// always the leader.
+type soloElector struct{}
+
+func (soloElector) IsLeader() bool          { return true }
+func (soloElector) Run(ctx context.Context) {}
+
+// newElectorFromEnv picks the leader election backend from LEADER_ELECTION.
+func newElectorFromEnv() (elector, error) {
+	switch mode := os.Getenv("LEADER_ELECTION"); mode {
+	case "", "none":
+		return soloElector{}, nil
+	case "kubernetes":
+		kube, err := newKubeClient()
+		if err != nil {
+			return nil, err
+		}
+		name := os.Getenv("LEADER_ELECTION_LEASE_NAME")
+		if name == "" {
+			name = "api-go-leader"
+		}
+		return &kubeLeaseElector{lease: kube.lease(name, replicaIdentity(), defaultLeaseDuration)}, nil
+	default:
+		return nil, fmt.Errorf("unknown LEADER_ELECTION mode %q", mode)
+	}
+}
+
+// replicaIdentity names this replica in leases, using the pod name when we
+// run in Kubernetes.
+func replicaIdentity() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", name, os.Getpid())
+}
+
+// kubeClient is the small part of the Kubernetes API we need, talking to
+// the API server with the pod's service account.
+type kubeClient struct {
+	host      string
+	namespace string
+	token     string
+	http      *http.Client
+}
+
+func newKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside Kubernetes")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	return &kubeClient{
+		host:      "https://" + host + ":" + port,
+		namespace: strings.TrimSpace(string(namespace)),
+		token:     strings.TrimSpace(string(token)),
+		http: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+func (k *kubeClient) do(ctx context.Context, method, path string, body, out any) (int, error) {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.host+path, &payload)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := k.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 || out == nil {
+		return res.StatusCode, nil
+	}
+	return res.StatusCode, json.NewDecoder(res.Body).Decode(out)
+}
+
+type kubeLease struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+	} `json:"spec"`
+}
+
+// leaseHandle acquires, renews and releases one coordination.k8s.io Lease.
+// Updates carry the resourceVersion we read, so two replicas racing for an
+// expired lease can't both win.
+type leaseHandle struct {
+	kube     *kubeClient
+	name     string
+	identity string
+	duration time.Duration
+}
+
+func (k *kubeClient) lease(name, identity string, duration time.Duration) *leaseHandle {
+	return &leaseHandle{kube: k, name: name, identity: identity, duration: duration}
+}
+
+func (l *leaseHandle) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.kube.namespace)
+}
+
+// TryAcquire takes or renews the lease, reporting whether we hold it.
+func (l *leaseHandle) TryAcquire(ctx context.Context) (bool, error) {
+	now := time.Now().UTC()
+	var lease kubeLease
+	status, err := l.kube.do(ctx, http.MethodGet, l.path()+"/"+l.name, nil, &lease)
+	if err != nil {
+		return false, err
+	}
+
+	if status == http.StatusNotFound {
+		lease.Metadata.Name = l.name
+		lease.Metadata.Namespace = l.kube.namespace
+		lease.Spec.HolderIdentity = l.identity
+		lease.Spec.LeaseDurationSeconds = int(l.duration.Seconds())
+		lease.Spec.AcquireTime = now.Format(leaseTimeFormat)
+		lease.Spec.RenewTime = now.Format(leaseTimeFormat)
+		status, err = l.kube.do(ctx, http.MethodPost, l.path(), lease, nil)
+		if err != nil {
+			return false, err
+		}
+		if status == http.StatusConflict {
+			return false, nil
+		}
+		if status >= 300 {
+			return false, fmt.Errorf("creating lease %s: status %d", l.name, status)
+		}
+		return true, nil
+	}
+	if status >= 300 {
+		return false, fmt.Errorf("reading lease %s: status %d", l.name, status)
+	}
+
+	if lease.Spec.HolderIdentity != l.identity && lease.Spec.HolderIdentity != "" {
+		renewed, _ := time.Parse(leaseTimeFormat, lease.Spec.RenewTime)
+		expiry := renewed.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if now.Before(expiry) {
+			return false, nil
+		}
+	}
+	if lease.Spec.HolderIdentity != l.identity {
+		lease.Spec.AcquireTime = now.Format(leaseTimeFormat)
+	}
+	lease.Spec.HolderIdentity = l.identity
+	lease.Spec.LeaseDurationSeconds = int(l.duration.Seconds())
+	lease.Spec.RenewTime = now.Format(leaseTimeFormat)
+	status, err = l.kube.do(ctx, http.MethodPut, l.path()+"/"+l.name, lease, nil)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusConflict {
+		return false, nil
+	}
+	if status >= 300 {
+		return false, fmt.Errorf("updating lease %s: status %d", l.name, status)
+	}
+	return true, nil
+}
+
+// Release gives the lease up early if we still hold it.
+func (l *leaseHandle) Release(ctx context.Context) error {
+	var lease kubeLease
+	status, err := l.kube.do(ctx, http.MethodGet, l.path()+"/"+l.name, nil, &lease)
+	if err != nil || status >= 300 || lease.Spec.HolderIdentity != l.identity {
+		return err
+	}
+	lease.Spec.HolderIdentity = ""
+	_, err = l.kube.do(ctx, http.MethodPut, l.path()+"/"+l.name, lease, nil)
+	return err
+}
+
+// kubeLeaseElector keeps trying to hold a Lease and is leader while it does.
+type kubeLeaseElector struct {
+	lease  *leaseHandle
+	leader atomic.Bool
+}
+
+func (e *kubeLeaseElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *kubeLeaseElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.lease.duration / 3)
+	defer ticker.Stop()
+	for {
+		held, err := e.lease.TryAcquire(ctx)
+		if err != nil {
+			log.Printf("Leader election: %v", err)
+			held = false
+		}
+		if held != e.leader.Swap(held) {
+			if held {
+				log.Printf("Leader election: %s is now the leader", e.lease.identity)
+			} else {
+				log.Printf("Leader election: %s lost leadership", e.lease.identity)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if e.leader.Load() {
+				release, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				e.lease.Release(release)
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKube is an API server holding Leases in memory. Updates must carry
+// the current resourceVersion, as with the real one.
+type fakeKube struct {
+	mu      sync.Mutex
+	leases  map[string]kubeLease
+	version int
+}
+
+func newFakeKube(t *testing.T) (*kubeClient, *fakeKube) {
+	t.Helper()
+	fake := &fakeKube{leases: map[string]kubeLease{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return &kubeClient{host: srv.URL, namespace: "default", token: "token", http: srv.Client()}, fake
+}
+
+func (f *fakeKube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/apis/coordination.k8s.io/v1/namespaces/default/leases")
+	name = strings.TrimPrefix(name, "/")
+	var lease kubeLease
+	if r.Method != http.MethodGet {
+		json.NewDecoder(r.Body).Decode(&lease)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		existing, ok := f.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(existing)
+	case http.MethodPost:
+		if _, ok := f.leases[lease.Metadata.Name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.store(lease)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if f.leases[name].Metadata.ResourceVersion != lease.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.store(lease)
+	}
+}
+
+func (f *fakeKube) store(lease kubeLease) {
+	f.version++
+	lease.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.leases[lease.Metadata.Name] = lease
+}
+
+func (f *fakeKube) holder(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.leases[name].Spec.HolderIdentity
+}
+
+// expire backdates the lease's renewal so that it has run out.
+func (f *fakeKube) expire(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lease := f.leases[name]
+	lease.Spec.RenewTime = time.Now().Add(-time.Hour).UTC().Format(leaseTimeFormat)
+	f.leases[name] = lease
+}
+
+func TestLeaseHandle(t *testing.T) {
+	kube, fake := newFakeKube(t)
+	ctx := context.Background()
+	a := kube.lease("leader", "replica-a", time.Minute)
+	b := kube.lease("leader", "replica-b", time.Minute)
+
+	for _, step := range []struct {
+		name   string
+		do     func(context.Context) (bool, error)
+		want   bool
+		holder string
+	}{
+		{"a creates the lease", a.TryAcquire, true, "replica-a"},
+		{"b can't take a live lease", b.TryAcquire, false, "replica-a"},
+		{"a renews", a.TryAcquire, true, "replica-a"},
+		{"b takes an expired lease", func(ctx context.Context) (bool, error) { fake.expire("leader"); return b.TryAcquire(ctx) }, true, "replica-b"},
+		{"a no longer holds it", a.TryAcquire, false, "replica-b"},
+		{"a can't release b's lease", func(ctx context.Context) (bool, error) { return false, a.Release(ctx) }, false, "replica-b"},
+		{"b releases", func(ctx context.Context) (bool, error) { return false, b.Release(ctx) }, false, ""},
+		{"a takes a released lease", a.TryAcquire, true, "replica-a"},
+	} {
+		held, err := step.do(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if held != step.want || fake.holder("leader") != step.holder {
+			t.Fatalf("%s: held = %v, holder = %q; want %v, %q", step.name, held, fake.holder("leader"), step.want, step.holder)
+		}
+	}
+}
+
+func TestKubeLeaseElector(t *testing.T) {
+	kube, fake := newFakeKube(t)
+	// Leases are kept in whole seconds: this one lasts a second and is
+	// renewed every half second.
+	const duration = 1500 * time.Millisecond
+	leader := &kubeLeaseElector{lease: kube.lease("leader", "replica-a", duration)}
+	follower := &kubeLeaseElector{lease: kube.lease("leader", "replica-b", duration)}
+	stopLeader := run(t, leader)
+	waitFor(t, leader.IsLeader)
+	run(t, follower)
+
+	time.Sleep(duration)
+	if follower.IsLeader() {
+		t.Fatal("two replicas are leader at once")
+	}
+
+	// Stopping the leader releases the lease for the follower.
+	stopLeader()
+	waitFor(t, follower.IsLeader)
+	if fake.holder("leader") != "replica-b" {
+		t.Errorf("holder = %q, want replica-b", fake.holder("leader"))
+	}
+}
+
+// run runs the elector until the returned function is called or the test
+// ends.
+func run(t *testing.T, e *kubeLeaseElector) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { e.Run(ctx); close(done) }()
+	stop = func() { cancel(); <-done }
+	t.Cleanup(stop)
+	return stop
+}
+
+// waitFor polls cond for up to two seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+	}
+}
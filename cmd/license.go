@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// licenseKeysRoute reveals key values on a GET, so the "read" action of an
+// access policy doesn't cover it.
+const licenseKeysRoute = "/accounts/{account}/licenseKeys"
+
+// LicenseKey is a LICENSE ingest key with its value.
+type LicenseKey struct {
+	IngestKey
+	Key string `json:"key"`
+}
+
+// AccountLicenseKeys is what agents of an account can report with: the
+// account's original license key and its LICENSE ingest keys.
+type AccountLicenseKeys struct {
+	AccountID  int          `json:"accountId"`
+	LicenseKey string       `json:"licenseKey"`
+	IngestKeys []LicenseKey `json:"ingestKeys"`
+}
+
+// getLicenseKeys reads the license keys of an account. Keys whose deletion
+// is scheduled are left out.
+func (s *Server) getLicenseKeys(ctx context.Context, accountID int) (*AccountLicenseKeys, error) {
+	keys := &AccountLicenseKeys{AccountID: accountID, IngestKeys: []LicenseKey{}}
+	var cursor *string
+	for {
+		req, err := newRequest("getLicenseKeys", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Var("accountId", accountID)
+		req.Var("cursor", cursor)
+
+		var responseData struct {
+			Actor struct {
+				Account struct {
+					LicenseKey string `json:"licenseKey"`
+				} `json:"account"`
+				APIAccess struct {
+					KeySearch struct {
+						NextCursor *string      `json:"nextCursor"`
+						Keys       []LicenseKey `json:"keys"`
+					} `json:"keySearch"`
+				} `json:"apiAccess"`
+			} `json:"actor"`
+		}
+		if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+			return nil, err
+		}
+		keys.LicenseKey = responseData.Actor.Account.LicenseKey
+		search := responseData.Actor.APIAccess.KeySearch
+		for _, key := range search.Keys {
+			if key.IngestType != "LICENSE" {
+				continue
+			}
+			if job, _ := s.pendingDelete(key.ID); job != nil {
+				continue
+			}
+			keys.IngestKeys = append(keys.IngestKeys, key)
+		}
+		if search.NextCursor == nil || *search.NextCursor == "" {
+			return keys, nil
+		}
+		cursor = search.NextCursor
+	}
+}
+
+// Get the license keys of an account, for agents that can't be given an
+// ingest key of their own
+func (s *Server) getAccountLicenseKeys(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received request for the license keys of account %s", mux.Vars(r)["account"])
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := s.getLicenseKeys(r.Context(), accountID)
+	if err != nil {
+		writeError(w, "Failed to get license keys", err)
+		return
+	}
+	ids := make([]string, len(keys.IngestKeys))
+	for i, key := range keys.IngestKeys {
+		ids[i] = key.ID
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "licenseKey.read",
+		AccountID: accountID,
+		Details:   map[string]any{"ingestKeys": ids},
+	})
+	writeJSON(w, http.StatusOK, keys)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const lockLeaseDuration = 30 * time.Second
+
+// locker hands out named locks so that conflicting mutations, such as two
+// rotations of the same key, can't run at the same time.
+type locker interface {
+	// TryLock takes the lock without waiting. ok is false when it is
+	// already held elsewhere.
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
+}
+
+// newLockerFromEnv picks the lock backend from LOCK_BACKEND. The local
+// backend only protects a single replica; multi-replica deployments should
+// use kubernetes.
+func newLockerFromEnv() (locker, error) {
+	switch backend := os.Getenv("LOCK_BACKEND"); backend {
+	case "", "local":
+		return &localLocker{held: map[string]bool{}}, nil
+	case "kubernetes":
+		kube, err := newKubeClient()
+		if err != nil {
+			return nil, err
+		}
+		return &kubeLocker{kube: kube, identity: replicaIdentity()}, nil
+	default:
+		return nil, fmt.Errorf("unknown LOCK_BACKEND %q", backend)
+	}
+}
+
+type localLocker struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func (l *localLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[name] {
+		return nil, false, nil
+	}
+	l.held[name] = true
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.held, name)
+			l.mu.Unlock()
+		})
+	}, true, nil
+}
+
+// kubeLocker holds each lock as a Kubernetes Lease, renewed for as long as
+// the lock is held. If the holder dies the lease expires and the lock frees
+// itself. Each acquisition holds the lease under its own identity, so a
+// second operation on this replica can't renew its way into a lock that is
+// already held, and releasing a lock never frees one taken after it.
+type kubeLocker struct {
+	kube     *kubeClient
+	identity string
+}
+
+func (l *kubeLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	sum := sha256.Sum256([]byte(name))
+	lease := l.kube.lease("api-go-lock-"+hex.EncodeToString(sum[:8]), l.identity+"/"+newID(), lockLeaseDuration)
+	held, err := lease.TryAcquire(ctx)
+	if err != nil || !held {
+		return nil, false, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockLeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := lease.TryAcquire(context.Background()); err != nil {
+					log.Printf("Failed to renew lock %s: %v", name, err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			release, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := lease.Release(release); err != nil {
+				log.Printf("Failed to release lock %s: %v", name, err)
+			}
+		})
+	}, true, nil
+}
+
+// lock takes the named lock for a request, answering 409 when another
+// operation holds it. It returns false when the request has been answered.
+func (s *Server) lock(w http.ResponseWriter, r *http.Request, name string) (func(), bool) {
+	unlock, ok, err := s.locks.TryLock(r.Context(), name)
+	if err != nil {
+		log.Printf("Failed to take lock %s: %v, Status Code: %d", name, err, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to take lock"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !ok {
+		writeLockConflict(w, name)
+		return nil, false
+	}
+	return unlock, true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLockers(t *testing.T) {
+	kube, _ := newFakeKube(t)
+	for name, l := range map[string]locker{
+		"local":      &localLocker{held: map[string]bool{}},
+		"kubernetes": &kubeLocker{kube: kube, identity: "replica-a"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			unlock, ok, err := l.TryLock(ctx, "rotate:key-1")
+			if err != nil || !ok {
+				t.Fatalf("first lock: %v, %v", ok, err)
+			}
+
+			// A second operation on the same replica is turned away.
+			if _, ok, err := l.TryLock(ctx, "rotate:key-1"); err != nil || ok {
+				t.Fatalf("second lock of a held name: %v, %v; want false", ok, err)
+			}
+			other, ok, err := l.TryLock(ctx, "rotate:key-2")
+			if err != nil || !ok {
+				t.Fatalf("lock of another name: %v, %v", ok, err)
+			}
+			defer other()
+
+			unlock()
+			again, ok, err := l.TryLock(ctx, "rotate:key-1")
+			if err != nil || !ok {
+				t.Fatalf("lock after unlock: %v, %v", ok, err)
+			}
+
+			// Unlocking twice must not free the lock taken since.
+			unlock()
+			if _, ok, _ := l.TryLock(ctx, "rotate:key-1"); ok {
+				t.Error("a stale unlock freed a lock held by another operation")
+			}
+			again()
+		})
+	}
+}
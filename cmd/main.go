@@ -3,44 +3,47 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
-	"github.com/machinebox/graphql"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
 )
 
 // const newRelicGraphQLEndpoint = "https://api.eu.newrelic.com/graphql"
 
 // request
 type InsertKeyRequest struct {
-	AccountID  int    `json:"account_id"`
+	AccountID  int               `json:"account_id"`
+	Name       string            `json:"name"`
+	Notes      string            `json:"notes"`
+	IngestType string            `json:"ingestType"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+type CreatedKey struct {
+	ID         string `json:"id"`
+	Key        string `json:"key"`
 	Name       string `json:"name"`
 	Notes      string `json:"notes"`
+	Type       string `json:"type"`
 	IngestType string `json:"ingestType"`
 }
 
 // response
 type NewRelicResponse struct {
 	APIAccessCreateKeys struct {
-		CreatedKeys []struct {
-			ID         string `json:"id"`
-			Key        string `json:"key"`
-			Name       string `json:"name"`
-			Notes      string `json:"notes"`
-			Type       string `json:"type"`
-			IngestType string `json:"ingestType"`
-		} `json:"createdKeys"`
-		Errors []struct {
-			Message    string `json:"message"`
-			Type       string `json:"type"`
-			AccountID  int    `json:"accountId"`
-			ErrorType  string `json:"errorType"`
-			IngestType string `json:"ingestType"`
-		} `json:"errors"`
+		CreatedKeys []CreatedKey `json:"createdKeys"`
+		Errors      []KeyError   `json:"errors"`
 	} `json:"apiAccessCreateKeys"`
 }
 
@@ -53,16 +56,7 @@ type DeleteKeysResponse struct {
 		DeletedKeys []struct {
 			ID string `json:"id"`
 		} `json:"deletedKeys"`
-		Errors []struct {
-			Message                 string `json:"message"`
-			Type                    string `json:"type"`
-			ApiAccessIngestKeyError struct {
-				ID        string `json:"id"`
-				Message   string `json:"message"`
-				ErrorType string `json:"errorType"`
-				AccountID string `json:"accountId"`
-			} `json:"apiAccessIngestKeyError,omitempty"`
-		} `json:"errors"`
+		Errors []KeyError `json:"errors"`
 	} `json:"apiAccessDeleteKeys"`
 }
 
@@ -74,7 +68,50 @@ type DeleteKeysRequest struct {
 
 type Server struct {
 	client *graphql.Client
-	apiKey string
+	apiKey *secretValue
+	locks  locker
+	auth   *authenticator
+	jobs   *jobQueue
+	audit  *auditLog
+	keys   *keyRegistry
+	search *searchIndex
+	schema *schemaValidator
+
+	// tenants are the per-business-unit credentials, by name.
+	tenants map[string]*Tenant
+	// credentials are the named credentials requests may select.
+	credentials map[string]*Credential
+	// naming fills in key names and notes callers leave out.
+	naming *keyNamer
+	// deferred queues changes while NerdGraph is throttling us; nil when
+	// queueing is off.
+	deferred *deferredQueue
+	// offline queues changes while NerdGraph is down; nil when off.
+	offline *offlineQueue
+	// desiredState is what GET /drift compares the live keys with; nil
+	// when no source is configured.
+	desiredState *desiredStateSource
+	// keyCounter exports key counts per account; nil when off.
+	keyCounter *keyCounter
+	// duplicates is what creating a key whose name is taken does by
+	// default: allow, reject or return.
+	duplicates string
+	// reporter sends the scheduled summary report; nil when off.
+	reporter *summaryReporter
+	// router serves the API, and replays deferred requests.
+	router http.Handler
+
+	// readOnly is set at startup when the credential was rejected and
+	// CREDENTIAL_CHECK=readonly.
+	readOnly bool
+
+	// webhooks is nil unless WEBHOOK_URLS is set.
+	webhooks *webhookNotifier
+
+	// approvals is nil unless destructive changes need a second person.
+	approvals *approvalStore
+
+	deleteGrace time.Duration
 }
 
 // Create an API key
@@ -90,59 +127,33 @@ func (s *Server) createApiKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mutation := fmt.Sprintf(`
-        mutation {
-            apiAccessCreateKeys(
-                keys: {
-                    ingest: {
-                        accountId: %d
-                        ingestType: %s
-                        name: "%s"
-                        notes: "%s"
-                    }
-                }
-            ) {
-                createdKeys {
-                    id
-                    key
-                    name
-                    notes
-                    type
-                    ... on ApiAccessIngestKey {
-                        ingestType
-                    }
-                }
-                errors {
-                    message
-                    type
-                    ... on ApiAccessIngestKeyError {
-                        accountId
-                        errorType
-                        ingestType
-                    }
-                }
-            }
-        }
-    `, request.AccountID, request.IngestType, request.Name, request.Notes)
-
-	req := graphql.NewRequest(mutation)
-
-	req.Header.Set("API-Key", s.apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	ctx := context.Background()
-	var responseData NewRelicResponse
-
-	err = s.client.Run(ctx, req, &responseData)
-	if err != nil {
-		log.Printf("Failed to create insert key: %v, Status Code: %d", err, http.StatusInternalServerError)
-		http.Error(w, "Failed to create insert key", http.StatusInternalServerError)
+	if err := validateLabels(request.Labels); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if !authorizeAccount(w, r, request.AccountID) {
+		return
+	}
+
+	unlock, ok := s.checkDuplicate(w, r, &request)
+	if !ok {
+		return
+	}
+	if unlock != nil {
+		defer unlock()
+	}
+
+	responseData, err := s.createIngestKey(r.Context(), request)
+	if err != nil {
+		writeError(w, "Failed to create insert key", err)
 		return
 	}
 
 	if len(responseData.APIAccessCreateKeys.CreatedKeys) > 0 {
 		createdKey := responseData.APIAccessCreateKeys.CreatedKeys[0]
 		log.Printf("Successfully created key: ID=%s, Name=%s", createdKey.ID, createdKey.Name)
+		s.audit.Record(r.Context(), AuditEntry{Action: "key.create", AccountID: request.AccountID, KeyID: createdKey.ID})
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]any{
 			"insert_key": createdKey,
@@ -151,7 +162,7 @@ func (s *Server) createApiKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(responseData.APIAccessCreateKeys.Errors) > 0 {
-		http.Error(w, fmt.Sprintf("API returned an error: %v", responseData.APIAccessCreateKeys.Errors), http.StatusBadRequest)
+		writeKeyErrors(w, "API returned an error", responseData.APIAccessCreateKeys.Errors, http.StatusBadRequest)
 		return
 	}
 
@@ -163,12 +174,6 @@ func (s *Server) createApiKey(w http.ResponseWriter, r *http.Request) {
 func (s *Server) deleteApiKey(w http.ResponseWriter, r *http.Request) {
 	log.Println("Received request to delete a key")
 
-	apiKey := os.Getenv("NEW_RELIC_API_KEY")
-	if apiKey == "" {
-		http.Error(w, `{"error": "Missing NEW_RELIC_API_KEY"}`, http.StatusUnauthorized)
-		return
-	}
-
 	var request DeleteKeyRequest
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil || request.ID == "" {
@@ -177,84 +182,387 @@ func (s *Server) deleteApiKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mutation := fmt.Sprintf(`
-	mutation {
-		apiAccessDeleteKeys(keys: { ingestKeyIds: ["%s"] }) {
-			deletedKeys {
-				id
-			}
-			errors {
-				message
-			}
-		}
-	}`, request.ID)
-
-	req := graphql.NewRequest(mutation)
-
-	req.Header.Set("API-Key", apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	var responseData DeleteKeysResponse
+	s.deleteKeyWithGrace(w, r, request.ID)
+}
 
-	ctx := context.Background()
+// NerdGraph endpoints by New Relic region.
+var newRelicGraphQLEndpoints = map[string]string{
+	"US": "https://api.newrelic.com/graphql",
+	"EU": "https://api.eu.newrelic.com/graphql",
+}
 
-	err = s.client.Run(ctx, req, &responseData)
-	if err != nil {
-		log.Printf("Error executing GraphQL request: %v", err)
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to delete key", "details": "%s"}`, err.Error()), http.StatusInternalServerError)
-		return
+// retryUpstream retries queries, never mutations, that failed because
+// NerdGraph or the network in front of it fell over, up to twice.
+func retryUpstream(req *graphql.Request, attempt int, err error) (time.Duration, bool) {
+	if req.IsMutation() || attempt > 2 {
+		return 0, false
 	}
-
-	if len(responseData.ApiAccessDeleteKeys.Errors) > 0 {
-		errorMessages := []string{}
-		for _, e := range responseData.ApiAccessDeleteKeys.Errors {
-			errorMessages = append(errorMessages, e.Message)
-		}
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to delete key", "details": "%s"}`, errorMessages), http.StatusInternalServerError)
-		log.Printf("Failed to delete key: %v, Status Code: %d", errorMessages, http.StatusInternalServerError)
-		return
+	var httpErr *graphql.HTTPError
+	var rateLimitErr *RateLimitError
+	switch {
+	case errors.As(err, &rateLimitErr), errors.As(err, new(graphql.Errors)):
+		return 0, false
+	case errors.As(err, &httpErr) && httpErr.StatusCode < 500:
+		return 0, false
 	}
-
-	log.Printf("Successfully deleted key: Status Code=%d", http.StatusOK)
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]any{
-		"deleted_key": request.ID,
-	})
+	return time.Duration(attempt) * 500 * time.Millisecond, true
 }
 
-func GetClient() (*graphql.Client, error) {
-	newRelicGraphQLEndpoint := "https://api.eu.newrelic.com/graphql"
-	client := graphql.NewClient(newRelicGraphQLEndpoint)
+func GetClient(region string) (*graphql.Client, error) {
+	if region == "" {
+		region = "EU"
+	}
+	newRelicGraphQLEndpoint, ok := newRelicGraphQLEndpoints[strings.ToUpper(region)]
+	if !ok {
+		return nil, fmt.Errorf("unknown New Relic region %q", region)
+	}
+	availability := &availabilityTransport{next: telemetryTransport{next: upstreamTransport}}
+	rateLimit := newRateLimitTransport(availability)
+	client := graphql.NewClient(newRelicGraphQLEndpoint,
+		graphql.WithHTTPClient(&http.Client{Transport: rateLimit}),
+		graphql.WithRetry(retryUpstream),
+	)
+	rateLimits.Store(client, rateLimit)
+	upstreamAvailability.Store(client, availability)
 	log.Println("Successfully connected to NerdGraph client")
 	return client, nil
 }
 
 func main() {
+	log.SetOutput(redactingWriter{w: os.Stderr})
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
-	apiKey := os.Getenv("NEW_RELIC_API_KEY")
-	if apiKey == "" {
-		log.Fatalf("Missing NEW_RELIC_API_KEY.")
+	apiKey, err := secretFromEnv("NEW_RELIC_API_KEY")
+	if err != nil {
+		log.Fatalf("Missing NEW_RELIC_API_KEY or NEW_RELIC_API_KEY_FILE: %v", err)
+	}
+	shutdownWait, err := shutdownTimeout()
+	if err != nil {
+		log.Fatalf("Invalid shutdown settings: %v", err)
+	}
+
+	reloadInterval, err := secretReloadInterval()
+	if err != nil {
+		log.Fatalf("Invalid secret reload settings: %v", err)
+	}
+
+	dataSealer, err = newSealerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize encryption: %v", err)
+	}
+	if dataSealer == nil {
+		log.Println("Warning: ENCRYPTION_MASTER_KEY is not set, persisted data will not be encrypted")
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	transport, err := config.Transport.build()
+	if err != nil {
+		log.Fatalf("Invalid transport settings: %v", err)
+	}
+	upstreamTransport = transport
+
+	overridden, err := loadDocumentOverrides()
+	if err != nil {
+		log.Fatalf("Failed to load GraphQL templates: %v", err)
+	}
+	if len(overridden) > 0 {
+		log.Printf("Using GraphQL template overrides for %s", strings.Join(overridden, ", "))
 	}
 
-	client, err := GetClient()
+	client, err := GetClient(os.Getenv("NEW_RELIC_REGION"))
 	if err != nil {
 		log.Fatalf("Failed to initialize GraphQL client: %v", err)
 	}
 
+	tenants, err := buildTenants(config)
+	if err != nil {
+		log.Fatalf("Failed to initialize tenants: %v", err)
+	}
+
+	naming, err := newKeyNamer(config.Naming)
+	if err != nil {
+		log.Fatalf("Invalid naming templates: %v", err)
+	}
+
+	credentials, err := buildCredentials(config)
+	if err != nil {
+		log.Fatalf("Failed to initialize credentials: %v", err)
+	}
+
+	leader, err := newElectorFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize leader election: %v", err)
+	}
+
+	locks, err := newLockerFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize locks: %v", err)
+	}
+
+	auth, err := newAuthenticator(config, tenants, credentials)
+	if err != nil {
+		log.Fatalf("Failed to load caller tokens: %v", err)
+	}
+	watched := append([]*secretValue{apiKey}, auth.secrets()...)
+	for _, tenant := range tenants {
+		watched = append(watched, tenant.APIKey)
+	}
+	for _, credential := range credentials {
+		watched = append(watched, credential.APIKey)
+	}
+	go watchSecrets(context.Background(), reloadInterval, watched, auth.rebuild)
+
+	oidc, err := newOIDCProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid OIDC settings: %v", err)
+	}
+	if oidc != nil {
+		if len(auth.users) == 0 {
+			log.Fatalf("OIDC_ISSUER needs callers marked oidc to sign in as")
+		}
+		auth.oidc = oidc
+	}
+
+	policy, err := newPolicyFileFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load policy: %v", err)
+	}
+	if policy != nil {
+		if len(config.Callers) == 0 {
+			log.Fatalf("POLICY_FILE needs callers configured to bind roles to")
+		}
+		auth.policy = policy
+		go policy.watch(context.Background(), reloadInterval)
+	}
+
+	jobQueue, err := newJobQueue(dataPath("jobs.json"), auth)
+	if err != nil {
+		log.Fatalf("Failed to load jobs: %v", err)
+	}
+
+	deleteGrace, err := deleteGraceFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid DELETE_GRACE_HOURS: %v", err)
+	}
+
+	audit, err := newAuditLog(dataPath("audit.log"))
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	registry, err := newKeyRegistry(dataPath("registry.json"))
+	if err != nil {
+		log.Fatalf("Failed to load key registry: %v", err)
+	}
+
+	schema, err := newSchemaValidatorFromEnv(client, apiKey)
+	if err != nil {
+		log.Fatalf("Invalid schema settings: %v", err)
+	}
+
+	search, err := newSearchIndex()
+	if err != nil {
+		log.Fatalf("Invalid search settings: %v", err)
+	}
+
+	deferred, err := newDeferredQueueFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid rate limit queue settings: %v", err)
+	}
+
+	offline, err := newOfflineQueueFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid offline queue settings: %v", err)
+	}
+
+	desiredState, err := newDesiredStateSourceFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid desired state settings: %v", err)
+	}
+
+	keyCounter, err := newKeyCounterFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid key metrics settings: %v", err)
+	}
+
+	duplicates, err := duplicateModeFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid duplicate key settings: %v", err)
+	}
+
+	reporter, err := newSummaryReporterFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid summary report settings: %v", err)
+	}
+
+	var approvals *approvalStore
+	if os.Getenv("APPROVAL_REQUIRED") == "true" {
+		if len(config.Callers) == 0 {
+			log.Fatalf("APPROVAL_REQUIRED needs callers configured to tell requesters and approvers apart")
+		}
+		approvals, err = newApprovalStore(dataPath("approvals.json"))
+		if err != nil {
+			log.Fatalf("Failed to load approvals: %v", err)
+		}
+	}
+
 	server := &Server{
-		client: client,
-		apiKey: apiKey,
+		client:       client,
+		apiKey:       apiKey,
+		locks:        locks,
+		auth:         auth,
+		jobs:         jobQueue,
+		audit:        audit,
+		keys:         registry,
+		search:       search,
+		schema:       schema,
+		tenants:      tenants,
+		credentials:  credentials,
+		naming:       naming,
+		deferred:     deferred,
+		offline:      offline,
+		desiredState: desiredState,
+		keyCounter:   keyCounter,
+		duplicates:   duplicates,
+		reporter:     reporter,
+		webhooks:     newWebhookNotifierFromEnv(),
+		approvals:    approvals,
+		deleteGrace:  deleteGrace,
+	}
+	if err := server.applyCredentialCheck(context.Background()); err != nil {
+		log.Fatalf("Failed to validate New Relic credentials: %v", err)
+	}
+	server.registerBulkJobs()
+	server.registerDeleteJobs()
+	server.registerBulkDeleteJobs()
+	server.registerWebhookJobs()
+	server.registerDeferredJobs()
+	server.registerOfflineJobs()
+	server.registerReportJobs()
+
+	jobs := []backgroundJob{jobQueue.backgroundJob()}
+	if approvals != nil {
+		jobs = append(jobs, backgroundJob{name: "approval-expiry", interval: time.Minute, run: server.expireApprovals})
+	}
+	if keyCounter != nil {
+		jobs = append(jobs, backgroundJob{name: "key-metrics", interval: keyCounter.interval, run: server.countKeys})
+	}
+	if reporter != nil {
+		jobs = append(jobs, backgroundJob{name: "summary-report", interval: reportCheckInterval, run: server.sendDueReport})
+	}
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	backgroundDone := runBackgroundJobs(backgroundCtx, leader, jobs)
+
+	accessLog, err := newAccessLogFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open access log: %v", err)
 	}
 
 	r := mux.NewRouter()
+	r.Use(gzipMiddleware)
+	r.Use(auth.Middleware)
+	if policy != nil {
+		r.Use(policy.Middleware)
+	}
+	r.Use(auth.CredentialMiddleware)
+	r.Use(server.readOnlyMiddleware)
+	r.Use(server.deferMiddleware)
+	r.Use(server.offlineMiddleware)
 	r.HandleFunc("/createKey", server.createApiKey).Methods("POST")
 	r.HandleFunc("/deleteKey", server.deleteApiKey).Methods("DELETE")
+	r.HandleFunc("/accounts/{account}/usage", server.getAccountUsage).Methods("GET")
+	r.HandleFunc("/accounts/{account}/issues", server.getAccountIssues).Methods("GET")
+	r.HandleFunc(licenseKeysRoute, server.getAccountLicenseKeys).Methods("GET")
+	r.HandleFunc("/accounts/{account}/keys", server.deleteKeyByName).Methods("DELETE")
+	r.HandleFunc("/accounts/{account}/keys/rotate", server.rotateKeyByName).Methods("POST")
+	r.HandleFunc("/accounts/{account}/mutingRules", server.createMutingRuleHandler).Methods("POST")
+	r.HandleFunc("/accounts/{account}/mutingRules/{id}", server.updateMutingRuleHandler).Methods("PUT")
+	r.HandleFunc("/accounts/{account}/mutingRules/{id}", server.deleteMutingRuleHandler).Methods("DELETE")
+	r.HandleFunc("/accounts/{account}/policies/{policy}/conditions", server.createNrqlConditionHandler).Methods("POST")
+	r.HandleFunc("/accounts/{account}/conditions/{id}", server.updateNrqlConditionHandler).Methods("PUT")
+	r.HandleFunc("/accounts/{account}/conditions/{id}", server.deleteConditionHandler).Methods("DELETE")
+	r.HandleFunc("/accounts/{account}/policies/{policy}/anomalyDetectors", server.createAnomalyDetectorHandler).Methods("POST")
+	r.HandleFunc("/accounts/{account}/anomalyDetectors/{id}", server.updateAnomalyDetectorHandler).Methods("PATCH")
+	r.HandleFunc("/accounts/{account}/eventsToMetrics", server.createEventsToMetricsRuleHandler).Methods("POST")
+	r.HandleFunc("/accounts/{account}/eventsToMetrics/{id}", server.deleteEventsToMetricsRuleHandler).Methods("DELETE")
+	r.HandleFunc("/accounts/{account}/metricNormalizationRules", server.saveMetricNormalizationRule).Methods("POST")
+	r.HandleFunc("/accounts/{account}/metricNormalizationRules/{id}", server.saveMetricNormalizationRule).Methods("PUT")
+	r.HandleFunc("/accounts/{account}/metricNormalizationRules/{id}/{toggle:enable|disable}", server.toggleMetricNormalizationRule).Methods("POST")
+	r.HandleFunc("/apps/browser", server.createBrowserApplication).Methods("POST")
+	r.HandleFunc("/apps/mobile", server.createMobileApplication).Methods("POST")
+	r.HandleFunc("/apps/mobile/{guid}/token", server.getMobileApplicationToken).Methods("GET")
+	r.HandleFunc("/keys", server.listKeys).Methods("GET")
+	r.HandleFunc("/keys/fanout", server.fanOutApiKey).Methods("POST")
+	r.HandleFunc("/keys/delete", server.bulkDeleteApiKeys).Methods("POST")
+	r.HandleFunc("/keys/import", server.importApiKeys).Methods("POST")
+	r.HandleFunc("/keys/rotate", server.bulkRotateApiKeys).Methods("POST")
+	r.HandleFunc("/keys/search", server.searchKeys).Methods("GET")
+	r.HandleFunc("/keys/validate", server.validateKey).Methods("POST")
+	r.HandleFunc("/keys/{id}", server.deleteKeyByID).Methods("DELETE")
+	r.HandleFunc("/keys/{id}/undelete", server.undeleteKey).Methods("POST")
+	r.HandleFunc("/keys/{id}/labels", server.setKeyLabels).Methods("PUT", "PATCH")
+	r.HandleFunc("/keys/{id}/rotate", server.rotateApiKey).Methods("POST")
+	r.HandleFunc("/keys/{id}/transfer", server.transferKey).Methods("POST")
+	r.HandleFunc("/reconcile", server.reconcileKeys).Methods("POST")
+	r.HandleFunc("/drift", server.getDrift).Methods("GET")
+	r.HandleFunc("/audit", server.getAuditLog).Methods("GET")
+	r.HandleFunc("/deployments", server.createDeploymentHandler).Methods("POST")
+	r.HandleFunc("/entities/{guid}/summary", server.getEntitySummaryHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}", server.getJob).Methods("GET")
+	if approvals != nil {
+		r.HandleFunc("/approvals/{id}", server.getApproval).Methods("GET")
+		r.HandleFunc("/approvals/{id}/approve", server.approveChange).Methods("POST")
+		r.HandleFunc("/approvals/{id}/reject", server.rejectChange).Methods("POST")
+	}
+
+	server.router = r
 
 	port := ":8080"
 	fmt.Println("Server is running on port", port)
-	log.Fatal(http.ListenAndServe(port, r))
+	// Metrics are served outside the router so scrapers don't need a token.
+	root := http.NewServeMux()
+	root.Handle("/metrics", metrics)
+	if oidc != nil {
+		root.Handle("/auth/", oidc.Handler(auth))
+	}
+	if os.Getenv("UI_ENABLED") != "false" {
+		root.Handle("/ui/", uiHandler())
+	}
+	root.Handle("/", r)
+	httpServer := &http.Server{Addr: port, Handler: requestIDMiddleware(accessLog.Middleware(metricsMiddleware(root)))}
+
+	var shutdown shutdownHooks
+	shutdown.Add("http server", httpServer.Shutdown)
+	shutdown.Add("job queue", jobQueue.Pause)
+	shutdown.Add("background jobs", func(ctx context.Context) error {
+		stopBackground()
+		return waitDone(ctx, backgroundDone, "background jobs")
+	})
+	shutdown.Add("audit log", audit.Sync)
+	shutdown.Add("access log", accessLog.Close)
+
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	<-stopped.Done()
+	stop()
+
+	log.Printf("Shutting down, waiting up to %s", shutdownWait)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownWait)
+	defer cancel()
+	if err := shutdown.Run(ctx); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+	}
 }
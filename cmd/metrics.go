@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Latency buckets in seconds, shared by every histogram.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics is the registry served on GET /metrics in the Prometheus text
+// format.
+var metrics = &metricRegistry{}
+
+type metricRegistry struct {
+	mu       sync.Mutex
+	families []metricFamily
+}
+
+type metricFamily interface {
+	write(w io.Writer)
+}
+
+func (m *metricRegistry) register(family metricFamily) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.families = append(m.families, family)
+}
+
+// ServeHTTP writes every registered metric.
+func (m *metricRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	families := slices.Clone(m.families)
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, family := range families {
+		family.write(w)
+	}
+}
+
+// series is the labelled part shared by every metric kind: the values of
+// each label combination, keyed by the joined label values.
+type series[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*T
+	keys   map[string][]string
+}
+
+func newSeries[T any](name, help, kind string, labels []string) *series[T] {
+	return &series[T]{name: name, help: help, kind: kind, labels: labels, values: map[string]*T{}, keys: map[string][]string{}}
+}
+
+// get returns the value for the label values, creating it with init. The
+// caller must hold s.mu.
+func (s *series[T]) get(labelValues []string, init func() *T) *T {
+	key := strings.Join(labelValues, "\xff")
+	value, ok := s.values[key]
+	if !ok {
+		value = init()
+		s.values[key] = value
+		s.keys[key] = slices.Clone(labelValues)
+	}
+	return value
+}
+
+// each calls fn for every label combination in a stable order.
+func (s *series[T]) each(w io.Writer, fn func(labels []string, value *T)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fn(s.keys[key], s.values[key])
+	}
+}
+
+// formatLabels renders name="value" pairs, with extra appended.
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(values[i]))
+	}
+	pairs = append(pairs, extra...)
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// counterVec is a counter per label combination.
+type counterVec struct {
+	*series[float64]
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{newSeries[float64](name, help, "counter", labels)}
+	metrics.register(c)
+	return c
+}
+
+func (c *counterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labelValues, func() *float64 { return new(float64) }) += delta
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.each(w, func(labels []string, value *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, labels), formatValue(*value))
+	})
+}
+
+// gaugeVec is a value per label combination that can go up and down.
+type gaugeVec struct {
+	*series[float64]
+}
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	g := &gaugeVec{newSeries[float64](name, help, "gauge", labels)}
+	metrics.register(g)
+	return g
+}
+
+func (g *gaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues, func() *float64 { return new(float64) }) = value
+}
+
+func (g *gaugeVec) write(w io.Writer) {
+	g.each(w, func(labels []string, value *float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, labels), formatValue(*value))
+	})
+}
+
+// histogramVec counts observations into latencyBuckets per label
+// combination.
+type histogramVec struct {
+	*series[histogram]
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	h := &histogramVec{newSeries[histogram](name, help, "histogram", labels)}
+	metrics.register(h)
+	return h
+}
+
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist := h.get(labelValues, func() *histogram { return &histogram{counts: make([]uint64, len(latencyBuckets))} })
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.each(w, func(labels []string, hist *histogram) {
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, labels, `le="`+formatValue(bound)+`"`), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, labels, `le="+Inf"`), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, labels), formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, labels), hist.count)
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// MobileAppRequest creates a mobile application.
+type MobileAppRequest struct {
+	AccountID int    `json:"account_id"`
+	Name      string `json:"name"`
+}
+
+// MobileApplication is a mobile app with the token its agent reports with.
+type MobileApplication struct {
+	GUID             string `json:"guid"`
+	Name             string `json:"name"`
+	AccountID        int    `json:"accountId"`
+	ApplicationToken string `json:"applicationToken"`
+}
+
+// createMobileApp runs the agentApplicationCreateMobile mutation.
+func (s *Server) createMobileApp(ctx context.Context, request MobileAppRequest) (*MobileApplication, error) {
+	req, err := newRequest("createMobileApp", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("accountId", request.AccountID)
+	req.Var("name", request.Name)
+
+	var responseData struct {
+		AgentApplicationCreateMobile *MobileApplication `json:"agentApplicationCreateMobile"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	app := responseData.AgentApplicationCreateMobile
+	if app == nil {
+		return nil, fmt.Errorf("no mobile application was created")
+	}
+	return app, nil
+}
+
+// getMobileApp looks up a mobile application entity and its token.
+func (s *Server) getMobileApp(ctx context.Context, guid string) (*MobileApplication, error) {
+	req, err := newRequest("getMobileApp", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("guid", guid)
+
+	var responseData struct {
+		Actor struct {
+			Entity *MobileApplication `json:"entity"`
+		} `json:"actor"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	// Entities of any other type come back without the mobile fields.
+	app := responseData.Actor.Entity
+	if app == nil || app.GUID == "" {
+		return nil, fmt.Errorf("mobile application %w", errNotFound)
+	}
+	return app, nil
+}
+
+// Create a mobile application
+func (s *Server) createMobileApplication(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to create a mobile application")
+
+	var request MobileAppRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	if request.AccountID <= 0 || request.Name == "" {
+		http.Error(w, `{"error": "Invalid request: account_id and name are required"}`, http.StatusBadRequest)
+		return
+	}
+	if !authorizeAccount(w, r, request.AccountID) {
+		return
+	}
+
+	app, err := s.createMobileApp(r.Context(), request)
+	if err != nil {
+		writeError(w, "Failed to create mobile application", err)
+		return
+	}
+	log.Printf("Successfully created mobile application: GUID=%s, Name=%s", app.GUID, app.Name)
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "app.create",
+		AccountID: request.AccountID,
+		Details:   map[string]any{"guid": app.GUID, "name": app.Name, "type": "mobile"},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"application": app})
+}
+
+// Get the application token of a mobile application
+func (s *Server) getMobileApplicationToken(w http.ResponseWriter, r *http.Request) {
+	guid := mux.Vars(r)["guid"]
+	log.Printf("Received request for the token of mobile application %s", guid)
+
+	app, err := s.getMobileApp(r.Context(), guid)
+	if err != nil {
+		writeError(w, "Failed to look up mobile application", err)
+		return
+	}
+	if !authorizeAccount(w, r, app.AccountID) {
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "app.token.read",
+		AccountID: app.AccountID,
+		Details:   map[string]any{"guid": app.GUID},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"application": app})
+}
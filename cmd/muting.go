@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// naiveDateTime is the format NerdGraph expects for muting rule schedules;
+// the zone is given separately in timeZone.
+const naiveDateTime = "2006-01-02T15:04:05"
+
+// MutingRuleCondition matches incidents on one attribute.
+type MutingRuleCondition struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values"`
+}
+
+// MutingRuleConditionGroup combines conditions with AND or OR.
+type MutingRuleConditionGroup struct {
+	Operator   string                `json:"operator"`
+	Conditions []MutingRuleCondition `json:"conditions"`
+}
+
+// MutingRuleSchedule limits a rule to a window, optionally repeating.
+type MutingRuleSchedule struct {
+	StartTime        string   `json:"startTime,omitempty"`
+	EndTime          string   `json:"endTime,omitempty"`
+	TimeZone         string   `json:"timeZone"`
+	Repeat           string   `json:"repeat,omitempty"`
+	EndRepeat        string   `json:"endRepeat,omitempty"`
+	RepeatCount      int      `json:"repeatCount,omitempty"`
+	WeeklyRepeatDays []string `json:"weeklyRepeatDays,omitempty"`
+}
+
+// MutingRule silences alert notifications for matching incidents, such as
+// during planned maintenance.
+type MutingRule struct {
+	ID          string                   `json:"id,omitempty"`
+	Name        string                   `json:"name"`
+	Description string                   `json:"description,omitempty"`
+	Enabled     bool                     `json:"enabled"`
+	Condition   MutingRuleConditionGroup `json:"condition"`
+	Schedule    *MutingRuleSchedule      `json:"schedule,omitempty"`
+}
+
+// validate checks a rule before it is sent, so mistakes come back as a 400
+// naming the field rather than a NerdGraph error.
+func (rule *MutingRule) validate() error {
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if rule.Condition.Operator != "AND" && rule.Condition.Operator != "OR" {
+		return fmt.Errorf("condition.operator must be AND or OR")
+	}
+	if len(rule.Condition.Conditions) == 0 {
+		return fmt.Errorf("condition.conditions needs at least one condition")
+	}
+	for i, condition := range rule.Condition.Conditions {
+		if condition.Attribute == "" || condition.Operator == "" {
+			return fmt.Errorf("condition.conditions[%d] needs an attribute and an operator", i)
+		}
+	}
+	if schedule := rule.Schedule; schedule != nil {
+		if schedule.TimeZone == "" {
+			return fmt.Errorf("schedule.timeZone is required")
+		}
+		if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+			return fmt.Errorf("schedule.timeZone %q is not a known time zone", schedule.TimeZone)
+		}
+		for name, value := range map[string]string{"startTime": schedule.StartTime, "endTime": schedule.EndTime, "endRepeat": schedule.EndRepeat} {
+			if _, err := time.Parse(naiveDateTime, value); value != "" && err != nil {
+				return fmt.Errorf("schedule.%s must look like %s", name, naiveDateTime)
+			}
+		}
+		if schedule.StartTime != "" && schedule.EndTime != "" && schedule.EndTime <= schedule.StartTime {
+			return fmt.Errorf("schedule.endTime must be after startTime")
+		}
+	}
+	return nil
+}
+
+// input is the rule as AlertsMutingRuleInput.
+func (rule *MutingRule) input() map[string]any {
+	input := map[string]any{
+		"name":        rule.Name,
+		"description": rule.Description,
+		"enabled":     rule.Enabled,
+		"condition":   rule.Condition,
+	}
+	if rule.Schedule != nil {
+		input["schedule"] = rule.Schedule
+	}
+	return input
+}
+
+// createMutingRule runs the alertsMutingRuleCreate mutation.
+func (s *Server) createMutingRule(ctx context.Context, accountID int, rule MutingRule) (*MutingRule, error) {
+	req, err := newRequest("createMutingRule", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("accountId", accountID)
+	req.Var("rule", rule.input())
+
+	var responseData struct {
+		AlertsMutingRuleCreate *MutingRule `json:"alertsMutingRuleCreate"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if responseData.AlertsMutingRuleCreate == nil {
+		return nil, fmt.Errorf("no muting rule was created")
+	}
+	return responseData.AlertsMutingRuleCreate, nil
+}
+
+// updateMutingRule runs the alertsMutingRuleUpdate mutation, replacing the
+// rule with the one given.
+func (s *Server) updateMutingRule(ctx context.Context, accountID int, id string, rule MutingRule) (*MutingRule, error) {
+	req, err := newRequest("updateMutingRule", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("accountId", accountID)
+	req.Var("id", id)
+	req.Var("rule", rule.input())
+
+	var responseData struct {
+		AlertsMutingRuleUpdate *MutingRule `json:"alertsMutingRuleUpdate"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if responseData.AlertsMutingRuleUpdate == nil {
+		return nil, fmt.Errorf("muting rule %w", errNotFound)
+	}
+	return responseData.AlertsMutingRuleUpdate, nil
+}
+
+// deleteMutingRule runs the alertsMutingRuleDelete mutation.
+func (s *Server) deleteMutingRule(ctx context.Context, accountID int, id string) error {
+	req, err := newRequest("deleteMutingRule", nil)
+	if err != nil {
+		return err
+	}
+	req.Var("accountId", accountID)
+	req.Var("id", id)
+
+	var responseData struct {
+		AlertsMutingRuleDelete *struct {
+			ID string `json:"id"`
+		} `json:"alertsMutingRuleDelete"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return err
+	}
+	if responseData.AlertsMutingRuleDelete == nil {
+		return fmt.Errorf("muting rule %w", errNotFound)
+	}
+	return nil
+}
+
+// decodeMutingRule reads and validates a rule from the request body. It
+// returns false when the request has been answered.
+func decodeMutingRule(w http.ResponseWriter, r *http.Request) (MutingRule, bool) {
+	var rule MutingRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return rule, false
+	}
+	if err := rule.validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid request: "+err.Error()), http.StatusBadRequest)
+		return rule, false
+	}
+	return rule, true
+}
+
+// Create a muting rule
+func (s *Server) createMutingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to create a muting rule")
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := decodeMutingRule(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := s.createMutingRule(r.Context(), accountID, rule)
+	if err != nil {
+		writeError(w, "Failed to create muting rule", err)
+		return
+	}
+	log.Printf("Successfully created muting rule: ID=%s, Name=%s", created.ID, created.Name)
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "mutingRule.create",
+		AccountID: accountID,
+		Details:   map[string]any{"rule": created.ID, "name": created.Name},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"muting_rule": created})
+}
+
+// Update a muting rule
+func (s *Server) updateMutingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to update muting rule %s", id)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := decodeMutingRule(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := s.updateMutingRule(r.Context(), accountID, id, rule)
+	if err != nil {
+		writeError(w, "Failed to update muting rule", err)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "mutingRule.update",
+		AccountID: accountID,
+		Details:   map[string]any{"rule": id, "name": updated.Name, "enabled": updated.Enabled},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"muting_rule": updated})
+}
+
+// Delete a muting rule
+func (s *Server) deleteMutingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to delete muting rule %s", id)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.deleteMutingRule(r.Context(), accountID, id); err != nil {
+		writeError(w, "Failed to delete muting rule", err)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "mutingRule.delete",
+		AccountID: accountID,
+		Details:   map[string]any{"rule": id},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"deleted_muting_rule": id})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NamingConfig holds the templates that name keys, and write their notes,
+// when the caller leaves them out. Templates set for an account win over
+// those of its team, which win over the defaults.
+type NamingConfig struct {
+	NamingTemplates
+	Teams    map[string]NamingTemplates `json:"teams"`
+	Accounts map[int]AccountNaming      `json:"accounts"`
+}
+
+// NamingTemplates are Go templates executed with a KeyNameData.
+type NamingTemplates struct {
+	Name  string `json:"name"`
+	Notes string `json:"notes"`
+}
+
+// AccountNaming sets the team and environment of an account, and
+// optionally templates for its keys alone.
+type AccountNaming struct {
+	NamingTemplates
+	Team string `json:"team"`
+	Env  string `json:"env"`
+}
+
+// KeyNameData is what naming templates can refer to. Team and Env come from
+// the key's "team" and "env" labels, or else from the account's naming
+// settings.
+type KeyNameData struct {
+	Team       string
+	Env        string
+	Date       string
+	AccountID  int
+	IngestType string
+	Caller     string
+	Labels     map[string]string
+}
+
+type namingTemplates struct {
+	name  *template.Template
+	notes *template.Template
+}
+
+// keyNamer fills in the names and notes of keys from the naming config.
+type keyNamer struct {
+	defaults         namingTemplates
+	teams            map[string]namingTemplates
+	accounts         map[int]AccountNaming
+	accountTemplates map[int]namingTemplates
+}
+
+// newKeyNamer parses the templates and tries them out, so a template that
+// refers to a field that doesn't exist fails at startup. It returns nil
+// when no naming is configured.
+func newKeyNamer(config NamingConfig) (*keyNamer, error) {
+	n := &keyNamer{
+		teams:            map[string]namingTemplates{},
+		accounts:         config.Accounts,
+		accountTemplates: map[int]namingTemplates{},
+	}
+	configured := false
+	parse := func(what string, t NamingTemplates) (namingTemplates, error) {
+		var parsed namingTemplates
+		var err error
+		if parsed.name, err = parseNamingTemplate(what+" name", t.Name); err != nil {
+			return parsed, err
+		}
+		if parsed.notes, err = parseNamingTemplate(what+" notes", t.Notes); err != nil {
+			return parsed, err
+		}
+		configured = configured || parsed.name != nil || parsed.notes != nil
+		return parsed, nil
+	}
+
+	var err error
+	if n.defaults, err = parse("default", config.NamingTemplates); err != nil {
+		return nil, err
+	}
+	for team, t := range config.Teams {
+		if n.teams[team], err = parse("team "+team, t); err != nil {
+			return nil, err
+		}
+	}
+	for accountID, account := range config.Accounts {
+		if n.accountTemplates[accountID], err = parse(fmt.Sprintf("account %d", accountID), account.NamingTemplates); err != nil {
+			return nil, err
+		}
+	}
+	if !configured {
+		return nil, nil
+	}
+	return n, nil
+}
+
+func parseNamingTemplate(what, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(what).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("naming template for %s: %w", what, err)
+	}
+	if err := t.Execute(new(strings.Builder), KeyNameData{}); err != nil {
+		return nil, fmt.Errorf("naming template for %s: %w", what, err)
+	}
+	return t, nil
+}
+
+// pick returns the most specific template set by choose.
+func (n *keyNamer) pick(accountID int, team string, choose func(namingTemplates) *template.Template) *template.Template {
+	if t := choose(n.accountTemplates[accountID]); t != nil {
+		return t
+	}
+	if t := choose(n.teams[team]); t != nil {
+		return t
+	}
+	return choose(n.defaults)
+}
+
+// apply fills in the name and notes of a key the caller left empty.
+func (n *keyNamer) apply(ctx context.Context, request *InsertKeyRequest) error {
+	if n == nil || (request.Name != "" && request.Notes != "") {
+		return nil
+	}
+	account := n.accounts[request.AccountID]
+	data := KeyNameData{
+		Team:       account.Team,
+		Env:        account.Env,
+		Date:       time.Now().UTC().Format(time.DateOnly),
+		AccountID:  request.AccountID,
+		IngestType: request.IngestType,
+		Caller:     actorName(ctx),
+		Labels:     request.Labels,
+	}
+	if team := request.Labels["team"]; team != "" {
+		data.Team = team
+	}
+	if env := request.Labels["env"]; env != "" {
+		data.Env = env
+	}
+
+	render := func(t *template.Template) (string, error) {
+		if t == nil {
+			return "", nil
+		}
+		var out strings.Builder
+		if err := t.Execute(&out, data); err != nil {
+			return "", fmt.Errorf("rendering %s: %w", t.Name(), err)
+		}
+		return strings.TrimSpace(out.String()), nil
+	}
+	var err error
+	if request.Name == "" {
+		t := n.pick(request.AccountID, data.Team, func(t namingTemplates) *template.Template { return t.name })
+		if request.Name, err = render(t); err != nil {
+			return err
+		}
+	}
+	if request.Notes == "" {
+		t := n.pick(request.AccountID, data.Team, func(t namingTemplates) *template.Template { return t.notes })
+		if request.Notes, err = render(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+var errKeyNotFound = errors.New("key not found")
+
+// runGraphQL sends a request to NerdGraph with the credential belonging to
+// the caller's tenant, or the server's own when the caller has none.
+// Requests that no longer match the schema fail with a *SchemaError instead
+// of being sent.
+func (s *Server) runGraphQL(ctx context.Context, req *graphql.Request, resp any) error {
+	client, apiKey := s.credentialFor(ctx)
+	return s.runGraphQLWithKey(ctx, client, apiKey, req, resp)
+}
+
+// credentialFor returns the client and key NerdGraph calls made for the
+// caller in ctx use: the named credential the request selected, the
+// caller's tenant, or NEW_RELIC_API_KEY.
+func (s *Server) credentialFor(ctx context.Context) (*graphql.Client, string) {
+	if credential := credentialFromContext(ctx); credential != nil {
+		return credential.Client, credential.APIKey.Get()
+	}
+	if caller := callerFromContext(ctx); caller != nil && caller.Tenant != nil {
+		return caller.Tenant.Client, caller.Tenant.APIKey.Get()
+	}
+	return s.client, s.apiKey.Get()
+}
+
+// runGraphQLWithKey is runGraphQL with an explicit client and key.
+func (s *Server) runGraphQLWithKey(ctx context.Context, client *graphql.Client, apiKey string, req *graphql.Request, resp any) error {
+	if s.schema != nil {
+		if err := s.schema.Validate(req.Query, req.Variables); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("API-Key", apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	traceHeaders(ctx, req.Header)
+	return client.Run(withOperation(ctx, req.Name), req, resp)
+}
+
+// createKeysMutation creates one ingest key per input.
+var createKeysMutation = batchMutation{Document: "createKeys", Field: "apiAccessCreateKeys"}
+
+// createIngestKey runs the apiAccessCreateKeys mutation for one ingest key.
+func (s *Server) createIngestKey(ctx context.Context, request InsertKeyRequest) (*NewRelicResponse, error) {
+	responses, errs := s.createIngestKeys(ctx, []InsertKeyRequest{request})
+	return responses[0], errs[0]
+}
+
+// createIngestKeys creates several ingest keys in as few requests as
+// batching allows, returning each one's response and error in order. Names
+// and notes left empty are filled in from the naming templates.
+func (s *Server) createIngestKeys(ctx context.Context, requests []InsertKeyRequest) ([]*NewRelicResponse, []error) {
+	requests = slices.Clone(requests)
+	for i := range requests {
+		if err := s.naming.apply(ctx, &requests[i]); err != nil {
+			errs := make([]error, len(requests))
+			for j := range errs {
+				errs[j] = err
+			}
+			return make([]*NewRelicResponse, len(requests)), errs
+		}
+	}
+	inputs := make([]any, len(requests))
+	for i, request := range requests {
+		inputs[i] = map[string]any{
+			"ingest": []map[string]any{{
+				"accountId":  request.AccountID,
+				"ingestType": request.IngestType,
+				"name":       request.Name,
+				"notes":      traceNotes(ctx, request.Notes),
+			}},
+		}
+	}
+	results, errs := s.runBatch(ctx, createKeysMutation, inputs)
+
+	responses := make([]*NewRelicResponse, len(requests))
+	for i, request := range requests {
+		if errs[i] != nil {
+			continue
+		}
+		var responseData NewRelicResponse
+		if err := json.Unmarshal(results[i], &responseData.APIAccessCreateKeys); err != nil {
+			errs[i] = fmt.Errorf("decoding apiAccessCreateKeys: %w", err)
+			continue
+		}
+		if len(responseData.APIAccessCreateKeys.CreatedKeys) > 0 {
+			s.search.Invalidate(request.AccountID)
+		}
+		for _, key := range responseData.APIAccessCreateKeys.CreatedKeys {
+			s.keys.Put(KeyRecord{
+				ID:        key.ID,
+				AccountID: request.AccountID,
+				Name:      key.Name,
+				Labels:    request.Labels,
+				CreatedBy: actorName(ctx),
+			})
+		}
+		responses[i] = &responseData
+	}
+	return responses, errs
+}
+
+// deleteIngestKeys runs the apiAccessDeleteKeys mutation.
+func (s *Server) deleteIngestKeys(ctx context.Context, ids []string) (*DeleteKeysResponse, error) {
+	req, err := newRequest("deleteIngestKeys", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("ids", ids)
+
+	var responseData DeleteKeysResponse
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	for _, key := range responseData.ApiAccessDeleteKeys.DeletedKeys {
+		s.keys.Remove(key.ID)
+		s.search.Remove(key.ID)
+	}
+	return &responseData, nil
+}
+
+// IngestKey is an existing ingest key as NerdGraph reports it. The key
+// value itself is never fetched.
+type IngestKey struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Notes      string `json:"notes"`
+	AccountID  int    `json:"accountId"`
+	IngestType string `json:"ingestType"`
+}
+
+// getIngestKey looks up an ingest key by ID.
+func (s *Server) getIngestKey(ctx context.Context, id string) (*IngestKey, error) {
+	req, err := newRequest("getIngestKey", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("id", id)
+
+	var responseData struct {
+		Actor struct {
+			APIAccess struct {
+				Key *IngestKey `json:"key"`
+			} `json:"apiAccess"`
+		} `json:"actor"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if responseData.Actor.APIAccess.Key == nil {
+		return nil, errKeyNotFound
+	}
+	return responseData.Actor.APIAccess.Key, nil
+}
+
+// searchIngestKeys lists every ingest key in the given accounts, following
+// keySearch's cursor until all pages are read.
+func (s *Server) searchIngestKeys(ctx context.Context, accountIDs []int) ([]IngestKey, error) {
+	var keys []IngestKey
+	var cursor *string
+	for {
+		req, err := newRequest("searchIngestKeys", keySearchDocument{})
+		if err != nil {
+			return nil, err
+		}
+		req.Var("accountIds", accountIDs)
+		req.Var("cursor", cursor)
+
+		var responseData struct {
+			Actor struct {
+				APIAccess struct {
+					KeySearch struct {
+						NextCursor *string     `json:"nextCursor"`
+						Keys       []IngestKey `json:"keys"`
+					} `json:"keySearch"`
+				} `json:"apiAccess"`
+			} `json:"actor"`
+		}
+		if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+			return nil, err
+		}
+		search := responseData.Actor.APIAccess.KeySearch
+		keys = append(keys, search.Keys...)
+		if search.NextCursor == nil || *search.NextCursor == "" {
+			return keys, nil
+		}
+		cursor = search.NextCursor
+	}
+}
+
+// updateIngestKeyNotes runs the apiAccessUpdateKeys mutation to change a key's
+// notes.
+func (s *Server) updateIngestKeyNotes(ctx context.Context, id, notes string) error {
+	req, err := newRequest("updateIngestKeyNotes", nil)
+	if err != nil {
+		return err
+	}
+	req.Var("keys", map[string]any{
+		"ingest": []map[string]any{{
+			"keyId": id,
+			"notes": notes,
+		}},
+	})
+
+	var responseData struct {
+		APIAccessUpdateKeys struct {
+			UpdatedKeys []struct {
+				ID string `json:"id"`
+			} `json:"updatedKeys"`
+			Errors []KeyError `json:"errors"`
+		} `json:"apiAccessUpdateKeys"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return err
+	}
+	if len(responseData.APIAccessUpdateKeys.Errors) > 0 {
+		return KeyErrors(responseData.APIAccessUpdateKeys.Errors)
+	}
+	return nil
+}
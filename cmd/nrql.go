@@ -0,0 +1,27 @@
+package main
+
+import "context"
+
+// runNRQL runs an NRQL query in an account and returns the result rows.
+func (s *Server) runNRQL(ctx context.Context, accountID int, query string) ([]map[string]any, error) {
+	req, err := newRequest("runNRQL", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("accountId", accountID)
+	req.Var("query", query)
+
+	var responseData struct {
+		Actor struct {
+			Account struct {
+				NRQL struct {
+					Results []map[string]any `json:"results"`
+				} `json:"nrql"`
+			} `json:"account"`
+		} `json:"actor"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	return responseData.Actor.Account.NRQL.Results, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+const (
+	defaultOfflineQueueSize = 1000
+	defaultOfflineMaxAge    = 24 * time.Hour
+	offlineRetryInterval    = 30 * time.Second
+	// upstreamFailureThreshold is how many NerdGraph calls in a row must
+	// fail before it is considered down.
+	upstreamFailureThreshold = 3
+	// maxOfflineAttempts bounds replays of a queued change; its deadline
+	// normally gives it up first.
+	maxOfflineAttempts = 10000
+)
+
+var errUpstreamUnavailable = errors.New("NerdGraph is unavailable")
+
+// availabilityTransport tracks whether NerdGraph is reachable through a
+// client, from the outcome of the calls made with it.
+type availabilityTransport struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	failures  int
+	downSince time.Time
+}
+
+// upstreamAvailability maps each client to its availabilityTransport.
+var upstreamAvailability sync.Map
+
+// upstreamDown reports whether NerdGraph has been failing for the client,
+// and since when.
+func upstreamDown(client *graphql.Client) (time.Time, bool) {
+	t, ok := upstreamAvailability.Load(client)
+	if !ok {
+		return time.Time{}, false
+	}
+	transport := t.(*availabilityTransport)
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	return transport.downSince, !transport.downSince.IsZero()
+}
+
+func (t *availabilityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() == nil:
+		t.record(false)
+	case err == nil && res.StatusCode >= http.StatusBadGateway && res.StatusCode <= http.StatusGatewayTimeout:
+		t.record(false)
+	case err == nil:
+		t.record(true)
+	}
+	return res, err
+}
+
+func (t *availabilityTransport) record(ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ok {
+		if !t.downSince.IsZero() {
+			log.Printf("NerdGraph is reachable again after %s", time.Since(t.downSince).Round(time.Second))
+		}
+		t.failures, t.downSince = 0, time.Time{}
+		return
+	}
+	t.failures++
+	if t.failures >= upstreamFailureThreshold && t.downSince.IsZero() {
+		t.downSince = time.Now()
+		log.Printf("NerdGraph failed %d times in a row, considering it down", t.failures)
+	}
+}
+
+// offlineQueue decides which changes are queued while NerdGraph is down.
+type offlineQueue struct {
+	size   int
+	maxAge time.Duration
+}
+
+// newOfflineQueueFromEnv returns nil unless OFFLINE_QUEUE is true.
+func newOfflineQueueFromEnv() (*offlineQueue, error) {
+	if os.Getenv("OFFLINE_QUEUE") != "true" {
+		return nil, nil
+	}
+	q := &offlineQueue{size: defaultOfflineQueueSize, maxAge: defaultOfflineMaxAge}
+	if v := os.Getenv("OFFLINE_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid OFFLINE_QUEUE_SIZE %q", v)
+		}
+		q.size = n
+	}
+	if v := os.Getenv("OFFLINE_QUEUE_MAX_AGE_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours <= 0 {
+			return nil, fmt.Errorf("invalid OFFLINE_QUEUE_MAX_AGE_HOURS %q", v)
+		}
+		q.maxAge = time.Duration(hours) * time.Hour
+	}
+	return q, nil
+}
+
+// credentialName is the named credential selected for a request, or "".
+func credentialName(ctx context.Context) string {
+	if credential := credentialFromContext(ctx); credential != nil {
+		return credential.Name
+	}
+	return ""
+}
+
+// offlinePending matches the offline jobs that haven't finished.
+func offlinePending(job *Job) bool {
+	return job.Kind == "offline" && (job.Status == jobQueued || job.Status == jobRunning)
+}
+
+// offlineMiddleware queues changes as "offline" jobs while NerdGraph is down
+// for the credential they'd use, answering 202 with the job. Once changes
+// are queued, later ones are queued behind them until the queue has
+// drained, so they reach NerdGraph in the order they came in.
+func (s *Server) offlineMiddleware(next http.Handler) http.Handler {
+	if s.offline == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || readPaths[r.URL.Path] || replaying(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, _ := s.credentialFor(r.Context())
+		_, down := upstreamDown(client)
+		credential := credentialName(r.Context())
+		queued := s.jobs.Count(func(job *Job) bool {
+			return offlinePending(job) && job.Credential == credential
+		})
+		if !down && queued == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if queued >= s.offline.size {
+			log.Printf("Offline queue is full, Status Code: %d", http.StatusServiceUnavailable)
+			http.Error(w, `{"error": "NerdGraph is unavailable and the offline queue is full"}`, http.StatusServiceUnavailable)
+			return
+		}
+
+		request, err := captureRequest(r, s.offline.maxAge)
+		if errors.Is(err, errBodyTooLarge) {
+			http.Error(w, `{"error": "NerdGraph is unavailable and the request is too large to queue"}`, http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
+			return
+		}
+		job, err := s.jobs.EnqueueAttempts(r.Context(), "offline", request, maxOfflineAttempts)
+		if err != nil {
+			log.Printf("Failed to queue job: %v, Status Code: %d", err, http.StatusInternalServerError)
+			http.Error(w, `{"error": "Failed to queue job"}`, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("NerdGraph unavailable, queued %s %s as job %s", r.Method, r.URL.Path, job.ID)
+		writeJobAccepted(w, job)
+	})
+}
+
+func (s *Server) registerOfflineJobs() {
+	s.jobs.Register("offline", func(ctx context.Context, job *Job) (any, error) {
+		var request DeferredRequest
+		if err := json.Unmarshal(job.Payload, &request); err != nil {
+			return nil, err
+		}
+		if time.Now().After(request.Deadline) {
+			return nil, errDeferredExpired
+		}
+		if job.Caller != "" && callerFromContext(ctx) == nil {
+			return nil, fmt.Errorf("caller %s is no longer configured: %w", job.Caller, errForbidden)
+		}
+		// Changes are replayed in the order they were queued.
+		earlier := s.jobs.Find(func(other *Job) bool {
+			return offlinePending(other) && other.ID != job.ID && other.Credential == job.Credential && other.CreatedAt.Before(job.CreatedAt)
+		})
+		if earlier != nil {
+			return nil, fmt.Errorf("waiting for job %s: %w", earlier.ID, errUpstreamUnavailable)
+		}
+		// Nothing else may be calling NerdGraph while changes are queued, so
+		// a cheap query checks whether it is back.
+		client, apiKey := s.credentialFor(ctx)
+		if since, down := upstreamDown(client); down {
+			if _, err := s.whoAmI(ctx, client, apiKey); err != nil {
+				return nil, fmt.Errorf("down since %s: %w", since.UTC().Format(time.RFC3339), errUpstreamUnavailable)
+			}
+		}
+
+		// A replay that fails while the transport is counting NerdGraph as
+		// down went nowhere, whatever status the handler picked, unless
+		// the handler says the change may have been made regardless.
+		response, err := s.replay(ctx, request)
+		if errors.Is(err, errMaybeApplied) {
+			return response, err
+		}
+		if response != nil && response.Status >= http.StatusInternalServerError {
+			_, down := upstreamDown(client)
+			if down || response.Status >= http.StatusBadGateway && response.Status <= http.StatusGatewayTimeout {
+				return response, fmt.Errorf("replay answered %d: %w", response.Status, errUpstreamUnavailable)
+			}
+		}
+		return response, err
+	})
+}
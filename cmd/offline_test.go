@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+	"github.com/gorilla/mux"
+)
+
+func TestOfflineReplayOfTimedOutCreate(t *testing.T) {
+	// NerdGraph takes the create but never answers in time.
+	var creates atomic.Int32
+	release := make(chan struct{})
+	nerdGraph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creates.Add(1)
+		<-release
+	}))
+	t.Cleanup(nerdGraph.Close)
+	t.Cleanup(func() { close(release) })
+	apiKey, _ := newSecretValue("NEW_RELIC_API_KEY", "NRAK-TESTTESTTEST", "")
+	client := graphql.NewClient(nerdGraph.URL, graphql.WithHTTPClient(&http.Client{
+		Timeout:   20 * time.Millisecond,
+		Transport: newRateLimitTransport(http.DefaultTransport),
+	}))
+	jobs, err := newJobQueue(t.TempDir()+"/jobs.json", &authenticator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{client: client, apiKey: apiKey, jobs: jobs, duplicates: duplicateAllow}
+	router := mux.NewRouter()
+	router.HandleFunc("/createKey", s.createApiKey).Methods("POST")
+	s.router = router
+	s.registerOfflineJobs()
+
+	body, _ := json.Marshal(InsertKeyRequest{AccountID: 1, Name: "ci", IngestType: "LICENSE"})
+	job, err := jobs.EnqueueAttempts(context.Background(), "offline", DeferredRequest{
+		Method:   http.MethodPost,
+		Path:     "/createKey",
+		Body:     body,
+		Deadline: time.Now().Add(time.Hour),
+	}, maxOfflineAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.RunDue(context.Background())
+
+	job = jobs.Get(job.ID)
+	if job.Status != jobFailed {
+		t.Errorf("job is %s after a create that may have been made, want failed: %s", job.Status, job.Error)
+	}
+	var response DeferredResponse
+	json.Unmarshal(job.Result, &response)
+	if response.Status != http.StatusBadGateway {
+		t.Errorf("replay answered %d, want 502", response.Status)
+	}
+	jobs.RunDue(context.Background())
+	if got := creates.Load(); got != 1 {
+		t.Errorf("NerdGraph got the create %d times, want once", got)
+	}
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSessionDuration = 8 * time.Hour
+	loginCookieDuration    = 10 * time.Minute
+	sessionCookie          = "proxy_session"
+	loginCookie            = "proxy_login"
+	// sessionTokenPrefix marks session tokens handed to device flow clients,
+	// so they can't be mistaken for caller tokens.
+	sessionTokenPrefix = "session."
+)
+
+var errSessionInvalid = errors.New("invalid or expired session")
+
+// oidcProvider signs humans in against the IdP at OIDC_ISSUER, with the
+// authorization code flow for browsers and the device flow for terminals.
+// Sessions are signed with OIDC_SESSION_SECRET rather than stored, so every
+// replica accepts them.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       string
+	claim        string
+	secret       []byte
+	duration     time.Duration
+	client       *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+}
+
+// oidcDiscovery is the part of the IdP's openid-configuration we use.
+type oidcDiscovery struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// oidcSession is the signed content of a session cookie or token.
+type oidcSession struct {
+	Caller  string    `json:"caller"`
+	Expires time.Time `json:"exp"`
+}
+
+// oidcLogin is the signed content of the cookie that carries a login from
+// /auth/login to /auth/callback.
+type oidcLogin struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Redirect string    `json:"redirect"`
+	Expires  time.Time `json:"exp"`
+}
+
+// newOIDCProviderFromEnv returns nil when OIDC_ISSUER is unset.
+func newOIDCProviderFromEnv() (*oidcProvider, error) {
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+	p := &oidcProvider{
+		issuer:       issuer,
+		clientID:     os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		scopes:       "openid email profile",
+		claim:        "email",
+		secret:       []byte(os.Getenv("OIDC_SESSION_SECRET")),
+		duration:     defaultSessionDuration,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+	if p.clientID == "" {
+		return nil, errors.New("OIDC_ISSUER needs OIDC_CLIENT_ID")
+	}
+	if len(p.secret) < 32 {
+		return nil, errors.New("OIDC_SESSION_SECRET must be at least 32 characters")
+	}
+	secrets.Add(p.clientSecret)
+	secrets.Add(string(p.secret))
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		p.scopes = v
+	}
+	if v := os.Getenv("OIDC_USER_CLAIM"); v != "" {
+		p.claim = v
+	}
+	if v := os.Getenv("OIDC_SESSION_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours <= 0 {
+			return nil, fmt.Errorf("invalid OIDC_SESSION_HOURS %q", v)
+		}
+		p.duration = time.Duration(hours) * time.Hour
+	}
+	return p, nil
+}
+
+// discover fetches the IdP's configuration the first time it is needed,
+// and again after a failure.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("IdP reports issuer %q, expected %q", discovery.Issuer, p.issuer)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// postForm sends a form to an IdP endpoint and decodes the JSON answer,
+// whatever its status; OAuth errors come back as JSON with 400.
+func (p *oidcProvider) postForm(ctx context.Context, endpoint string, form url.Values, v any) (int, error) {
+	form.Set("client_id", p.clientID)
+	if p.clientSecret != "" {
+		form.Set("client_secret", p.clientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("decoding answer from %s (%s): %w", endpoint, resp.Status, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// tokenResponse is the token endpoint's answer, successful or not.
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// publicKey returns the IdP's signing key with the given ID, fetching the
+// key set again when the ID is unknown, at most once a minute.
+func (p *oidcProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysAt) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys, p.keysAt = map[string]crypto.PublicKey{}, time.Now()
+	for _, jwk := range set.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			p.keys[jwk.Kid] = key
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// jsonWebKey is an RSA or P-256 key from a JWKS document.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b), err
+	}
+	switch {
+	case k.Kty == "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and, when given, nonce, and returns the identity in OIDC_USER_CLAIM.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, token, nonce string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("malformed ID token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ID token signature: %w", err)
+	}
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return "", errors.New("invalid ID token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return "", errors.New("invalid ID token signature")
+		}
+	default:
+		return "", errors.New("invalid ID token signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return "", fmt.Errorf("ID token issued by %q", iss)
+	}
+	if !audienceIncludes(claims["aud"], p.clientID) {
+		return "", errors.New("ID token is for another client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().After(time.Unix(int64(exp), 0)) {
+		return "", errors.New("ID token has expired")
+	}
+	if got, _ := claims["nonce"].(string); nonce != "" && got != nonce {
+		return "", errors.New("ID token nonce does not match")
+	}
+	if verified, ok := claims["email_verified"].(bool); p.claim == "email" && ok && !verified {
+		return "", errors.New("email address is not verified")
+	}
+	user, _ := claims[p.claim].(string)
+	if user == "" {
+		return "", fmt.Errorf("ID token has no %s claim", p.claim)
+	}
+	return user, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func audienceIncludes(aud any, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []any:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sign encodes v and appends an HMAC of it, keyed with OIDC_SESSION_SECRET.
+func (p *oidcProvider) sign(v any) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// open checks a value made by sign and decodes it into v.
+func (p *oidcProvider) open(value string, v any) error {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return errSessionInvalid
+	}
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return errSessionInvalid
+	}
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errSessionInvalid
+	}
+	if err := decodeSegment(payload, v); err != nil {
+		return errSessionInvalid
+	}
+	return nil
+}
+
+// sessionCaller returns the name of the caller signed in with the session
+// token or cookie of a request, or "" when there is none.
+func (p *oidcProvider) sessionCaller(r *http.Request) string {
+	value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "+sessionTokenPrefix)
+	if !ok {
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil {
+			return ""
+		}
+		value = cookie.Value
+	}
+	var session oidcSession
+	if p.open(value, &session) != nil || time.Now().After(session.Expires) {
+		return ""
+	}
+	return session.Caller
+}
+
+func (p *oidcProvider) setCookie(w http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(p.redirectURL, "https://"),
+		// Lax, not Strict: the IdP sends the browser back to /auth/callback
+		// from another site, and Strict cookies would be left off that
+		// request and the redirect after it. Lax still leaves them off
+		// requests other sites send with POST, PUT, PATCH or DELETE, which is
+		// how every change is made.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Handler serves the /auth routes, which need no token.
+func (p *oidcProvider) Handler(auth *authenticator) http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/auth/login", p.login).Methods("GET")
+	r.HandleFunc("/auth/callback", func(w http.ResponseWriter, r *http.Request) { p.callback(w, r, auth) }).Methods("GET")
+	r.HandleFunc("/auth/logout", p.logout).Methods("POST")
+	r.HandleFunc("/auth/device", p.startDevice).Methods("POST")
+	r.HandleFunc("/auth/device/token", func(w http.ResponseWriter, r *http.Request) { p.pollDevice(w, r, auth) }).Methods("POST")
+	return r
+}
+
+// Send the browser to the IdP to sign in
+func (p *oidcProvider) login(w http.ResponseWriter, r *http.Request) {
+	if p.redirectURL == "" {
+		http.Error(w, `{"error": "Browser login needs OIDC_REDIRECT_URL"}`, http.StatusNotFound)
+		return
+	}
+	discovery, err := p.discover(r.Context())
+	if err != nil {
+		log.Printf("Failed to discover OIDC configuration: %v, Status Code: %d", err, http.StatusBadGateway)
+		http.Error(w, `{"error": "Identity provider is unavailable"}`, http.StatusBadGateway)
+		return
+	}
+	login := oidcLogin{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		Redirect: localRedirect(r.URL.Query().Get("redirect")),
+		Expires:  time.Now().Add(loginCookieDuration),
+	}
+	p.setCookie(w, loginCookie, p.sign(login), login.Expires)
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {p.scopes},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, discovery.AuthorizationEndpoint+"?"+query.Encode(), http.StatusFound)
+}
+
+// Finish a browser sign-in: exchange the code and start a session
+func (p *oidcProvider) callback(w http.ResponseWriter, r *http.Request, auth *authenticator) {
+	var login oidcLogin
+	cookie, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = p.open(cookie.Value, &login)
+	}
+	if err != nil || time.Now().After(login.Expires) || r.URL.Query().Get("state") != login.State {
+		log.Printf("Rejected OIDC callback without a matching login, Status Code: %d", http.StatusBadRequest)
+		http.Error(w, `{"error": "Login expired or was not started here"}`, http.StatusBadRequest)
+		return
+	}
+	p.setCookie(w, loginCookie, "", time.Unix(0, 0))
+	if e := r.URL.Query().Get("error"); e != "" {
+		log.Printf("IdP refused login: %s, Status Code: %d", e, http.StatusUnauthorized)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Login refused: "+e), http.StatusUnauthorized)
+		return
+	}
+
+	discovery, err := p.discover(r.Context())
+	if err != nil {
+		log.Printf("Failed to discover OIDC configuration: %v, Status Code: %d", err, http.StatusBadGateway)
+		http.Error(w, `{"error": "Identity provider is unavailable"}`, http.StatusBadGateway)
+		return
+	}
+	var token tokenResponse
+	_, err = p.postForm(r.Context(), discovery.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {r.URL.Query().Get("code")},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {login.Verifier},
+	}, &token)
+	if err == nil && token.IDToken == "" {
+		err = fmt.Errorf("token endpoint answered %s: %s", token.Error, token.ErrorDescription)
+	}
+	if err != nil {
+		log.Printf("Failed to exchange OIDC code: %v, Status Code: %d", err, http.StatusBadGateway)
+		http.Error(w, `{"error": "Failed to complete login"}`, http.StatusBadGateway)
+		return
+	}
+	caller, ok := p.signIn(w, r, auth, token.IDToken, login.Nonce)
+	if !ok {
+		return
+	}
+	p.setCookie(w, sessionCookie, p.sign(oidcSession{Caller: caller.Name, Expires: time.Now().Add(p.duration)}), time.Now().Add(p.duration))
+	http.Redirect(w, r, login.Redirect, http.StatusFound)
+}
+
+// signIn verifies an ID token and finds the caller it signs in as. It
+// returns false when the request has been answered.
+func (p *oidcProvider) signIn(w http.ResponseWriter, r *http.Request, auth *authenticator, idToken, nonce string) (*Caller, bool) {
+	user, err := p.verifyIDToken(r.Context(), idToken, nonce)
+	if err != nil {
+		log.Printf("Rejected ID token: %v, Status Code: %d", err, http.StatusUnauthorized)
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+	caller := auth.userByName(user)
+	if caller == nil {
+		log.Printf("Rejected login of unknown user %s, Status Code: %d", user, http.StatusForbidden)
+		http.Error(w, `{"error": "User is not configured as a caller"}`, http.StatusForbidden)
+		return nil, false
+	}
+	log.Printf("User %s signed in", user)
+	return caller, true
+}
+
+// End the browser session
+func (p *oidcProvider) logout(w http.ResponseWriter, r *http.Request) {
+	p.setCookie(w, sessionCookie, "", time.Unix(0, 0))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Start a device flow sign-in for a terminal
+func (p *oidcProvider) startDevice(w http.ResponseWriter, r *http.Request) {
+	discovery, err := p.discover(r.Context())
+	if err == nil && discovery.DeviceAuthorizationEndpoint == "" {
+		err = errors.New("IdP does not support the device flow")
+	}
+	if err != nil {
+		log.Printf("Failed to start device flow: %v, Status Code: %d", err, http.StatusBadGateway)
+		http.Error(w, `{"error": "Identity provider is unavailable"}`, http.StatusBadGateway)
+		return
+	}
+	var device struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+		Error                   string `json:"error"`
+	}
+	if _, err := p.postForm(r.Context(), discovery.DeviceAuthorizationEndpoint, url.Values{"scope": {p.scopes}}, &device); err != nil || device.DeviceCode == "" {
+		log.Printf("Failed to start device flow: %v %s, Status Code: %d", err, device.Error, http.StatusBadGateway)
+		http.Error(w, `{"error": "Failed to start device login"}`, http.StatusBadGateway)
+		return
+	}
+	if device.Interval == 0 {
+		device.Interval = 5
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"deviceCode":              device.DeviceCode,
+		"userCode":                device.UserCode,
+		"verificationUri":         device.VerificationURI,
+		"verificationUriComplete": device.VerificationURIComplete,
+		"expiresIn":               device.ExpiresIn,
+		"interval":                device.Interval,
+	})
+}
+
+// Poll a device flow sign-in; once the user has approved it, answer with a
+// session token to send as a bearer token
+func (p *oidcProvider) pollDevice(w http.ResponseWriter, r *http.Request, auth *authenticator) {
+	var request struct {
+		DeviceCode string `json:"deviceCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.DeviceCode == "" {
+		http.Error(w, `{"error": "Invalid request: deviceCode is required"}`, http.StatusBadRequest)
+		return
+	}
+	discovery, err := p.discover(r.Context())
+	if err != nil {
+		log.Printf("Failed to discover OIDC configuration: %v, Status Code: %d", err, http.StatusBadGateway)
+		http.Error(w, `{"error": "Identity provider is unavailable"}`, http.StatusBadGateway)
+		return
+	}
+	var token tokenResponse
+	if _, err := p.postForm(r.Context(), discovery.TokenEndpoint, url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {request.DeviceCode},
+	}, &token); err != nil {
+		log.Printf("Failed to poll device flow: %v, Status Code: %d", err, http.StatusBadGateway)
+		http.Error(w, `{"error": "Failed to complete device login"}`, http.StatusBadGateway)
+		return
+	}
+	switch token.Error {
+	case "":
+	case "authorization_pending", "slow_down":
+		// Passed on as RFC 8628 does, so clients poll again.
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": token.Error})
+		return
+	default:
+		log.Printf("Device login failed: %s, Status Code: %d", token.Error, http.StatusUnauthorized)
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": token.Error, "details": token.ErrorDescription})
+		return
+	}
+	caller, ok := p.signIn(w, r, auth, token.IDToken, "")
+	if !ok {
+		return
+	}
+	expires := time.Now().Add(p.duration)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"token":     sessionTokenPrefix + p.sign(oidcSession{Caller: caller.Name, Expires: expires}),
+		"expiresAt": expires.UTC(),
+	})
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localRedirect only allows redirects within the proxy after login.
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, `\`) {
+		return "/"
+	}
+	return target
+}
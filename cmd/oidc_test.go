@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdP is an OIDC provider that signs ID tokens with an RSA key and
+// hands out idToken from its token endpoint.
+type testIdP struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kid: "test",
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(tokenResponse{IDToken: idp.idToken})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// sign makes an RS256 ID token with the given claims, signed with the key
+// kid names.
+func (idp *testIdP) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// claims are valid ID token claims for alice, with changes applied.
+func (idp *testIdP) claims(changes map[string]any) map[string]any {
+	claims := map[string]any{
+		"iss":   idp.URL,
+		"aud":   "proxy",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "nonce-1",
+		"email": "alice@example.com",
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+func (idp *testIdP) provider() *oidcProvider {
+	return &oidcProvider{
+		issuer:      idp.URL,
+		clientID:    "proxy",
+		redirectURL: "https://proxy.example.com/auth/callback",
+		scopes:      "openid email",
+		claim:       "email",
+		secret:      []byte("0123456789abcdef0123456789abcdef"),
+		duration:    time.Hour,
+		client:      idp.Client(),
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider()
+	valid := idp.sign(t, "test", idp.claims(nil))
+	parts := strings.Split(valid, ".")
+	forged, _ := json.Marshal(idp.claims(map[string]any{"email": "mallory@example.com"}))
+	for _, test := range []struct {
+		name    string
+		kid     string
+		changes map[string]any
+		token   string
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "audience list", changes: map[string]any{"aud": []string{"other", "proxy"}}},
+		{name: "other issuer", changes: map[string]any{"iss": "https://evil.example.com"}, wantErr: "ID token issued by"},
+		{name: "other client", changes: map[string]any{"aud": "other"}, wantErr: "ID token is for another client"},
+		{name: "expired", changes: map[string]any{"exp": time.Now().Add(-time.Minute).Unix()}, wantErr: "ID token has expired"},
+		{name: "other nonce", changes: map[string]any{"nonce": "nonce-2"}, wantErr: "ID token nonce does not match"},
+		{name: "unverified email", changes: map[string]any{"email_verified": false}, wantErr: "email address is not verified"},
+		{name: "no email", changes: map[string]any{"email": nil}, wantErr: "ID token has no email claim"},
+		{name: "unknown key", kid: "rotated", wantErr: `unknown signing key "rotated"`},
+		{name: "forged claims", token: parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2], wantErr: "invalid ID token signature"},
+		{name: "malformed", token: "not-a-token", wantErr: "malformed ID token"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			token := test.token
+			if token == "" {
+				kid := test.kid
+				if kid == "" {
+					kid = "test"
+				}
+				token = idp.sign(t, kid, idp.claims(test.changes))
+			}
+			user, err := p.verifyIDToken(context.Background(), token, "nonce-1")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("err = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil || user != "alice@example.com" {
+				t.Errorf("verifyIDToken = %q, %v; want alice@example.com", user, err)
+			}
+		})
+	}
+}
+
+func TestBrowserLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider()
+	alice := &Caller{Name: "alice@example.com"}
+	auth := &authenticator{users: map[string]*Caller{alice.Name: alice}}
+	handler := p.Handler(auth)
+
+	// /auth/login sets the login cookie and sends the browser to the IdP.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/login?redirect=/ui/keys", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login answered %d", w.Code)
+	}
+	loginCookies := w.Result().Cookies()
+	if len(loginCookies) != 1 || loginCookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("login cookies = %+v, want one Lax cookie", loginCookies)
+	}
+	authorize, _ := url.Parse(w.Header().Get("Location"))
+	state := authorize.Query().Get("state")
+	idp.idToken = idp.sign(t, "test", idp.claims(map[string]any{"nonce": authorize.Query().Get("nonce")}))
+
+	// The IdP sends the browser back, and a browser sends a Lax cookie
+	// along with that cross-site navigation.
+	for _, test := range []struct {
+		name   string
+		cookie bool
+		state  string
+		want   int
+	}{
+		{"without the login cookie", false, state, http.StatusBadRequest},
+		{"with another state", true, "forged", http.StatusBadRequest},
+		{"signed in", true, state, http.StatusFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code-1&state="+test.state, nil)
+			if test.cookie {
+				r.AddCookie(loginCookies[0])
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.want {
+				t.Fatalf("callback answered %d, want %d: %s", w.Code, test.want, w.Body)
+			}
+			if w.Code != http.StatusFound {
+				return
+			}
+			if got := w.Header().Get("Location"); got != "/ui/keys" {
+				t.Errorf("redirected to %q, want /ui/keys", got)
+			}
+			var session *http.Cookie
+			for _, cookie := range w.Result().Cookies() {
+				if cookie.Name == sessionCookie {
+					session = cookie
+				}
+			}
+			if session == nil || session.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("session cookie = %+v, want a Lax cookie", session)
+			}
+			r = httptest.NewRequest(http.MethodGet, "/ui/keys", nil)
+			r.AddCookie(session)
+			if got := p.sessionCaller(r); got != alice.Name {
+				t.Errorf("session signs in as %q, want %s", got, alice.Name)
+			}
+		})
+	}
+}
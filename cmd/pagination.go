@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageCursor is where a page ended: the sort key of its last item, and a
+// fingerprint of the filter it was listed with so that a cursor can't be
+// replayed against a different query. Callers get it base64 encoded and
+// treat it as opaque.
+type pageCursor struct {
+	After  []string `json:"a"`
+	Filter string   `json:"f"`
+}
+
+// pageRequest is the limit and cursor of a list request.
+type pageRequest struct {
+	limit  int
+	after  []string
+	filter string
+}
+
+// parsePage reads limit and cursor from the query string. The other
+// parameters, apart from those named in unfiltered, are the filter the
+// cursor is tied to.
+func parsePage(query url.Values, unfiltered ...string) (pageRequest, error) {
+	filter := url.Values{}
+	for name, values := range query {
+		if name != "limit" && name != "cursor" && !slices.Contains(unfiltered, name) {
+			filter[name] = values
+		}
+	}
+	sum := sha256.Sum256([]byte(filter.Encode()))
+	page := pageRequest{limit: defaultPageSize, filter: hex.EncodeToString(sum[:8])}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return page, fmt.Errorf("invalid limit: must be between 1 and %d", maxPageSize)
+		}
+		page.limit = limit
+	}
+	if v := query.Get("cursor"); v != "" {
+		var cursor pageCursor
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || json.Unmarshal(raw, &cursor) != nil || len(cursor.After) == 0 {
+			return page, fmt.Errorf("invalid cursor")
+		}
+		if cursor.Filter != page.filter {
+			return page, fmt.Errorf("invalid cursor: it belongs to a different query")
+		}
+		page.after = cursor.After
+	}
+	return page, nil
+}
+
+// cursor encodes the cursor of a page ending at the item with key.
+func (page pageRequest) cursor(key []string) string {
+	raw, _ := json.Marshal(pageCursor{After: key, Filter: page.filter})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// paginate returns the page of items after the cursor, and the cursor of the
+// next page, or "" on the last page. Items have to be sorted by key in the
+// order compare defines. The cursor holds the last key rather than an
+// offset, so items added or removed before it don't shift the next page.
+func paginate[T any](items []T, page pageRequest, key func(T) []string, compare func(a, b []string) int) ([]T, string) {
+	if page.after != nil {
+		items = items[sort.Search(len(items), func(i int) bool { return compare(key(items[i]), page.after) > 0 }):]
+	}
+	if len(items) <= page.limit {
+		return items, ""
+	}
+	return items[:page.limit], page.cursor(key(items[page.limit-1]))
+}
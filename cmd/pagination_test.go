@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func pageKey(id string) []string { return []string{id} }
+
+// listPages pages through ids the way a client would, following
+// nextCursor, and returns every page.
+func listPages(t *testing.T, ids []string, query url.Values, compare func(a, b []string) int) [][]string {
+	t.Helper()
+	var pages [][]string
+	for {
+		page, err := parsePage(query)
+		if err != nil {
+			t.Fatalf("parsePage(%v): %v", query, err)
+		}
+		items, next := paginate(ids, page, pageKey, compare)
+		pages = append(pages, items)
+		if next == "" {
+			return pages
+		}
+		if len(pages) > len(ids) {
+			t.Fatalf("paging never ended: %v", pages)
+		}
+		query.Set("cursor", next)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	descending := func(a, b []string) int { return slices.Compare(b, a) }
+	for _, test := range []struct {
+		name    string
+		ids     []string
+		limit   string
+		compare func(a, b []string) int
+		want    string
+	}{
+		{"one page", []string{"a", "b"}, "5", slices.Compare[[]string], "a,b"},
+		{"round trip", []string{"a", "b", "c", "d", "e"}, "2", slices.Compare[[]string], "a,b|c,d|e"},
+		{"full last page", []string{"a", "b", "c", "d"}, "2", slices.Compare[[]string], "a,b|c,d"},
+		{"descending", []string{"e", "d", "c", "b", "a"}, "2", descending, "e,d|c,b|a"},
+		{"empty", nil, "", slices.Compare[[]string], ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{"account": {"1"}}
+			if test.limit != "" {
+				query.Set("limit", test.limit)
+			}
+			var pages []string
+			for _, items := range listPages(t, test.ids, query, test.compare) {
+				pages = append(pages, strings.Join(items, ","))
+			}
+			if got := strings.Join(pages, "|"); got != test.want {
+				t.Errorf("pages = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPaginateAfterDeletedItem(t *testing.T) {
+	page, _ := parsePage(url.Values{"limit": {"2"}})
+	_, next := paginate([]string{"a", "b", "c", "d"}, page, pageKey, slices.Compare[[]string])
+
+	// b, where the first page ended, is deleted before the next is fetched.
+	page, err := parsePage(url.Values{"limit": {"2"}, "cursor": {next}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, next := paginate([]string{"a", "c", "d"}, page, pageKey, slices.Compare[[]string])
+	if !slices.Equal(items, []string{"c", "d"}) || next != "" {
+		t.Errorf("page = %v, %q, want [c d] as the last page", items, next)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	first, _ := parsePage(url.Values{"account": {"1"}, "limit": {"1"}})
+	_, cursor := paginate([]string{"a", "b"}, first, pageKey, slices.Compare[[]string])
+
+	for _, test := range []struct {
+		name    string
+		query   url.Values
+		wantErr string
+	}{
+		{"defaults", url.Values{}, ""},
+		{"same filter", url.Values{"account": {"1"}, "cursor": {cursor}}, ""},
+		{"unfiltered parameter", url.Values{"account": {"1"}, "refresh": {"true"}, "cursor": {cursor}}, ""},
+		{"different filter", url.Values{"account": {"2"}, "cursor": {cursor}}, "invalid cursor: it belongs to a different query"},
+		{"garbled cursor", url.Values{"cursor": {"not a cursor"}}, "invalid cursor"},
+		{"empty cursor key", url.Values{"cursor": {"eyJhIjpbXSwiZiI6IiJ9"}}, "invalid cursor"},
+		{"zero limit", url.Values{"limit": {"0"}}, "invalid limit: must be between 1 and 1000"},
+		{"limit too large", url.Values{"limit": {"1001"}}, "invalid limit: must be between 1 and 1000"},
+		{"limit not a number", url.Values{"limit": {"ten"}}, "invalid limit: must be between 1 and 1000"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := parsePage(test.query, "refresh")
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("err = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.query.Has("cursor") && !slices.Equal(page.after, []string{"a"}) {
+				t.Errorf("after = %v, want [a]", page.after)
+			}
+		})
+	}
+	if page, _ := parsePage(url.Values{}); page.limit != defaultPageSize {
+		t.Errorf("default limit = %d, want %d", page.limit, defaultPageSize)
+	}
+}
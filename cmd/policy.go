@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Policy maps callers to roles, and roles to the routes they may use. It is
+// read from POLICY_FILE. A caller with no role may do nothing.
+type Policy struct {
+	Roles    map[string]Role     `json:"roles"`
+	Bindings map[string][]string `json:"bindings"`
+}
+
+// Role is a set of permissions. Accounts, when listed, bound the accounts
+// the role may act on, on top of the caller's own accounts.
+type Role struct {
+	Accounts []int        `json:"accounts,omitempty"`
+	Rules    []PolicyRule `json:"rules"`
+}
+
+// PolicyRule allows some actions on some routes. Actions are "read" (GET
+// and HEAD, except on the license keys route), "write" (every other
+// method), an HTTP method or "*". Routes are route templates such as
+// "/keys/{id}/rotate"; a trailing "*" matches any route with that prefix.
+type PolicyRule struct {
+	Actions []string `json:"actions"`
+	Routes  []string `json:"routes"`
+}
+
+// validate lists what is wrong with a policy.
+func (p *Policy) validate() []string {
+	var problems []string
+	for name, role := range p.Roles {
+		if len(role.Rules) == 0 {
+			problems = append(problems, fmt.Sprintf("role %s has no rules", name))
+		}
+		for i, rule := range role.Rules {
+			if len(rule.Actions) == 0 || len(rule.Routes) == 0 {
+				problems = append(problems, fmt.Sprintf("role %s rule %d needs actions and routes", name, i))
+			}
+		}
+	}
+	for caller, roles := range p.Bindings {
+		for _, role := range roles {
+			if _, ok := p.Roles[role]; !ok {
+				problems = append(problems, fmt.Sprintf("caller %s is bound to unknown role %s", caller, role))
+			}
+		}
+	}
+	slices.Sort(problems)
+	return problems
+}
+
+func (r *PolicyRule) matches(method, route string) bool {
+	action := slices.ContainsFunc(r.Actions, func(action string) bool {
+		switch action {
+		case "*":
+			return true
+		case "read":
+			return (method == http.MethodGet || method == http.MethodHead) && route != licenseKeysRoute
+		case "write":
+			return method != http.MethodGet && method != http.MethodHead
+		}
+		return strings.EqualFold(action, method)
+	})
+	return action && slices.ContainsFunc(r.Routes, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(route, prefix)
+		}
+		return pattern == route
+	})
+}
+
+// authorize reports whether the caller may use the route, and the accounts
+// it may then act on. A nil list means the roles don't bound the accounts.
+func (p *Policy) authorize(caller, method, route string) (bool, []int) {
+	allowed, unbounded := false, false
+	var accounts []int
+	for _, name := range p.Bindings[caller] {
+		role := p.Roles[name]
+		if !slices.ContainsFunc(role.Rules, func(rule PolicyRule) bool { return rule.matches(method, route) }) {
+			continue
+		}
+		allowed = true
+		if len(role.Accounts) == 0 {
+			unbounded = true
+		}
+		accounts = append(accounts, role.Accounts...)
+	}
+	if !allowed || unbounded {
+		return allowed, nil
+	}
+	return true, accounts
+}
+
+// accounts returns the accounts the caller's roles may act on on any route,
+// or nil when one of them is not bounded.
+func (p *Policy) accounts(caller string) []int {
+	var accounts []int
+	for _, name := range p.Bindings[caller] {
+		role := p.Roles[name]
+		if len(role.Accounts) == 0 {
+			return nil
+		}
+		accounts = append(accounts, role.Accounts...)
+	}
+	return accounts
+}
+
+// narrowCaller returns a copy of the caller limited to accounts, or nil
+// when the caller may access none of them.
+func narrowCaller(caller *Caller, accounts []int) *Caller {
+	if accounts == nil {
+		return caller
+	}
+	narrowed := *caller
+	narrowed.Accounts = nil
+	for _, accountID := range accounts {
+		if caller.CanAccess(accountID) && !slices.Contains(narrowed.Accounts, accountID) {
+			narrowed.Accounts = append(narrowed.Accounts, accountID)
+		}
+	}
+	if len(narrowed.Accounts) == 0 {
+		return nil
+	}
+	return &narrowed
+}
+
+// policyFile is the POLICY_FILE, re-read when it changes. A policy that
+// fails to load or validate is logged and the previous one kept.
+type policyFile struct {
+	path string
+
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+}
+
+// newPolicyFileFromEnv returns nil when POLICY_FILE is unset.
+func newPolicyFileFromEnv() (*policyFile, error) {
+	path := os.Getenv("POLICY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	p := &policyFile{path: path}
+	if _, err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Get returns the current policy.
+func (p *policyFile) Get() *Policy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.policy
+}
+
+// reload reads the file if it changed since the last read, and reports
+// whether it did.
+func (p *policyFile) reload() (bool, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false, err
+	}
+	p.mu.RLock()
+	unchanged := info.ModTime().Equal(p.modTime)
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return false, err
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return false, fmt.Errorf("parsing %s: %w", p.path, err)
+	}
+	if problems := policy.validate(); len(problems) > 0 {
+		return false, fmt.Errorf("invalid policy in %s: %v", p.path, problems)
+	}
+	p.mu.Lock()
+	p.policy, p.modTime = policy, info.ModTime()
+	p.mu.Unlock()
+	return true, nil
+}
+
+// watch re-reads the policy every interval until ctx is done. Every replica
+// runs it.
+func (p *policyFile) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := p.reload()
+		if err != nil {
+			log.Printf("Failed to reload policy, keeping the previous one: %v", err)
+			continue
+		}
+		if changed {
+			log.Printf("Reloaded policy from %s", p.path)
+		}
+	}
+}
+
+// Middleware checks the caller's roles allow the matched route, and limits
+// the caller to the accounts of the roles that do.
+func (p *policyFile) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := callerFromContext(r.Context())
+		route := mux.CurrentRoute(r)
+		if caller == nil || route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, accounts := p.Get().authorize(caller.Name, r.Method, template)
+		if allowed {
+			caller = narrowCaller(caller, accounts)
+		}
+		if !allowed || caller == nil {
+			log.Printf("Policy denies caller %s %s %s, Status Code: %d", callerFromContext(r.Context()).Name, r.Method, template, http.StatusForbidden)
+			http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+var testPolicy = &Policy{
+	Roles: map[string]Role{
+		"reader":    {Rules: []PolicyRule{{Actions: []string{"read"}, Routes: []string{"*"}}}},
+		"rotator":   {Accounts: []int{1, 2}, Rules: []PolicyRule{{Actions: []string{"POST"}, Routes: []string{"/keys/{id}/rotate"}}}},
+		"deleter":   {Accounts: []int{3}, Rules: []PolicyRule{{Actions: []string{"write"}, Routes: []string{"/keys/*"}}}},
+		"anything":  {Rules: []PolicyRule{{Actions: []string{"*"}, Routes: []string{"*"}}}},
+		"licensing": {Accounts: []int{2}, Rules: []PolicyRule{{Actions: []string{"GET"}, Routes: []string{licenseKeysRoute}}}},
+	},
+	Bindings: map[string][]string{
+		"viewer":   {"reader"},
+		"operator": {"reader", "rotator", "deleter"},
+		"admin":    {"rotator", "anything"},
+		"finance":  {"licensing"},
+	},
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	for _, test := range []struct {
+		caller, method, route string
+		allowed               bool
+		accounts              []int
+	}{
+		{"viewer", http.MethodGet, "/keys/{id}", true, nil},
+		{"viewer", http.MethodHead, "/keys", true, nil},
+		{"viewer", http.MethodPost, "/keys/{id}/rotate", false, nil},
+		{"viewer", http.MethodGet, licenseKeysRoute, false, nil},
+		{"operator", http.MethodPost, "/keys/{id}/rotate", true, []int{1, 2, 3}},
+		{"operator", http.MethodDelete, "/keys/{id}", true, []int{3}},
+		{"operator", http.MethodDelete, "/approvals/{id}", false, nil},
+		{"admin", http.MethodPost, "/keys/{id}/rotate", true, nil},
+		{"finance", http.MethodGet, licenseKeysRoute, true, []int{2}},
+		{"finance", http.MethodPost, licenseKeysRoute, false, nil},
+		{"stranger", http.MethodGet, "/keys", false, nil},
+	} {
+		t.Run(test.caller+" "+test.method+" "+test.route, func(t *testing.T) {
+			allowed, accounts := testPolicy.authorize(test.caller, test.method, test.route)
+			if allowed != test.allowed || !slices.Equal(accounts, test.accounts) {
+				t.Errorf("authorize = %v, %v, want %v, %v", allowed, accounts, test.allowed, test.accounts)
+			}
+		})
+	}
+}
+
+func TestPolicyAccounts(t *testing.T) {
+	for _, test := range []struct {
+		caller string
+		want   []int
+	}{
+		{"viewer", nil},
+		{"operator", nil},
+		{"finance", []int{2}},
+		{"stranger", nil},
+	} {
+		if got := testPolicy.accounts(test.caller); !slices.Equal(got, test.want) {
+			t.Errorf("accounts(%s) = %v, want %v", test.caller, got, test.want)
+		}
+	}
+}
+
+func TestNarrowCaller(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		caller   *Caller
+		accounts []int
+		want     []int
+		denied   bool
+	}{
+		{"unbounded roles", &Caller{Accounts: []int{1}}, nil, []int{1}, false},
+		{"caller with every account", &Caller{}, []int{1, 2}, []int{1, 2}, false},
+		{"overlap", &Caller{Accounts: []int{2, 3}}, []int{1, 2}, []int{2}, false},
+		{"duplicates", &Caller{}, []int{2, 2, 1}, []int{2, 1}, false},
+		{"no overlap", &Caller{Accounts: []int{3}}, []int{1, 2}, nil, true},
+		{"empty role accounts", &Caller{}, []int{}, nil, true},
+		{"tenant", &Caller{Tenant: &Tenant{Accounts: []int{1}}}, []int{1, 2}, []int{1}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			original := slices.Clone(test.caller.Accounts)
+			narrowed := narrowCaller(test.caller, test.accounts)
+			if test.denied {
+				if narrowed != nil {
+					t.Errorf("narrowCaller = %+v, want nil", narrowed)
+				}
+				return
+			}
+			if narrowed == nil || !slices.Equal(narrowed.Accounts, test.want) {
+				t.Errorf("narrowCaller = %+v, want accounts %v", narrowed, test.want)
+			}
+			if !slices.Equal(test.caller.Accounts, original) {
+				t.Errorf("narrowCaller changed the caller's accounts to %v", test.caller.Accounts)
+			}
+		})
+	}
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{
+		"roles": {"rotator": {"accounts": [1], "rules": [{"actions": ["POST"], "routes": ["/keys/{id}/rotate"]}]}},
+		"bindings": {"alice": ["rotator"]}
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy := &policyFile{path: path}
+	if _, err := policy.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen *Caller
+	router := mux.NewRouter()
+	router.HandleFunc("/keys/{id}/rotate", func(w http.ResponseWriter, r *http.Request) {
+		seen = callerFromContext(r.Context())
+	}).Methods("POST")
+	router.HandleFunc("/keys/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods("DELETE")
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller := &Caller{Name: r.Header.Get("X-Caller"), Accounts: []int{1, 2}}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+		})
+	})
+	router.Use(policy.Middleware)
+
+	for _, test := range []struct {
+		caller, method, path string
+		want                 int
+	}{
+		{"alice", http.MethodPost, "/keys/key-1/rotate", http.StatusOK},
+		{"alice", http.MethodDelete, "/keys/key-1", http.StatusForbidden},
+		{"bob", http.MethodPost, "/keys/key-1/rotate", http.StatusForbidden},
+	} {
+		seen = nil
+		request := httptest.NewRequest(test.method, test.path, nil)
+		request.Header.Set("X-Caller", test.caller)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Errorf("%s %s %s answered %d, want %d", test.caller, test.method, test.path, recorder.Code, test.want)
+		}
+		if test.want == http.StatusOK && (seen == nil || !slices.Equal(seen.Accounts, []int{1})) {
+			t.Errorf("handler saw caller %+v, want one limited to account 1", seen)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		policy *Policy
+		want   []string
+	}{
+		{"valid", testPolicy, nil},
+		{"role without rules", &Policy{Roles: map[string]Role{"empty": {}}}, []string{"role empty has no rules"}},
+		{"rule without routes", &Policy{Roles: map[string]Role{"r": {Rules: []PolicyRule{{Actions: []string{"read"}}}}}}, []string{"role r rule 0 needs actions and routes"}},
+		{"unknown role", &Policy{Bindings: map[string][]string{"alice": {"ghost"}}}, []string{"caller alice is bound to unknown role ghost"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.validate(); !slices.Equal(got, test.want) {
+				t.Errorf("validate = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+// How long a request will wait for the upstream rate limit window to reset
+// before we give up and hand the 429 back to the caller.
+const defaultMaxRateLimitWait = 5 * time.Second
+
+// Used when NerdGraph throttles us without telling us when to come back.
+const defaultRetryAfter = 30 * time.Second
+
+// RateLimitError is returned when NerdGraph has told us to back off.
+type RateLimitError struct {
+	ResetAt time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("NerdGraph rate limit exceeded, retry after %s", e.ResetAt.UTC().Format(time.RFC3339))
+}
+
+// RetryAfter is the number of whole seconds until the limit resets, as used in the Retry-After header.
+func (e *RateLimitError) RetryAfter() int {
+	seconds := int(time.Until(e.ResetAt).Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// rateLimitTransport watches NerdGraph responses for throttling and holds
+// back further requests until the advertised reset time has passed.
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	maxWait time.Duration
+
+	mu      sync.Mutex
+	resetAt time.Time
+}
+
+func newRateLimitTransport(next http.RoundTripper) *rateLimitTransport {
+	maxWait := defaultMaxRateLimitWait
+	if v := os.Getenv("NERDGRAPH_MAX_RATE_LIMIT_WAIT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("Invalid NERDGRAPH_MAX_RATE_LIMIT_WAIT %q, using %s", v, maxWait)
+		} else {
+			maxWait = d
+		}
+	}
+	return &rateLimitTransport{next: next, maxWait: maxWait}
+}
+
+// rateLimits maps each client to its rateLimitTransport, so handlers can
+// tell whether the credential they'd use is currently throttled.
+var rateLimits sync.Map
+
+// throttledUntil returns when the client's rate limit window resets, and
+// whether that is further off than a request would be held for.
+func throttledUntil(client *graphql.Client) (time.Time, bool) {
+	t, ok := rateLimits.Load(client)
+	if !ok {
+		return time.Time{}, false
+	}
+	transport := t.(*rateLimitTransport)
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	return transport.resetAt, time.Until(transport.resetAt) > transport.maxWait
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.wait(req); err != nil {
+		return nil, err
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		res.Body.Close()
+		return nil, t.throttle(res.Header.Get("Retry-After"))
+	}
+
+	// NerdGraph also reports throttling as a GraphQL error on a 200 response.
+	// When the response carries data too, part of the request ran, so the
+	// body is handed on for the caller to see what did.
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if limited, hasData := isRateLimitedBody(body); limited {
+		rateLimitErr := t.throttle(res.Header.Get("Retry-After"))
+		if !hasData {
+			return nil, rateLimitErr
+		}
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+// wait blocks until the current rate limit window resets, or fails straight
+// away when that is further off than we are willing to hold the request.
+func (t *rateLimitTransport) wait(req *http.Request) error {
+	t.mu.Lock()
+	resetAt := t.resetAt
+	t.mu.Unlock()
+
+	delay := time.Until(resetAt)
+	if delay <= 0 {
+		return nil
+	}
+	if delay > t.maxWait {
+		nerdGraphRateLimitRejections.Inc(operationFromContext(req.Context()))
+		return &RateLimitError{ResetAt: resetAt}
+	}
+
+	log.Printf("NerdGraph rate limited, pacing request for %s", delay.Round(time.Millisecond))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func (t *rateLimitTransport) throttle(retryAfter string) *RateLimitError {
+	resetAt := time.Now().Add(parseRetryAfter(retryAfter))
+
+	t.mu.Lock()
+	if resetAt.After(t.resetAt) {
+		t.resetAt = resetAt
+	}
+	resetAt = t.resetAt
+	t.mu.Unlock()
+
+	log.Printf("NerdGraph rate limit hit, holding requests until %s", resetAt.UTC().Format(time.RFC3339))
+	return &RateLimitError{ResetAt: resetAt}
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header: a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return defaultRetryAfter
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// isRateLimitedBody reports whether a response carries a TOO_MANY_REQUESTS
+// error, and whether it carries any data alongside.
+func isRateLimitedBody(body []byte) (limited, hasData bool) {
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Extensions struct {
+				ErrorClass string `json:"errorClass"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false, false
+	}
+	hasData = len(response.Data) > 0 && string(response.Data) != "null"
+	for _, e := range response.Errors {
+		if e.Extensions.ErrorClass == "TOO_MANY_REQUESTS" {
+			return true, hasData
+		}
+	}
+	return false, hasData
+}
+
+// writeRateLimitError sends a 429 to the caller with the upstream reset time.
+func writeRateLimitError(w http.ResponseWriter, err *RateLimitError) {
+	log.Printf("Rejecting request: %v, Status Code: %d", err, http.StatusTooManyRequests)
+	w.Header().Set("Retry-After", strconv.Itoa(err.RetryAfter()))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":    "NerdGraph rate limit exceeded",
+		"reset_at": err.ResetAt.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// DesiredState is the ingest keys a set of accounts should have. Keys are
+// matched to live ones by account, name and ingest type. Live keys in the
+// accounts that aren't listed are only deleted when Prune is set.
+type DesiredState struct {
+	Accounts []DesiredAccount `json:"accounts"`
+	Prune    bool             `json:"prune,omitempty"`
+}
+
+type DesiredAccount struct {
+	AccountID int          `json:"accountId"`
+	Keys      []DesiredKey `json:"keys"`
+}
+
+// DesiredKey is one key of the desired state. Notes and labels that are
+// left out are not managed, so whatever the live key has is kept.
+type DesiredKey struct {
+	Name       string            `json:"name"`
+	IngestType string            `json:"ingestType"`
+	Notes      *string           `json:"notes,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// Reconcile actions and their outcomes.
+const (
+	reconcileCreate = "create"
+	reconcileUpdate = "update"
+	reconcileDelete = "delete"
+
+	reconcilePlanned         = "planned"
+	reconcileApplied         = "applied"
+	reconcileFailed          = "failed"
+	reconcileScheduled       = "scheduled"
+	reconcilePendingApproval = "pending_approval"
+	reconcileUnmanaged       = "unmanaged"
+)
+
+// ReconcileAction is one step of converging an account to its desired
+// state, and what became of it.
+type ReconcileAction struct {
+	Action            string   `json:"action"`
+	AccountID         int      `json:"accountId"`
+	KeyID             string   `json:"keyId,omitempty"`
+	Name              string   `json:"name"`
+	IngestType        string   `json:"ingestType"`
+	Changes           []string `json:"changes,omitempty"`
+	Status            string   `json:"status,omitempty"`
+	Error             string   `json:"error,omitempty"`
+	Job               string   `json:"job,omitempty"`
+	Approval          string   `json:"approval,omitempty"`
+	CancellationToken string   `json:"cancellationToken,omitempty"`
+
+	desired DesiredKey
+}
+
+// validateDesiredState lists what is wrong with a desired state document.
+func validateDesiredState(desired DesiredState) []string {
+	var problems []string
+	if len(desired.Accounts) == 0 {
+		problems = append(problems, "accounts is required")
+	}
+	seenAccounts := map[int]bool{}
+	for i, account := range desired.Accounts {
+		if account.AccountID <= 0 {
+			problems = append(problems, fmt.Sprintf("accounts[%d].accountId must be a New Relic account ID", i))
+			continue
+		}
+		if seenAccounts[account.AccountID] {
+			problems = append(problems, fmt.Sprintf("account %d is listed more than once", account.AccountID))
+		}
+		seenAccounts[account.AccountID] = true
+		seenKeys := map[string]bool{}
+		for j, key := range account.Keys {
+			where := fmt.Sprintf("account %d key %d", account.AccountID, j)
+			if key.Name == "" {
+				problems = append(problems, where+": name is required")
+			}
+			if !validIngestTypes[key.IngestType] {
+				problems = append(problems, where+": ingestType must be LICENSE or BROWSER")
+			}
+			if err := validateLabels(key.Labels); err != nil {
+				problems = append(problems, where+": "+err.Error())
+			}
+			if id := key.IngestType + "/" + key.Name; seenKeys[id] {
+				problems = append(problems, fmt.Sprintf("%s: %s key %q is listed more than once", where, key.IngestType, key.Name))
+			} else {
+				seenKeys[id] = true
+			}
+		}
+	}
+	return problems
+}
+
+// planReconcile compares the desired state with the live keys and returns
+// the actions that would converge them. Live keys the desired state
+// doesn't list come back as deletions marked unmanaged unless it prunes.
+func (s *Server) planReconcile(ctx context.Context, desired DesiredState) ([]ReconcileAction, error) {
+	accountIDs := make([]int, len(desired.Accounts))
+	for i, account := range desired.Accounts {
+		accountIDs[i] = account.AccountID
+	}
+	live, err := s.searchIngestKeys(ctx, accountIDs)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].ID < live[j].ID })
+	unmatched := map[string][]IngestKey{}
+	for _, key := range live {
+		id := reconcileID(key.AccountID, key.IngestType, key.Name)
+		unmatched[id] = append(unmatched[id], key)
+	}
+
+	var actions []ReconcileAction
+	for _, account := range desired.Accounts {
+		for _, want := range account.Keys {
+			action := ReconcileAction{
+				AccountID:  account.AccountID,
+				Name:       want.Name,
+				IngestType: want.IngestType,
+				Status:     reconcilePlanned,
+				desired:    want,
+			}
+			id := reconcileID(account.AccountID, want.IngestType, want.Name)
+			if len(unmatched[id]) == 0 {
+				action.Action = reconcileCreate
+				actions = append(actions, action)
+				continue
+			}
+			have := unmatched[id][0]
+			unmatched[id] = unmatched[id][1:]
+			action.KeyID = have.ID
+			// Keys created with TRACE_IN_NOTES carry a trace the desired
+			// state can't know about.
+			if want.Notes != nil && *want.Notes != untracedNotes(have.Notes) {
+				action.Changes = append(action.Changes, "notes")
+			}
+			if want.Labels != nil {
+				var labels map[string]string
+				if record := s.keys.Get(have.ID); record != nil {
+					labels = record.Labels
+				}
+				if !maps.Equal(want.Labels, labels) {
+					action.Changes = append(action.Changes, "labels")
+				}
+			}
+			if len(action.Changes) > 0 {
+				action.Action = reconcileUpdate
+				actions = append(actions, action)
+			}
+		}
+	}
+
+	for _, keys := range unmatched {
+		for _, key := range keys {
+			if job, _ := s.pendingDelete(key.ID); job != nil {
+				continue
+			}
+			action := ReconcileAction{
+				Action:     reconcileDelete,
+				AccountID:  key.AccountID,
+				KeyID:      key.ID,
+				Name:       key.Name,
+				IngestType: key.IngestType,
+				Status:     reconcilePlanned,
+			}
+			if !desired.Prune {
+				action.Status = reconcileUnmanaged
+			}
+			actions = append(actions, action)
+		}
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		a, b := actions[i], actions[j]
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Name < b.Name
+	})
+	return actions, nil
+}
+
+func reconcileID(accountID int, ingestType, name string) string {
+	return strconv.Itoa(accountID) + "/" + ingestType + "/" + name
+}
+
+// applyReconcile carries out the planned actions, recording the outcome of
+// each. Deletions go through approval or the delete grace period like any
+// other deletion.
+func (s *Server) applyReconcile(ctx context.Context, actions []ReconcileAction) {
+	var creates []InsertKeyRequest
+	var createIndexes []int
+	for i, action := range actions {
+		if action.Action == reconcileCreate && action.Status == reconcilePlanned {
+			request := InsertKeyRequest{
+				AccountID:  action.AccountID,
+				Name:       action.Name,
+				IngestType: action.IngestType,
+				Labels:     action.desired.Labels,
+			}
+			if action.desired.Notes != nil {
+				request.Notes = *action.desired.Notes
+			}
+			creates = append(creates, request)
+			createIndexes = append(createIndexes, i)
+		}
+	}
+	for start := 0; start < len(creates); start += batchSize() {
+		end := min(start+batchSize(), len(creates))
+		responses, errs := s.createIngestKeys(ctx, creates[start:end])
+		for j, err := range errs {
+			action := &actions[createIndexes[start+j]]
+			if err == nil && len(responses[j].APIAccessCreateKeys.CreatedKeys) == 0 {
+				err = KeyErrors(responses[j].APIAccessCreateKeys.Errors)
+			}
+			if err != nil {
+				action.fail(err)
+				continue
+			}
+			action.KeyID = responses[j].APIAccessCreateKeys.CreatedKeys[0].ID
+			action.Status = reconcileApplied
+			s.audit.Record(ctx, AuditEntry{Action: "key.create", AccountID: action.AccountID, KeyID: action.KeyID, Details: map[string]any{"reconcile": true}})
+		}
+	}
+
+	for i := range actions {
+		action := &actions[i]
+		if action.Status != reconcilePlanned {
+			continue
+		}
+		switch action.Action {
+		case reconcileUpdate:
+			s.applyReconcileUpdate(ctx, action)
+		case reconcileDelete:
+			s.applyReconcileDelete(ctx, action)
+		}
+	}
+}
+
+func (s *Server) applyReconcileUpdate(ctx context.Context, action *ReconcileAction) {
+	for _, change := range action.Changes {
+		switch change {
+		case "notes":
+			if err := s.updateIngestKeyNotes(ctx, action.KeyID, *action.desired.Notes); err != nil {
+				action.fail(err)
+				return
+			}
+			s.search.Invalidate(action.AccountID)
+		case "labels":
+			s.keys.Update(KeyRecord{ID: action.KeyID, AccountID: action.AccountID, Name: action.Name}, func(record *KeyRecord) {
+				record.Labels = maps.Clone(action.desired.Labels)
+			})
+		}
+	}
+	action.Status = reconcileApplied
+	s.audit.Record(ctx, AuditEntry{
+		Action:    "key.update",
+		AccountID: action.AccountID,
+		KeyID:     action.KeyID,
+		Details:   map[string]any{"reconcile": true, "changes": action.Changes},
+	})
+}
+
+func (s *Server) applyReconcileDelete(ctx context.Context, action *ReconcileAction) {
+	switch {
+	case s.approvals != nil:
+		approval, err := s.requestApproval(ctx, "delete", action.KeyID, s.deleteGrace)
+		if err != nil {
+			action.fail(err)
+			return
+		}
+		action.Status, action.Approval = reconcilePendingApproval, approval.ID
+	case s.deleteGrace > 0:
+		job, token, err := s.scheduleDelete(ctx, action.KeyID, s.deleteGrace)
+		if err != nil {
+			action.fail(err)
+			return
+		}
+		action.Status, action.Job, action.CancellationToken = reconcileScheduled, job.ID, token
+	default:
+		if err := s.deleteKey(ctx, action.KeyID); err != nil {
+			action.fail(err)
+			return
+		}
+		action.Status = reconcileApplied
+	}
+}
+
+func (a *ReconcileAction) fail(err error) {
+	a.Status = reconcileFailed
+	a.Error = secrets.Redact(err.Error())
+}
+
+// readDesiredState decodes and validates a desired state document and
+// authorizes the caller for its accounts. It returns false when the request
+// has been answered.
+func readDesiredState(w http.ResponseWriter, r *http.Request, desired *DesiredState) bool {
+	if err := json.NewDecoder(r.Body).Decode(desired); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return false
+	}
+	if problems := validateDesiredState(*desired); len(problems) > 0 {
+		log.Printf("Invalid desired state: %v, Status Code: %d", problems, http.StatusBadRequest)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid desired state", "details": problems})
+		return false
+	}
+	for _, account := range desired.Accounts {
+		if !authorizeAccount(w, r, account.AccountID) {
+			return false
+		}
+	}
+	return true
+}
+
+// Converge the keys of the listed accounts to a desired state
+func (s *Server) reconcileKeys(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to reconcile keys")
+
+	var desired DesiredState
+	if !readDesiredState(w, r, &desired) {
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	actions, err := s.planReconcile(r.Context(), desired)
+	if err != nil {
+		writeError(w, "Failed to read live keys", err)
+		return
+	}
+	if !dryRun {
+		s.applyReconcile(r.Context(), actions)
+	}
+
+	counts := map[string]int{}
+	for _, action := range actions {
+		counts[action.Status]++
+	}
+	log.Printf("Reconciled %d accounts: %v", len(desired.Accounts), counts)
+	if !dryRun {
+		s.audit.Record(r.Context(), AuditEntry{Action: "keys.reconcile", Details: map[string]any{"accounts": len(desired.Accounts), "outcomes": counts}})
+	}
+	if actions == nil {
+		actions = []ReconcileAction{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"dryRun": dryRun, "actions": actions})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// New Relic key formats: user/insert/query/browser keys carry an NRxx- prefix,
+// license (ingest) keys are 40 characters ending in NRAL and mobile
+// application tokens end in -NRMA.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`NR[A-Z]{2}-[A-Za-z0-9_-]{8,}`),
+	regexp.MustCompile(`\b[A-Za-z0-9]{36}NRAL\b`),
+	regexp.MustCompile(`\bAA[0-9a-f]{40}-NRMA\b`),
+}
+
+// redactor scrubs secrets out of text before it leaves the process through
+// logs or error responses. The configured credentials are redacted exactly;
+// keys and tokens NerdGraph hands out are caught by the patterns above, so
+// they aren't registered one by one.
+type redactor struct {
+	mu      sync.RWMutex
+	secrets map[string]struct{}
+}
+
+var secrets = &redactor{secrets: map[string]struct{}{}}
+
+// Add registers a configured secret value that must never be written out.
+// Every value is checked on every line, so only register credentials from
+// the configuration, never values handed out per request.
+func (r *redactor) Add(value string) {
+	if len(value) < 8 {
+		return
+	}
+	r.mu.Lock()
+	r.secrets[value] = struct{}{}
+	r.mu.Unlock()
+}
+
+// Redact returns s with every known or recognisable secret replaced.
+func (r *redactor) Redact(s string) string {
+	r.mu.RLock()
+	for value := range r.secrets {
+		if strings.Contains(s, value) {
+			s = strings.ReplaceAll(s, value, redacted)
+		}
+	}
+	r.mu.RUnlock()
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// redactingWriter is installed as the log output so that no log line can
+// carry a secret, whichever code path produced it.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, secrets.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// httpError is http.Error with secrets scrubbed from the message.
+func httpError(w http.ResponseWriter, message string, code int) {
+	http.Error(w, secrets.Redact(message), code)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Keys and tokens NerdGraph hands out aren't registered with the redactor,
+// so the patterns have to catch every format.
+func TestRedactHandedOutValues(t *testing.T) {
+	for _, value := range []string{
+		"NRAK-ABCDEFGHIJKLMNOPQRSTUVWXYZ0",
+		"NRJS-0123456789abcdef012",
+		"0123456789abcdef0123456789abcdef0123NRAL",
+		"AA0123456789abcdef0123456789abcdef01234567-NRMA",
+	} {
+		got := secrets.Redact("created key " + value + ".")
+		if strings.Contains(got, value) || !strings.Contains(got, redacted) {
+			t.Errorf("Redact left %s in %q", value, got)
+		}
+	}
+}
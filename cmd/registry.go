@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyRecord is what we know locally about a key, on top of what NerdGraph
+// stores for it.
+type KeyRecord struct {
+	ID        string            `json:"id"`
+	AccountID int               `json:"accountId"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	CreatedBy string            `json:"createdBy,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// keyRegistry keeps the local records for keys, persisted to a sealed file.
+// Keys created through the proxy are added automatically; others are added
+// the first time they are labelled.
+type keyRegistry struct {
+	path string
+
+	mu   sync.Mutex
+	keys map[string]*KeyRecord
+}
+
+func newKeyRegistry(path string) (*keyRegistry, error) {
+	r := &keyRegistry{path: path, keys: map[string]*KeyRecord{}}
+	if err := loadSealed(path, &r.keys); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *keyRegistry) save() {
+	if err := saveSealed(r.path, r.keys); err != nil {
+		log.Printf("Failed to save key registry: %v", err)
+	}
+}
+
+// Get returns a copy of a key's record, or nil.
+func (r *keyRegistry) Get(id string) *KeyRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.keys[id]
+	if !ok {
+		return nil
+	}
+	return record.copy()
+}
+
+// Put adds or replaces the record for a key.
+func (r *keyRegistry) Put(record KeyRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = now
+	}
+	record.UpdatedAt = now
+	r.keys[record.ID] = record.copy()
+	r.save()
+}
+
+// Update changes the record for a key in place, creating it from base when
+// it doesn't exist yet, and returns the result. change always sees a
+// non-nil Labels map, even for records loaded without labels.
+func (r *keyRegistry) Update(base KeyRecord, change func(record *KeyRecord)) *KeyRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.keys[base.ID]
+	if !ok {
+		record = base.copy()
+		record.CreatedAt = time.Now().UTC()
+		r.keys[base.ID] = record
+	}
+	if record.Labels == nil {
+		record.Labels = map[string]string{}
+	}
+	change(record)
+	record.UpdatedAt = time.Now().UTC()
+	r.save()
+	return record.copy()
+}
+
+// Remove forgets a deleted key.
+func (r *keyRegistry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[id]; ok {
+		delete(r.keys, id)
+		r.save()
+	}
+}
+
+// Select returns the records whose labels match the selector, ordered by ID.
+func (r *keyRegistry) Select(selector labelSelector) []*KeyRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matches []*KeyRecord
+	for _, record := range r.keys {
+		if selector.Matches(record.Labels) {
+			matches = append(matches, record.copy())
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches
+}
+
+func (k *KeyRecord) copy() *KeyRecord {
+	c := *k
+	c.Labels = make(map[string]string, len(k.Labels))
+	for name, value := range k.Labels {
+		c.Labels[name] = value
+	}
+	return &c
+}
+
+// labelRequirement is one term of a selector: "key=value", "key!=value" or
+// just "key" for "has this label".
+type labelRequirement struct {
+	key      string
+	operator string
+	value    string
+}
+
+// labelSelector matches label sets against every one of its requirements.
+type labelSelector []labelRequirement
+
+// parseLabelSelector parses a comma separated selector such as
+// "team=payments,env!=dev,critical".
+func parseLabelSelector(selector string) (labelSelector, error) {
+	var requirements labelSelector
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		requirement := labelRequirement{key: term, operator: "exists"}
+		if key, value, ok := strings.Cut(term, "!="); ok {
+			requirement = labelRequirement{key: key, operator: "!=", value: value}
+		} else if key, value, ok := strings.Cut(term, "="); ok {
+			requirement = labelRequirement{key: key, operator: "=", value: strings.TrimPrefix(value, "=")}
+		}
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if requirement.key == "" {
+			return nil, fmt.Errorf("invalid selector term %q", term)
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, nil
+}
+
+func (s labelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.key]
+		switch requirement.operator {
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "=":
+			if !ok || value != requirement.value {
+				return false
+			}
+		case "!=":
+			if ok && value == requirement.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateLabels checks label names and values are usable in selectors.
+func validateLabels(labels map[string]string) error {
+	for name, value := range labels {
+		if name == "" || strings.ContainsAny(name, "=!, ") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if strings.ContainsAny(value, "=!,") {
+			return fmt.Errorf("invalid value for label %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReportStaleAge = 90 * 24 * time.Hour
+	reportCheckInterval   = 10 * time.Minute
+)
+
+// reportPeriods are the SUMMARY_REPORT schedules.
+var reportPeriods = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// SummaryReport is what happened to keys over a reporting period.
+type SummaryReport struct {
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Created   int            `json:"created"`
+	Deleted   int            `json:"deleted"`
+	Rotated   int            `json:"rotated"`
+	ByActor   map[string]int `json:"byActor"`
+	Stale     []StaleKey     `json:"stale"`
+	StaleDays int            `json:"staleDays"`
+	// Violations are the differences from the desired state, when one is
+	// configured; nil means it wasn't checked.
+	Violations *DriftReport `json:"violations,omitempty"`
+	// Problems lists the parts of the report that couldn't be compiled.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// StaleKey is a key that hasn't been rotated for longer than the stale age.
+type StaleKey struct {
+	ID        string    `json:"id"`
+	AccountID int       `json:"accountId"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// reportEmail is the payload of a "report-email" job.
+type reportEmail struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// reportState is persisted so restarts and leader changes don't resend.
+type reportState struct {
+	LastSent time.Time `json:"lastSent"`
+}
+
+// summaryReporter compiles a summary report every SUMMARY_REPORT period and
+// sends it to the webhooks and, when SMTP is configured, by email.
+type summaryReporter struct {
+	period   time.Duration
+	staleAge time.Duration
+	path     string
+
+	smtpAddr string
+	smtpAuth smtp.Auth
+	from     string
+	to       []string
+}
+
+// newSummaryReporterFromEnv returns nil when SUMMARY_REPORT is unset.
+func newSummaryReporterFromEnv() (*summaryReporter, error) {
+	schedule := os.Getenv("SUMMARY_REPORT")
+	if schedule == "" || schedule == "off" {
+		return nil, nil
+	}
+	period, ok := reportPeriods[schedule]
+	if !ok {
+		return nil, fmt.Errorf("invalid SUMMARY_REPORT %q, expected daily or weekly", schedule)
+	}
+	r := &summaryReporter{period: period, staleAge: defaultReportStaleAge, path: dataPath("report.json")}
+	if v := os.Getenv("REPORT_STALE_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("invalid REPORT_STALE_DAYS %q", v)
+		}
+		r.staleAge = time.Duration(days) * 24 * time.Hour
+	}
+
+	for _, to := range strings.Split(os.Getenv("REPORT_EMAIL_TO"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			r.to = append(r.to, to)
+		}
+	}
+	if len(r.to) == 0 {
+		return r, nil
+	}
+	r.smtpAddr, r.from = os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_FROM")
+	if r.smtpAddr == "" || r.from == "" {
+		return nil, errors.New("REPORT_EMAIL_TO needs SMTP_ADDR and SMTP_FROM")
+	}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		password := os.Getenv("SMTP_PASSWORD")
+		secrets.Add(password)
+		host, _, _ := strings.Cut(r.smtpAddr, ":")
+		r.smtpAuth = smtp.PlainAuth("", username, password, host)
+	}
+	return r, nil
+}
+
+// sendDueReport sends a report when the period has passed since the last
+// one. It runs as a background job on the leader.
+func (s *Server) sendDueReport(ctx context.Context) {
+	r := s.reporter
+	var state reportState
+	if err := loadSealed(r.path, &state); err != nil {
+		log.Printf("Failed to read report state: %v", err)
+		return
+	}
+	now := time.Now().UTC()
+	if !state.LastSent.IsZero() && now.Sub(state.LastSent) < r.period {
+		return
+	}
+	from := state.LastSent
+	if from.IsZero() {
+		from = now.Add(-r.period)
+	}
+
+	report := s.summaryReport(ctx, from, now)
+	data := map[string]any{}
+	if encoded, err := json.Marshal(report); err == nil {
+		json.Unmarshal(encoded, &data)
+	}
+	s.notify(ctx, WebhookEvent{Type: "report.summary", Data: data})
+	if len(r.to) > 0 {
+		email := reportEmail{
+			Subject: fmt.Sprintf("Ingest key summary %s to %s", from.Format(time.DateOnly), now.Format(time.DateOnly)),
+			Body:    report.Text(),
+		}
+		if _, err := s.jobs.Enqueue(ctx, "report-email", email); err != nil {
+			log.Printf("Failed to queue report email: %v", err)
+		}
+	}
+
+	if err := saveSealed(r.path, reportState{LastSent: now}); err != nil {
+		log.Printf("Failed to save report state: %v", err)
+	}
+	log.Printf("Sent summary report for %s to %s", from.Format(time.RFC3339), now.Format(time.RFC3339))
+}
+
+// summaryReport compiles the report for [from, to). Parts that fail are
+// listed as problems rather than failing the whole report.
+func (s *Server) summaryReport(ctx context.Context, from, to time.Time) *SummaryReport {
+	r := s.reporter
+	report := &SummaryReport{From: from, To: to, ByActor: map[string]int{}, Stale: []StaleKey{}, StaleDays: int(r.staleAge.Hours() / 24)}
+
+	entries, err := s.audit.Entries(func(entry *AuditEntry) bool {
+		return !entry.Time.Before(from) && entry.Time.Before(to) && entry.Outcome == "success"
+	})
+	if err != nil {
+		report.Problems = append(report.Problems, "audit log: "+err.Error())
+	}
+	for _, entry := range entries {
+		switch entry.Action {
+		case "key.create":
+			report.Created++
+		case "key.delete":
+			report.Deleted++
+		case "key.rotate":
+			report.Rotated++
+		default:
+			continue
+		}
+		report.ByActor[entry.Actor]++
+	}
+
+	for _, record := range s.keys.Select(nil) {
+		if !record.CreatedAt.IsZero() && to.Sub(record.CreatedAt) > r.staleAge {
+			report.Stale = append(report.Stale, StaleKey{
+				ID:        record.ID,
+				AccountID: record.AccountID,
+				Name:      record.Name,
+				Owner:     record.Owner,
+				CreatedAt: record.CreatedAt,
+			})
+		}
+	}
+	sort.Slice(report.Stale, func(i, j int) bool { return report.Stale[i].CreatedAt.Before(report.Stale[j].CreatedAt) })
+
+	if s.desiredState != nil {
+		desired, err := s.desiredState.Load(ctx)
+		if err == nil {
+			report.Violations, err = s.driftReport(ctx, *desired)
+		}
+		if err != nil {
+			report.Problems = append(report.Problems, "desired state: "+secrets.Redact(err.Error()))
+		} else {
+			report.Violations.Source = s.desiredState.String()
+		}
+	}
+	return report
+}
+
+// Text renders the report for email.
+func (r *SummaryReport) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ingest key summary from %s to %s\n\n", r.From.Format(time.RFC1123), r.To.Format(time.RFC1123))
+	fmt.Fprintf(&b, "Keys created: %d\nKeys deleted: %d\nKeys rotated: %d\n", r.Created, r.Deleted, r.Rotated)
+	if len(r.ByActor) > 0 {
+		actors := make([]string, 0, len(r.ByActor))
+		for actor := range r.ByActor {
+			actors = append(actors, actor)
+		}
+		sort.Strings(actors)
+		b.WriteString("\nChanges by caller:\n")
+		for _, actor := range actors {
+			fmt.Fprintf(&b, "  %s: %d\n", actor, r.ByActor[actor])
+		}
+	}
+
+	fmt.Fprintf(&b, "\nKeys not rotated in %d days: %d\n", r.StaleDays, len(r.Stale))
+	for _, key := range r.Stale {
+		fmt.Fprintf(&b, "  %s %q in account %d, created %s", key.ID, key.Name, key.AccountID, key.CreatedAt.Format(time.DateOnly))
+		if key.Owner != "" {
+			fmt.Fprintf(&b, ", owned by %s", key.Owner)
+		}
+		b.WriteString("\n")
+	}
+
+	if v := r.Violations; v != nil {
+		fmt.Fprintf(&b, "\nDifferences from the desired state in %s:\n", v.Source)
+		if v.InSync {
+			b.WriteString("  none\n")
+		}
+		for _, group := range []struct {
+			what    string
+			actions []ReconcileAction
+		}{{"missing", v.Additions}, {"not in desired state", v.Deletions}, {"differs", v.Mismatches}} {
+			for _, action := range group.actions {
+				fmt.Fprintf(&b, "  %s %s key %q in account %d", group.what, action.IngestType, action.Name, action.AccountID)
+				if len(action.Changes) > 0 {
+					fmt.Fprintf(&b, " (%s)", strings.Join(action.Changes, ", "))
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+
+	if len(r.Problems) > 0 {
+		b.WriteString("\nParts of this report could not be compiled:\n")
+		for _, problem := range r.Problems {
+			fmt.Fprintf(&b, "  %s\n", problem)
+		}
+	}
+	return b.String()
+}
+
+func (s *Server) registerReportJobs() {
+	s.jobs.Register("report-email", func(ctx context.Context, job *Job) (any, error) {
+		var email reportEmail
+		if err := json.Unmarshal(job.Payload, &email); err != nil {
+			return nil, err
+		}
+		if s.reporter == nil || len(s.reporter.to) == 0 {
+			return nil, errors.New("report email is no longer configured")
+		}
+		return nil, retryLater(s.reporter.sendEmail(email))
+	})
+}
+
+// sendEmail mails a report to REPORT_EMAIL_TO through SMTP_ADDR.
+func (r *summaryReporter) sendEmail(email reportEmail) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", r.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(r.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", email.Subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+	return smtp.SendMail(r.smtpAddr, r.smtpAuth, r.from, r.to, []byte(msg.String()))
+}
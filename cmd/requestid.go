@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+)
+
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID tags ctx with the ID of the request it serves.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the request ID, or "" outside of a request
+// or a job queued by one.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts the IDs pipelines and load balancers commonly
+// send, and nothing that could be used to forge log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware keeps the X-Request-ID the client sent, or makes one
+// up, and echoes it in the response so both sides log the same ID.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// traceHeaders tags a NerdGraph request with the proxy request ID and the
+// caller it is made for, so New Relic's side can be matched to ours.
+func traceHeaders(ctx context.Context, header http.Header) {
+	if id := requestIDFromContext(ctx); id != "" {
+		header.Set("X-Request-ID", id)
+	}
+	if caller := callerFromContext(ctx); caller != nil {
+		header.Set("X-Proxy-Caller", caller.Name)
+	}
+}
+
+// traceSuffix matches what traceNotes appends.
+var traceSuffix = regexp.MustCompile(`\s*\[(request [^,\]]*, )?caller [^\]]*\]$`)
+
+// untracedNotes returns notes without what traceNotes appended, so stored
+// notes can be compared with the notes a key was asked for.
+func untracedNotes(notes string) string {
+	return traceSuffix.ReplaceAllString(notes, "")
+}
+
+// traceNotes appends the request ID and caller to key notes when
+// TRACE_IN_NOTES is set, for audit trails that only show what NerdGraph
+// stored.
+func traceNotes(ctx context.Context, notes string) string {
+	if os.Getenv("TRACE_IN_NOTES") != "true" {
+		return notes
+	}
+	trace := fmt.Sprintf("[caller %s]", actorName(ctx))
+	if id := requestIDFromContext(ctx); id != "" {
+		trace = fmt.Sprintf("[request %s, caller %s]", id, actorName(ctx))
+	}
+	if notes == "" {
+		return trace
+	}
+	return notes + " " + trace
+}
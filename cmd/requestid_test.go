@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUntracedNotesUndoesTraceNotes(t *testing.T) {
+	t.Setenv("TRACE_IN_NOTES", "true")
+	for _, ctx := range []context.Context{context.Background(), withRequestID(context.Background(), "req-1")} {
+		for _, notes := range []string{"", "payments [prod]", "checkout agents"} {
+			traced := traceNotes(ctx, notes)
+			if traced == notes {
+				t.Fatalf("traceNotes(%q) added nothing", notes)
+			}
+			if got := untracedNotes(traced); got != notes {
+				t.Errorf("untracedNotes(%q) = %q, want %q", traced, got, notes)
+			}
+		}
+	}
+	if got := untracedNotes("notes [not a trace]"); got != "notes [not a trace]" {
+		t.Errorf("untracedNotes stripped %q", got)
+	}
+}
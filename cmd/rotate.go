@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// rotateKey creates a replacement for an ingest key with the same account,
+// name, notes and ingest type, then deletes the original. When the
+// replacement was created but the original could not be deleted, both the
+// new key and an error are returned.
+func (s *Server) rotateKey(ctx context.Context, id string) (*CreatedKey, error) {
+	unlock, ok, err := s.locks.TryLock(ctx, "key/"+id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errLocked
+	}
+	defer unlock()
+
+	key, err := s.getIngestKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccount(ctx, key.AccountID); err != nil {
+		return nil, err
+	}
+
+	replacement := InsertKeyRequest{
+		AccountID:  key.AccountID,
+		Name:       key.Name,
+		Notes:      untracedNotes(key.Notes),
+		IngestType: key.IngestType,
+	}
+	// The replacement carries the original's labels.
+	if record := s.keys.Get(id); record != nil {
+		replacement.Labels = record.Labels
+	}
+	created, err := s.createIngestKey(ctx, replacement)
+	if err != nil {
+		return nil, err
+	}
+	if len(created.APIAccessCreateKeys.CreatedKeys) == 0 {
+		return nil, KeyErrors(created.APIAccessCreateKeys.Errors)
+	}
+	newKey := created.APIAccessCreateKeys.CreatedKeys[0]
+
+	deleted, err := s.deleteIngestKeys(ctx, []string{id})
+	if err == nil && len(deleted.ApiAccessDeleteKeys.Errors) > 0 {
+		err = KeyErrors(deleted.ApiAccessDeleteKeys.Errors)
+	}
+	if err != nil {
+		return &newKey, fmt.Errorf("created replacement %s but failed to delete key %s: %w", newKey.ID, id, err)
+	}
+	log.Printf("Successfully rotated key %s to %s", id, newKey.ID)
+	s.audit.Record(ctx, AuditEntry{
+		Action:    "key.rotate",
+		AccountID: key.AccountID,
+		KeyID:     id,
+		Details:   map[string]any{"replacement": newKey.ID},
+	})
+	return &newKey, nil
+}
+
+// Rotate an ingest key
+func (s *Server) rotateApiKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to rotate key %s", id)
+	s.rotateKeyWithApproval(w, r, id)
+}
+
+// rotateKeyWithApproval rotates a key, or files the rotation for approval
+// when approvals are required, and answers the request.
+func (s *Server) rotateKeyWithApproval(w http.ResponseWriter, r *http.Request, id string) {
+	if s.requireApproval(w, r, "rotate", id, 0) {
+		return
+	}
+
+	newKey, err := s.rotateKey(r.Context(), id)
+	if err != nil && newKey != nil {
+		// The replacement exists, so hand it back even though the original
+		// is still there.
+		log.Printf("%v, Status Code: %d", err, http.StatusBadGateway)
+		writeJSON(w, http.StatusBadGateway, map[string]any{
+			"error":      "Created replacement key but failed to delete the original",
+			"details":    secrets.Redact(err.Error()),
+			"insert_key": newKey,
+		})
+		return
+	}
+	if err != nil {
+		writeError(w, "Failed to rotate key", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"insert_key":  newKey,
+		"deleted_key": id,
+	})
+}
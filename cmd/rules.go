@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+// EventsToMetricsRule turns the results of an NRQL query over events into
+// metrics.
+type EventsToMetricsRule struct {
+	ID          string `json:"id,omitempty"`
+	AccountID   int    `json:"accountId,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	NRQL        string `json:"nrql"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// eventsToMetricsFailure is an entry in the failures list of an
+// eventsToMetrics mutation.
+type eventsToMetricsFailure struct {
+	Errors []struct {
+		Description string `json:"description"`
+		Reason      string `json:"reason"`
+	} `json:"errors"`
+}
+
+// failureErrors turns eventsToMetrics failures into KeyErrors, so they are
+// reported with the same statuses as other mutation errors.
+func failureErrors(failures []eventsToMetricsFailure) KeyErrors {
+	var keyErrors KeyErrors
+	for _, failure := range failures {
+		for _, e := range failure.Errors {
+			keyErrors = append(keyErrors, KeyError{Message: e.Description, Type: e.Reason, ErrorType: e.Reason})
+		}
+	}
+	return keyErrors
+}
+
+// createEventsToMetricsRule runs the eventsToMetricsCreateRule mutation.
+func (s *Server) createEventsToMetricsRule(ctx context.Context, accountID int, rule EventsToMetricsRule) (*EventsToMetricsRule, error) {
+	req, err := newRequest("createEventsToMetricsRule", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Var("rules", []map[string]any{{
+		"accountId":   accountID,
+		"name":        rule.Name,
+		"description": rule.Description,
+		"nrql":        rule.NRQL,
+	}})
+
+	var responseData struct {
+		EventsToMetricsCreateRule struct {
+			Successes []EventsToMetricsRule    `json:"successes"`
+			Failures  []eventsToMetricsFailure `json:"failures"`
+		} `json:"eventsToMetricsCreateRule"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	result := responseData.EventsToMetricsCreateRule
+	if len(result.Successes) == 0 {
+		return nil, failureErrors(result.Failures)
+	}
+	return &result.Successes[0], nil
+}
+
+// deleteEventsToMetricsRule runs the eventsToMetricsDeleteRule mutation.
+func (s *Server) deleteEventsToMetricsRule(ctx context.Context, accountID int, id string) error {
+	req, err := newRequest("deleteEventsToMetricsRule", nil)
+	if err != nil {
+		return err
+	}
+	req.Var("deletes", []map[string]any{{
+		"accountId": accountID,
+		"ruleId":    id,
+	}})
+
+	var responseData struct {
+		EventsToMetricsDeleteRule struct {
+			Successes []struct {
+				ID string `json:"id"`
+			} `json:"successes"`
+			Failures []eventsToMetricsFailure `json:"failures"`
+		} `json:"eventsToMetricsDeleteRule"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return err
+	}
+	result := responseData.EventsToMetricsDeleteRule
+	if len(result.Successes) == 0 {
+		return failureErrors(result.Failures)
+	}
+	return nil
+}
+
+// MetricNormalizationRule renames, ignores or stops new metrics whose names
+// match an expression.
+type MetricNormalizationRule struct {
+	ID              int    `json:"id,omitempty"`
+	Action          string `json:"action"`
+	MatchExpression string `json:"matchExpression"`
+	Replacement     string `json:"replacement,omitempty"`
+	EvalOrder       int    `json:"evalOrder"`
+	TerminateChain  bool   `json:"terminateChain"`
+	Enabled         bool   `json:"enabled"`
+	Notes           string `json:"notes,omitempty"`
+	ApplicationGUID string `json:"applicationGuid,omitempty"`
+}
+
+// validate checks a rule before it is sent.
+func (rule *MetricNormalizationRule) validate() error {
+	switch rule.Action {
+	case "REPLACE":
+		if rule.Replacement == "" {
+			return fmt.Errorf("replacement is required for REPLACE rules")
+		}
+	case "IGNORE", "DENY_NEW_METRICS":
+	default:
+		return fmt.Errorf("action must be REPLACE, IGNORE or DENY_NEW_METRICS")
+	}
+	if rule.MatchExpression == "" {
+		return fmt.Errorf("matchExpression is required")
+	}
+	return nil
+}
+
+func (rule *MetricNormalizationRule) input() map[string]any {
+	input := map[string]any{
+		"action":          rule.Action,
+		"matchExpression": rule.MatchExpression,
+		"evalOrder":       rule.EvalOrder,
+		"terminateChain":  rule.TerminateChain,
+		"enabled":         rule.Enabled,
+		"notes":           rule.Notes,
+	}
+	if rule.Replacement != "" {
+		input["replacement"] = rule.Replacement
+	}
+	if rule.ApplicationGUID != "" {
+		input["applicationGuid"] = rule.ApplicationGUID
+	}
+	if rule.ID != 0 {
+		input["id"] = rule.ID
+	}
+	return input
+}
+
+// metricNormalizationResult is what every metricNormalization mutation
+// returns.
+type metricNormalizationResult struct {
+	Rule   *MetricNormalizationRule `json:"rule"`
+	Errors []struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"errors"`
+}
+
+func (result metricNormalizationResult) err() error {
+	if len(result.Errors) == 0 && result.Rule != nil {
+		return nil
+	}
+	var keyErrors KeyErrors
+	for _, e := range result.Errors {
+		keyErrors = append(keyErrors, KeyError{Message: e.Message, Type: e.Type, ErrorType: e.Type})
+	}
+	if len(keyErrors) == 0 {
+		return fmt.Errorf("metric normalization rule %w", errNotFound)
+	}
+	return keyErrors
+}
+
+// changeMetricNormalizationRule runs one of the metricNormalization
+// mutations: CreateRule and EditRule take the rule, EnableRule and
+// DisableRule take its ID.
+func (s *Server) changeMetricNormalizationRule(ctx context.Context, mutation string, accountID int, rule *MetricNormalizationRule) (*MetricNormalizationRule, error) {
+	var req *graphql.Request
+	var err error
+	switch mutation {
+	case "metricNormalizationCreateRule":
+		req, err = newRequest("createMetricNormalizationRule", nil)
+	case "metricNormalizationEditRule":
+		req, err = newRequest("editMetricNormalizationRule", nil)
+	default:
+		req, err = newRequest("toggleMetricNormalizationRule", mutationDocument{Mutation: mutation})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mutation == "metricNormalizationCreateRule" || mutation == "metricNormalizationEditRule" {
+		req.Var("rule", rule.input())
+	} else {
+		req.Var("ruleId", rule.ID)
+	}
+	req.Var("accountId", accountID)
+
+	var responseData struct {
+		Result metricNormalizationResult `json:"result"`
+	}
+	if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+		return nil, err
+	}
+	if err := responseData.Result.err(); err != nil {
+		return nil, err
+	}
+	return responseData.Result.Rule, nil
+}
+
+// Create an events-to-metrics rule
+func (s *Server) createEventsToMetricsRuleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to create an events-to-metrics rule")
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	var rule EventsToMetricsRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	if rule.Name == "" || rule.NRQL == "" {
+		http.Error(w, `{"error": "Invalid request: name and nrql are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.createEventsToMetricsRule(r.Context(), accountID, rule)
+	if err != nil {
+		writeError(w, "Failed to create events-to-metrics rule", err)
+		return
+	}
+	log.Printf("Successfully created events-to-metrics rule: ID=%s, Name=%s", created.ID, created.Name)
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "eventsToMetrics.create",
+		AccountID: accountID,
+		Details:   map[string]any{"rule": created.ID, "name": created.Name, "nrql": created.NRQL},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"rule": created})
+}
+
+// Delete an events-to-metrics rule
+func (s *Server) deleteEventsToMetricsRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to delete events-to-metrics rule %s", id)
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.deleteEventsToMetricsRule(r.Context(), accountID, id); err != nil {
+		writeError(w, "Failed to delete events-to-metrics rule", err)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "eventsToMetrics.delete",
+		AccountID: accountID,
+		Details:   map[string]any{"rule": id},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"deleted_rule": id})
+}
+
+// Create or edit a metric normalization rule
+func (s *Server) saveMetricNormalizationRule(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to save a metric normalization rule")
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	var rule MetricNormalizationRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	if err := rule.validate(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid request: "+err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	mutation, action := "metricNormalizationCreateRule", "metricNormalization.create"
+	if id, ok := mux.Vars(r)["id"]; ok {
+		ruleID, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid rule ID"}`, http.StatusBadRequest)
+			return
+		}
+		rule.ID = ruleID
+		mutation, action = "metricNormalizationEditRule", "metricNormalization.update"
+	}
+
+	saved, err := s.changeMetricNormalizationRule(r.Context(), mutation, accountID, &rule)
+	if err != nil {
+		writeError(w, "Failed to save metric normalization rule", err)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    action,
+		AccountID: accountID,
+		Details:   map[string]any{"rule": saved.ID, "action": saved.Action, "matchExpression": saved.MatchExpression},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"rule": saved})
+}
+
+// Enable or disable a metric normalization rule. NerdGraph has no way to
+// delete one.
+func (s *Server) toggleMetricNormalizationRule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.Printf("Received request to %s metric normalization rule %s", vars["toggle"], vars["id"])
+
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+	ruleID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, `{"error": "Invalid rule ID"}`, http.StatusBadRequest)
+		return
+	}
+	mutation := "metricNormalizationEnableRule"
+	if vars["toggle"] == "disable" {
+		mutation = "metricNormalizationDisableRule"
+	}
+
+	rule, err := s.changeMetricNormalizationRule(r.Context(), mutation, accountID, &MetricNormalizationRule{ID: ruleID})
+	if err != nil {
+		writeError(w, "Failed to "+vars["toggle"]+" metric normalization rule", err)
+		return
+	}
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "metricNormalization." + vars["toggle"],
+		AccountID: accountID,
+		Details:   map[string]any{"rule": ruleID},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"rule": rule})
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+const defaultSchemaRefresh = 24 * time.Hour
+
+// SchemaError means a request we generate no longer fits the NerdGraph
+// schema, usually because New Relic renamed or removed something.
+type SchemaError struct {
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return "request does not match the NerdGraph schema: " + strings.Join(e.Problems, "; ")
+}
+
+// typeRef is a type as introspection describes it, with NON_NULL and LIST
+// wrapping the named type.
+type typeRef struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	OfType *typeRef `json:"ofType"`
+}
+
+// named unwraps NON_NULL and LIST down to the named type.
+func (t *typeRef) named() string {
+	for t != nil && t.Name == "" {
+		t = t.OfType
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+// element is the item type of a list type, or the type itself.
+func (t *typeRef) element() *typeRef {
+	if t != nil && t.Kind == "NON_NULL" {
+		t = t.OfType
+	}
+	if t != nil && t.Kind == "LIST" {
+		return t.OfType
+	}
+	return t
+}
+
+type schemaField struct {
+	Name string             `json:"name"`
+	Type *typeRef           `json:"type"`
+	Args []schemaInputValue `json:"args"`
+}
+
+type schemaInputValue struct {
+	Name         string   `json:"name"`
+	Type         *typeRef `json:"type"`
+	DefaultValue *string  `json:"defaultValue"`
+}
+
+type schemaType struct {
+	Kind        string             `json:"kind"`
+	Name        string             `json:"name"`
+	Fields      []schemaField      `json:"fields"`
+	InputFields []schemaInputValue `json:"inputFields"`
+	EnumValues  []schemaEnumValue  `json:"enumValues"`
+}
+
+type schemaEnumValue struct {
+	Name string `json:"name"`
+}
+
+func (t *schemaType) field(name string) *schemaField {
+	for i := range t.Fields {
+		if t.Fields[i].Name == name {
+			return &t.Fields[i]
+		}
+	}
+	return nil
+}
+
+func (t *schemaType) hasEnumValue(name string) bool {
+	return slices.ContainsFunc(t.EnumValues, func(e schemaEnumValue) bool { return e.Name == name })
+}
+
+func (t *schemaType) inputField(name string) *schemaInputValue {
+	for i := range t.InputFields {
+		if t.InputFields[i].Name == name {
+			return &t.InputFields[i]
+		}
+	}
+	return nil
+}
+
+// nerdGraphSchema is the part of an introspection result we validate
+// against.
+type nerdGraphSchema struct {
+	query    string
+	mutation string
+	types    map[string]*schemaType
+}
+
+// schemaValidator holds the most recently introspected schema and refreshes
+// it in the background once it is older than the refresh interval. Until
+// the first introspection succeeds requests are sent unchecked.
+type schemaValidator struct {
+	client  *graphql.Client
+	apiKey  *secretValue
+	refresh time.Duration
+
+	mu         sync.Mutex
+	schema     *nerdGraphSchema
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+// newSchemaValidatorFromEnv returns nil when NERDGRAPH_SCHEMA_REFRESH_HOURS
+// is 0, turning validation off.
+func newSchemaValidatorFromEnv(client *graphql.Client, apiKey *secretValue) (*schemaValidator, error) {
+	refresh := defaultSchemaRefresh
+	if v := os.Getenv("NERDGRAPH_SCHEMA_REFRESH_HOURS"); v != "" {
+		hours, err := strconv.ParseFloat(v, 64)
+		if err != nil || hours < 0 {
+			return nil, fmt.Errorf("invalid NERDGRAPH_SCHEMA_REFRESH_HOURS %q", v)
+		}
+		if hours == 0 {
+			return nil, nil
+		}
+		refresh = time.Duration(hours * float64(time.Hour))
+	}
+	v := &schemaValidator{client: client, apiKey: apiKey, refresh: refresh}
+	v.refreshing = true
+	go v.load()
+	return v, nil
+}
+
+func (v *schemaValidator) load() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	schema, err := v.fetch(ctx)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.refreshing = false
+	if err != nil {
+		// Try again on the next request rather than waiting a full interval.
+		log.Printf("Failed to introspect NerdGraph schema: %v", err)
+		return
+	}
+	v.schema = schema
+	v.fetchedAt = time.Now()
+	log.Printf("Loaded NerdGraph schema with %d types", len(schema.types))
+}
+
+func (v *schemaValidator) fetch(ctx context.Context) (*nerdGraphSchema, error) {
+	req, err := newRequest("introspection", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("API-Key", v.apiKey.Get())
+	req.Header.Set("Content-Type", "application/json")
+	var responseData struct {
+		Schema struct {
+			QueryType    struct{ Name string } `json:"queryType"`
+			MutationType struct{ Name string } `json:"mutationType"`
+			Types        []*schemaType         `json:"types"`
+		} `json:"__schema"`
+	}
+	if err := v.client.Run(withOperation(ctx, req.Name), req, &responseData); err != nil {
+		return nil, err
+	}
+	schema := &nerdGraphSchema{
+		query:    responseData.Schema.QueryType.Name,
+		mutation: responseData.Schema.MutationType.Name,
+		types:    map[string]*schemaType{},
+	}
+	for _, t := range responseData.Schema.Types {
+		schema.types[t.Name] = t
+	}
+	if len(schema.types) == 0 {
+		return nil, fmt.Errorf("introspection returned no types")
+	}
+	return schema, nil
+}
+
+// current returns the loaded schema, starting a refresh when it is stale.
+func (v *schemaValidator) current() *nerdGraphSchema {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.refreshing && (v.schema == nil || time.Since(v.fetchedAt) > v.refresh) {
+		v.refreshing = true
+		go v.load()
+	}
+	return v.schema
+}
+
+// Validate checks a request against the schema, returning a *SchemaError
+// listing everything that doesn't match.
+func (v *schemaValidator) Validate(query string, vars map[string]any) error {
+	schema := v.current()
+	if schema == nil {
+		return nil
+	}
+	// Handlers pass input objects as structs and typed slices. Their JSON
+	// form is what NerdGraph gets, so that is what is checked.
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return fmt.Errorf("encoding variables: %w", err)
+	}
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("encoding variables: %w", err)
+	}
+
+	c := &schemaCheck{schema: schema, tokens: tokenizeGraphQL(query), vars: map[string]*typeRef{}}
+	c.operation()
+	for name, value := range values {
+		if declared, ok := c.vars[name]; ok {
+			c.value("$"+name, value, declared)
+		}
+	}
+	if len(c.problems) > 0 {
+		return &SchemaError{Problems: c.problems}
+	}
+	return nil
+}
+
+// tokenizeGraphQL splits a document into names, punctuation and string
+// literals, dropping whitespace, commas and comments.
+func tokenizeGraphQL(query string) []string {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			tokens = append(tokens, string(runes[i:min(j+1, len(runes))]))
+			i = j + 1
+		case r == '.' && i+2 < len(runes) && runes[i+1] == '.' && runes[i+2] == '.':
+			tokens = append(tokens, "...")
+			i += 3
+		case r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && (runes[j] == '_' || runes[j] == '-' || runes[j] == '.' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
+
+// schemaCheck walks one of our documents alongside the schema. It only
+// understands what we send: a single operation with variables, fields with
+// arguments and inline fragments.
+type schemaCheck struct {
+	schema   *nerdGraphSchema
+	tokens   []string
+	pos      int
+	vars     map[string]*typeRef
+	problems []string
+}
+
+func (c *schemaCheck) peek() string {
+	if c.pos < len(c.tokens) {
+		return c.tokens[c.pos]
+	}
+	return ""
+}
+
+func (c *schemaCheck) next() string {
+	t := c.peek()
+	c.pos++
+	return t
+}
+
+func (c *schemaCheck) problem(format string, args ...any) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+func (c *schemaCheck) operation() {
+	root := c.schema.query
+	switch c.peek() {
+	case "mutation":
+		root = c.schema.mutation
+		c.next()
+	case "query":
+		c.next()
+	}
+	if t := c.peek(); t != "(" && t != "{" {
+		c.next() // operation name
+	}
+	if c.peek() == "(" {
+		c.next()
+		for c.pos < len(c.tokens) && c.peek() != ")" {
+			if c.next() != "$" {
+				continue
+			}
+			name := c.next()
+			c.next() // ":"
+			declared := c.typeRef()
+			if _, ok := c.schema.types[declared.named()]; !ok {
+				c.problem("variable $%s has unknown type %s", name, declared.named())
+			}
+			c.vars[name] = declared
+			if c.peek() == "=" {
+				c.next()
+				c.skipValue()
+			}
+		}
+		c.next()
+	}
+	c.selectionSet(root)
+}
+
+// typeRef parses a variable type such as [ID!]!.
+func (c *schemaCheck) typeRef() *typeRef {
+	var t *typeRef
+	if c.peek() == "[" {
+		c.next()
+		t = &typeRef{Kind: "LIST", OfType: c.typeRef()}
+		c.next() // "]"
+	} else {
+		t = &typeRef{Name: c.next()}
+	}
+	if c.peek() == "!" {
+		c.next()
+		t = &typeRef{Kind: "NON_NULL", OfType: t}
+	}
+	return t
+}
+
+// selectionSet checks the fields selected on typeName. An empty typeName
+// means the type is already known to be wrong and the fields are skipped.
+func (c *schemaCheck) selectionSet(typeName string) {
+	if c.next() != "{" {
+		return
+	}
+	t := c.schema.types[typeName]
+	for c.pos < len(c.tokens) && c.peek() != "}" {
+		if c.peek() == "..." {
+			c.next()
+			c.next() // "on"
+			fragmentType := c.next()
+			if _, ok := c.schema.types[fragmentType]; !ok {
+				c.problem("type %s no longer exists", fragmentType)
+				fragmentType = ""
+			}
+			c.selectionSet(fragmentType)
+			continue
+		}
+
+		name := c.next()
+		if c.peek() == ":" { // alias
+			c.next()
+			name = c.next()
+		}
+		var field *schemaField
+		if t != nil && !strings.HasPrefix(name, "__") {
+			if field = t.field(name); field == nil {
+				c.problem("field %s.%s no longer exists", typeName, name)
+			}
+		}
+		given := map[string]bool{}
+		if c.peek() == "(" {
+			given = c.arguments(typeName, field)
+		}
+		if field != nil {
+			for _, arg := range field.Args {
+				if arg.Type.Kind == "NON_NULL" && arg.DefaultValue == nil && !given[arg.Name] {
+					c.problem("%s.%s now requires argument %s", typeName, field.Name, arg.Name)
+				}
+			}
+		}
+		if c.peek() == "{" {
+			fieldType := ""
+			if field != nil {
+				fieldType = field.Type.named()
+			}
+			c.selectionSet(fieldType)
+		}
+	}
+	c.next()
+}
+
+// arguments checks the arguments given to field and returns their names.
+func (c *schemaCheck) arguments(typeName string, field *schemaField) map[string]bool {
+	c.next()
+	given := map[string]bool{}
+	for c.pos < len(c.tokens) && c.peek() != ")" {
+		name := c.next()
+		c.next() // ":"
+		given[name] = true
+		if field == nil {
+			c.skipValue()
+			continue
+		}
+		i := slices.IndexFunc(field.Args, func(arg schemaInputValue) bool { return arg.Name == name })
+		if i < 0 {
+			c.problem("argument %s of %s.%s no longer exists", name, typeName, field.Name)
+			c.skipValue()
+			continue
+		}
+		c.literal(field.Name+"."+name, field.Args[i].Type)
+	}
+	c.next()
+	return given
+}
+
+// literal checks an inline argument value against the type expected there.
+func (c *schemaCheck) literal(path string, expected *typeRef) {
+	switch c.peek() {
+	case "$":
+		c.next()
+		name := c.next()
+		declared, ok := c.vars[name]
+		if !ok {
+			c.problem("%s uses undeclared variable $%s", path, name)
+		} else if declared.named() != expected.named() {
+			c.problem("%s now takes %s, not %s", path, expected.named(), declared.named())
+		}
+	case "{":
+		c.next()
+		t := c.schema.types[expected.named()]
+		for c.pos < len(c.tokens) && c.peek() != "}" {
+			name := c.next()
+			c.next() // ":"
+			var field *schemaInputValue
+			if t != nil {
+				field = t.inputField(name)
+			}
+			if field == nil {
+				c.problem("input field %s.%s no longer exists", expected.named(), name)
+				c.skipValue()
+				continue
+			}
+			c.literal(path+"."+name, field.Type)
+		}
+		c.next()
+	case "[":
+		c.next()
+		for c.pos < len(c.tokens) && c.peek() != "]" {
+			c.literal(path, expected.element())
+		}
+		c.next()
+	default:
+		value := c.next()
+		t := c.schema.types[expected.named()]
+		if t != nil && t.Kind == "ENUM" && !t.hasEnumValue(value) {
+			c.problem("%s no longer accepts %s", path, value)
+		}
+	}
+}
+
+func (c *schemaCheck) skipValue() {
+	depth := 0
+	for c.pos < len(c.tokens) {
+		switch c.next() {
+		case "{", "[":
+			depth++
+		case "}", "]":
+			depth--
+		case "$":
+			c.next()
+		}
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+// value checks a variable's value against its declared type: the fields
+// of input objects must exist and required ones must be set. Scalar and
+// enum values come from callers, so NerdGraph gets to reject those itself.
+func (c *schemaCheck) value(path string, value any, expected *typeRef) {
+	if value == nil {
+		return
+	}
+	t := c.schema.types[expected.named()]
+	if t == nil {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		if t.Kind != "INPUT_OBJECT" {
+			return
+		}
+		for name, fieldValue := range v {
+			field := t.inputField(name)
+			if field == nil {
+				c.problem("input field %s.%s no longer exists", t.Name, name)
+				continue
+			}
+			c.value(path+"."+name, fieldValue, field.Type)
+		}
+		for _, field := range t.InputFields {
+			if _, ok := v[field.Name]; !ok && field.Type.Kind == "NON_NULL" && field.DefaultValue == nil {
+				c.problem("input field %s.%s is now required", t.Name, field.Name)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			c.value(path, item, expected.element())
+		}
+	}
+}
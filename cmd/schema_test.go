@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testSchema is a small slice of NerdGraph: a createKeys mutation taking a
+// list of input objects, and an enum argument on a query field.
+func testSchema() *nerdGraphSchema {
+	named := func(name string) *typeRef { return &typeRef{Name: name} }
+	required := func(t *typeRef) *typeRef { return &typeRef{Kind: "NON_NULL", OfType: t} }
+	list := func(t *typeRef) *typeRef { return &typeRef{Kind: "LIST", OfType: t} }
+	types := []*schemaType{
+		{Kind: "OBJECT", Name: "RootQueryType", Fields: []schemaField{
+			{Name: "keys", Type: list(named("Key")), Args: []schemaInputValue{{Name: "type", Type: required(named("KeyType"))}}},
+		}},
+		{Kind: "OBJECT", Name: "RootMutationType", Fields: []schemaField{
+			{Name: "createKeys", Type: named("CreateKeysResult"), Args: []schemaInputValue{{Name: "keys", Type: required(list(named("KeyInput")))}}},
+		}},
+		{Kind: "OBJECT", Name: "CreateKeysResult", Fields: []schemaField{{Name: "createdKeys", Type: list(named("Key"))}}},
+		{Kind: "OBJECT", Name: "Key", Fields: []schemaField{{Name: "id", Type: named("ID")}, {Name: "name", Type: named("String")}}},
+		{Kind: "INPUT_OBJECT", Name: "KeyInput", InputFields: []schemaInputValue{
+			{Name: "accountId", Type: required(named("Int"))},
+			{Name: "name", Type: named("String")},
+		}},
+		{Kind: "ENUM", Name: "KeyType", EnumValues: []schemaEnumValue{{Name: "INGEST"}, {Name: "USER"}}},
+		{Kind: "SCALAR", Name: "ID"},
+		{Kind: "SCALAR", Name: "Int"},
+		{Kind: "SCALAR", Name: "String"},
+	}
+	schema := &nerdGraphSchema{query: "RootQueryType", mutation: "RootMutationType", types: map[string]*schemaType{}}
+	for _, t := range types {
+		schema.types[t.Name] = t
+	}
+	return schema
+}
+
+// keyInput is a KeyInput the way handlers pass them, as a struct.
+type keyInput struct {
+	AccountID int    `json:"accountId"`
+	Name      string `json:"name,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+}
+
+func TestSchemaValidate(t *testing.T) {
+	const createKeys = `mutation createKeys($keys: [KeyInput!]!) { createKeys(keys: $keys) { createdKeys { id name } } }`
+	for _, test := range []struct {
+		name  string
+		query string
+		vars  map[string]any
+		want  []string
+	}{
+		{"valid mutation", createKeys, map[string]any{"keys": []any{map[string]any{"accountId": 1, "name": "ci"}}}, nil},
+		{"valid query", `query { keys(type: INGEST) { id ... on Key { name } } }`, nil, nil},
+		{"aliases", `mutation($k0: [KeyInput!]!) { m0: createKeys(keys: $k0) { createdKeys { id } } }`, nil, nil},
+		{"removed field", `{ keys(type: USER) { id secret } }`, nil, []string{"field Key.secret no longer exists"}},
+		{"removed enum value", `{ keys(type: LICENSE) { id } }`, nil, []string{"keys.type no longer accepts LICENSE"}},
+		{"missing argument", `{ keys { id } }`, nil, []string{"RootQueryType.keys now requires argument type"}},
+		{"removed argument", `{ keys(type: USER, first: 10) { id } }`, nil, []string{"argument first of RootQueryType.keys no longer exists"}},
+		{"removed type", `mutation($keys: [NewKeyInput!]!) { createKeys(keys: $keys) { createdKeys { id } } }`, nil, []string{
+			"variable $keys has unknown type NewKeyInput",
+			"createKeys.keys now takes KeyInput, not NewKeyInput",
+		}},
+		{"removed input field", createKeys, map[string]any{"keys": []map[string]any{{"accountId": 1, "notes": "x"}}}, []string{
+			"input field KeyInput.notes no longer exists",
+		}},
+		{"typed input", createKeys, map[string]any{"keys": []keyInput{{AccountID: 1, Name: "ci"}}}, nil},
+		{"typed input with a removed field", createKeys, map[string]any{"keys": []keyInput{{AccountID: 1, Notes: "x"}}}, []string{
+			"input field KeyInput.notes no longer exists",
+		}},
+		{"typed input missing a required field", createKeys, map[string]any{"keys": &[]struct {
+			Name string `json:"name"`
+		}{{Name: "ci"}}}, []string{
+			"input field KeyInput.accountId is now required",
+		}},
+		{"required input field", createKeys, map[string]any{"keys": []any{map[string]any{"name": "ci"}}}, []string{
+			"input field KeyInput.accountId is now required",
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			v := &schemaValidator{schema: testSchema(), fetchedAt: time.Now(), refresh: time.Hour}
+			err := v.Validate(test.query, test.vars)
+			var schemaErr *SchemaError
+			if test.want == nil {
+				if err != nil {
+					t.Errorf("Validate = %v, want no problems", err)
+				}
+				return
+			}
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("Validate = %v, want a *SchemaError", err)
+			}
+			if !slices.Equal(schemaErr.Problems, test.want) {
+				t.Errorf("problems = %q, want %q", schemaErr.Problems, test.want)
+			}
+		})
+	}
+}
+
+func TestSchemaValidateBeforeIntrospection(t *testing.T) {
+	v := &schemaValidator{refreshing: true}
+	if err := v.Validate(`{ anything }`, nil); err != nil {
+		t.Errorf("Validate without a schema = %v, want nil", err)
+	}
+}
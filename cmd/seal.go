@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix marking a sealed file so we can tell it apart from plain JSON
+// written before encryption was configured.
+const sealedPrefix = "sealed:v1:"
+
+// sealer encrypts data written to disk with AES-GCM. The data key is derived
+// from the configured master key; older master keys are kept only so data
+// written before a rotation can still be read, and it is re-sealed with the
+// current key the next time it is saved.
+type sealer struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// newSealerFromEnv builds the sealer from ENCRYPTION_MASTER_KEY and the
+// comma separated ENCRYPTION_PREVIOUS_MASTER_KEYS. It returns nil when no
+// master key is configured, in which case data is stored unencrypted.
+func newSealerFromEnv() (*sealer, error) {
+	master := os.Getenv("ENCRYPTION_MASTER_KEY")
+	if master == "" {
+		return nil, nil
+	}
+	s := &sealer{keys: map[string]cipher.AEAD{}}
+	id, err := s.addKey(master)
+	if err != nil {
+		return nil, err
+	}
+	s.currentID = id
+	for _, previous := range strings.Split(os.Getenv("ENCRYPTION_PREVIOUS_MASTER_KEYS"), ",") {
+		if previous = strings.TrimSpace(previous); previous == "" {
+			continue
+		}
+		if _, err := s.addKey(previous); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *sealer) addKey(master string) (string, error) {
+	secrets.Add(master)
+	if len(master) < 32 {
+		log.Println("Warning: encryption master key is shorter than 32 characters")
+	}
+	dataKey, err := hkdf.Key(sha256.New, []byte(master), nil, "api-go data key", 32)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(dataKey)
+	id := hex.EncodeToString(sum[:4])
+	s.keys[id] = aead
+	return id, nil
+}
+
+// Seal encrypts plaintext with the current key.
+func (s *sealer) Seal(plaintext []byte) []byte {
+	aead := s.keys[s.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	ciphertext := aead.Seal(nonce, nonce, plaintext, []byte(s.currentID))
+	return []byte(sealedPrefix + s.currentID + ":" + base64.StdEncoding.EncodeToString(ciphertext))
+}
+
+// Open decrypts data sealed with the current or any previous key.
+func (s *sealer) Open(data []byte) ([]byte, error) {
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(string(data), sealedPrefix), ":")
+	if !ok {
+		return nil, errors.New("malformed sealed data")
+	}
+	aead, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("data sealed with unknown key %s", id)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("malformed sealed data")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(id))
+}
+
+var dataSealer *sealer
+
+// dataPath returns where a persisted file lives, under DATA_DIR.
+func dataPath(name string) string {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		dir = "data"
+	}
+	return filepath.Join(dir, name)
+}
+
+// saveSealed writes v as JSON to path, encrypted when a master key is set.
+// The file is replaced atomically so a crash never leaves it half written.
+func saveSealed(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if dataSealer != nil {
+		data = dataSealer.Seal(data)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadSealed reads a file written by saveSealed into v. A missing file
+// leaves v untouched.
+func loadSealed(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(string(data), sealedPrefix) {
+		if dataSealer == nil {
+			return fmt.Errorf("%s is encrypted but ENCRYPTION_MASTER_KEY is not set", path)
+		}
+		if data, err = dataSealer.Open(data); err != nil {
+			return fmt.Errorf("decrypting %s: %w", path, err)
+		}
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useSealer sets dataSealer from the given master keys for the test.
+func useSealer(t *testing.T, master, previous string) {
+	t.Helper()
+	t.Setenv("ENCRYPTION_MASTER_KEY", master)
+	t.Setenv("ENCRYPTION_PREVIOUS_MASTER_KEYS", previous)
+	s, err := newSealerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := dataSealer
+	dataSealer = s
+	t.Cleanup(func() { dataSealer = saved })
+}
+
+func TestSealedRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	useSealer(t, "0123456789abcdef0123456789abcdef", "")
+
+	want := map[string]string{"key-1": "NRAK-SECRET"}
+	if err := saveSealed(path, want); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), sealedPrefix) || strings.Contains(string(data), "NRAK-SECRET") {
+		t.Fatalf("file is not sealed: %s", data)
+	}
+	var got map[string]string
+	if err := loadSealed(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["key-1"] != "NRAK-SECRET" {
+		t.Errorf("loaded %v, want %v", got, want)
+	}
+}
+
+func TestSealedAfterKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	useSealer(t, "old-master-key-old-master-key-00", "")
+	if err := saveSealed(path, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	useSealer(t, "new-master-key-new-master-key-00", "old-master-key-old-master-key-00")
+	var got []string
+	if err := loadSealed(path, &got); err != nil || len(got) != 1 {
+		t.Fatalf("loading with the previous key: %v, %v", got, err)
+	}
+
+	useSealer(t, "new-master-key-new-master-key-00", "")
+	if err := loadSealed(path, &got); err == nil {
+		t.Error("loaded data sealed with a key that is no longer configured")
+	}
+}
+
+func TestLoadSealedWithoutKey(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.json")
+	os.WriteFile(plain, []byte(`["a"]`), 0o600)
+
+	useSealer(t, "0123456789abcdef0123456789abcdef", "")
+	var got []string
+	if err := loadSealed(plain, &got); err != nil || len(got) != 1 {
+		t.Errorf("reading plain JSON written before encryption: %v, %v", got, err)
+	}
+	sealed := filepath.Join(dir, "sealed.json")
+	if err := saveSealed(sealed, got); err != nil {
+		t.Fatal(err)
+	}
+
+	dataSealer = nil
+	if err := loadSealed(sealed, &got); err == nil || !strings.Contains(err.Error(), "ENCRYPTION_MASTER_KEY is not set") {
+		t.Errorf("err = %v, want ENCRYPTION_MASTER_KEY is not set", err)
+	}
+	if err := loadSealed(filepath.Join(dir, "missing.json"), &got); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const defaultSearchIndexTTL = 5 * time.Minute
+
+// searchIndex caches the keys of each account, as listed by keySearch, so
+// names and notes can be searched in ways NerdGraph's exact and prefix
+// filters can't. An account is refreshed on the first search after its
+// entry goes stale.
+type searchIndex struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	accounts map[int]*indexedAccount
+}
+
+type indexedAccount struct {
+	keys        []IngestKey
+	refreshedAt time.Time
+}
+
+func newSearchIndex() (*searchIndex, error) {
+	ttl := defaultSearchIndexTTL
+	if v := os.Getenv("SEARCH_INDEX_TTL_MINUTES"); v != "" {
+		minutes, err := strconv.ParseFloat(v, 64)
+		if err != nil || minutes < 0 {
+			return nil, fmt.Errorf("invalid SEARCH_INDEX_TTL_MINUTES %q", v)
+		}
+		ttl = time.Duration(minutes * float64(time.Minute))
+	}
+	return &searchIndex{ttl: ttl, accounts: map[int]*indexedAccount{}}, nil
+}
+
+// stale returns the accounts that need refreshing before a search.
+func (x *searchIndex) stale(accountIDs []int, force bool) []int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var stale []int
+	for _, accountID := range accountIDs {
+		account, ok := x.accounts[accountID]
+		if force || !ok || time.Since(account.refreshedAt) > x.ttl {
+			stale = append(stale, accountID)
+		}
+	}
+	return stale
+}
+
+func (x *searchIndex) store(accountIDs []int, keys []IngestKey) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := time.Now()
+	for _, accountID := range accountIDs {
+		x.accounts[accountID] = &indexedAccount{refreshedAt: now}
+	}
+	for _, key := range keys {
+		if account, ok := x.accounts[key.AccountID]; ok {
+			account.keys = append(account.keys, key)
+		}
+	}
+}
+
+// Invalidate makes the next search refresh the account, after a key was
+// created in it.
+func (x *searchIndex) Invalidate(accountID int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.accounts, accountID)
+}
+
+// Remove drops a deleted key from the index.
+func (x *searchIndex) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, account := range x.accounts {
+		account.keys = slices.DeleteFunc(account.keys, func(key IngestKey) bool { return key.ID == id })
+	}
+}
+
+func (x *searchIndex) keys(accountIDs []int) []IngestKey {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var keys []IngestKey
+	for _, accountID := range accountIDs {
+		if account, ok := x.accounts[accountID]; ok {
+			keys = append(keys, account.keys...)
+		}
+	}
+	return keys
+}
+
+// SearchResult is a key matching a search, best matches first.
+type SearchResult struct {
+	ListedKey
+	Matched string `json:"matched"`
+	Score   int    `json:"score"`
+}
+
+// searchTokens splits text into lower case words.
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchKey scores a key against the query. A substring of the name beats a
+// substring of the notes; with fuzzy matching, a key whose words are all
+// within a small edit distance of the query's words scores lowest.
+func matchKey(key IngestKey, query string, fuzzy bool) (string, int) {
+	query = strings.ToLower(query)
+	switch {
+	case strings.Contains(strings.ToLower(key.Name), query):
+		return "name", 3
+	case strings.Contains(strings.ToLower(key.Notes), query):
+		return "notes", 2
+	case !fuzzy:
+		return "", 0
+	}
+	if fuzzyMatch(searchTokens(key.Name), searchTokens(query)) {
+		return "name", 1
+	}
+	if fuzzyMatch(searchTokens(key.Notes), searchTokens(query)) {
+		return "notes", 1
+	}
+	return "", 0
+}
+
+// fuzzyMatch reports whether every term is close to one of the words.
+func fuzzyMatch(words, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		allowed := 1
+		if len(term) > 5 {
+			allowed = 2
+		}
+		found := false
+		for _, word := range words {
+			if strings.Contains(word, term) || editDistance(word, term) <= allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+// refreshSearchIndex lists the stale accounts' keys with the caller's
+// credentials.
+func (s *Server) refreshSearchIndex(ctx context.Context, accountIDs []int, force bool) error {
+	stale := s.search.stale(accountIDs, force)
+	if len(stale) == 0 {
+		return nil
+	}
+	keys, err := s.searchIngestKeys(ctx, stale)
+	if err != nil {
+		return err
+	}
+	s.search.store(stale, keys)
+	log.Printf("Indexed %d keys in %d accounts for search", len(keys), len(stale))
+	return nil
+}
+
+// Search key names and notes, best matches first. Results are paged like
+// GET /keys.
+func (s *Server) searchKeys(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	log.Printf("Received request to search keys for %q", query)
+
+	accountIDs, err := parseAccountIDs(r.URL.Query().Get("account"))
+	if err != nil || len(accountIDs) == 0 || query == "" {
+		http.Error(w, `{"error": "Invalid request: q and account are required"}`, http.StatusBadRequest)
+		return
+	}
+	for _, accountID := range accountIDs {
+		if !authorizeAccount(w, r, accountID) {
+			return
+		}
+	}
+	fuzzy := r.URL.Query().Get("fuzzy") == "true"
+	// refresh only changes how fresh the index is, so a later page may
+	// leave it out.
+	page, err := parsePage(r.URL.Query(), "refresh")
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.refreshSearchIndex(r.Context(), accountIDs, r.URL.Query().Get("refresh") == "true"); err != nil {
+		writeError(w, "Failed to index keys", err)
+		return
+	}
+
+	results := []SearchResult{}
+	for _, key := range s.search.keys(accountIDs) {
+		matched, score := matchKey(key, query, fuzzy)
+		if score == 0 {
+			continue
+		}
+		result := SearchResult{ListedKey: ListedKey{IngestKey: key}, Matched: matched, Score: score}
+		if record := s.keys.Get(key.ID); record != nil {
+			result.Labels = record.Labels
+		}
+		results = append(results, result)
+	}
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		return compareSearchKeys(searchResultKey(a), searchResultKey(b))
+	})
+	results, nextCursor := paginate(results, page, searchResultKey, compareSearchKeys)
+	response := map[string]any{"keys": results}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// searchResultKey is the sort key of a search result: its score, name and
+// ID.
+func searchResultKey(result SearchResult) []string {
+	return []string{strconv.Itoa(result.Score), result.Name, result.ID}
+}
+
+// compareSearchKeys orders search results best match first, then by name.
+func compareSearchKeys(a, b []string) int {
+	scoreA, _ := strconv.Atoi(a[0])
+	scoreB, _ := strconv.Atoi(b[0])
+	if scoreA != scoreB {
+		return scoreB - scoreA
+	}
+	return slices.Compare(a[1:], b[1:])
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSecretReloadInterval = 30 * time.Second
+
+// secretValue is a credential given directly or read from a file, such as
+// a mounted Kubernetes secret. File-backed values are re-read by
+// watchSecrets, so rotating the secret doesn't need a restart.
+type secretValue struct {
+	name string
+	path string
+
+	mu    sync.RWMutex
+	value string
+}
+
+// newSecretValue uses the file at path when one is given, and value
+// otherwise. name describes the secret in errors and logs.
+func newSecretValue(name, value, path string) (*secretValue, error) {
+	v := &secretValue{name: name, path: path, value: value}
+	if path != "" {
+		if _, err := v.reload(); err != nil {
+			return nil, err
+		}
+	}
+	if v.Get() == "" {
+		return nil, fmt.Errorf("%s is empty", name)
+	}
+	secrets.Add(v.Get())
+	return v, nil
+}
+
+// secretFromEnv reads the secret from the file named by <env>_FILE, or
+// from <env> itself.
+func secretFromEnv(env string) (*secretValue, error) {
+	if path := os.Getenv(env + "_FILE"); path != "" {
+		return newSecretValue(env+"_FILE", "", path)
+	}
+	return newSecretValue(env, os.Getenv(env), "")
+}
+
+// Get returns the current value.
+func (v *secretValue) Get() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.value
+}
+
+// reload re-reads a file-backed secret and reports whether it changed. An
+// empty file is treated as an error, since secret mounts can briefly be
+// empty while they are swapped, and the old value is kept.
+func (v *secretValue) reload() (bool, error) {
+	if v.path == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", v.name, err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return false, fmt.Errorf("%s at %s is empty", v.name, v.path)
+	}
+	secrets.Add(value)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if value == v.value {
+		return false, nil
+	}
+	v.value = value
+	return true, nil
+}
+
+// secretReloadInterval is how often file-backed secrets are re-read.
+func secretReloadInterval() (time.Duration, error) {
+	v := os.Getenv("SECRET_RELOAD_INTERVAL_SECONDS")
+	if v == "" {
+		return defaultSecretReloadInterval, nil
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid SECRET_RELOAD_INTERVAL_SECONDS %q", v)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// watchSecrets re-reads the file-backed values every interval until ctx is
+// done, calling onChange after any of them changed. Every replica runs it,
+// unlike the leader-only background jobs.
+func watchSecrets(ctx context.Context, interval time.Duration, values []*secretValue, onChange func()) {
+	var watched []*secretValue
+	for _, v := range values {
+		if v.path != "" {
+			watched = append(watched, v)
+		}
+	}
+	if len(watched) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed := false
+		for _, v := range watched {
+			ok, err := v.reload()
+			if err != nil {
+				log.Printf("Failed to reload %s, keeping the previous value: %v", v.name, err)
+				continue
+			}
+			if ok {
+				log.Printf("Reloaded %s", v.name)
+				changed = true
+			}
+		}
+		if changed && onChange != nil {
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownHook is one step of a clean shutdown, such as draining the job
+// queue or syncing the audit log.
+type shutdownHook struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// shutdownHooks run in the order they were added, so later hooks can rely
+// on earlier ones having stopped the work that feeds them: requests stop
+// first, then the jobs they queued, then the logs both of them write to.
+type shutdownHooks struct {
+	hooks []shutdownHook
+}
+
+// Add appends a hook to run after those already added.
+func (h *shutdownHooks) Add(name string, run func(ctx context.Context) error) {
+	h.hooks = append(h.hooks, shutdownHook{name: name, run: run})
+}
+
+// Run runs every hook, even after one fails or ctx expires, so that quick
+// steps such as syncing logs still get their chance. It returns the first
+// error.
+func (h *shutdownHooks) Run(ctx context.Context) error {
+	var first error
+	for _, hook := range h.hooks {
+		start := time.Now()
+		if err := hook.run(ctx); err != nil {
+			log.Printf("Shutdown: %s failed after %s: %v", hook.name, time.Since(start).Round(time.Millisecond), err)
+			if first == nil {
+				first = fmt.Errorf("%s: %w", hook.name, err)
+			}
+			continue
+		}
+		log.Printf("Shutdown: %s done in %s", hook.name, time.Since(start).Round(time.Millisecond))
+	}
+	return first
+}
+
+// shutdownTimeout bounds how long the hooks may take in total. It should
+// stay below the grace period the process gets before being killed.
+func shutdownTimeout() (time.Duration, error) {
+	v := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS")
+	if v == "" {
+		return defaultShutdownTimeout, nil
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid SHUTDOWN_TIMEOUT_SECONDS %q", v)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// waitDone waits for done to be closed or ctx to expire.
+func waitDone(ctx context.Context, done <-chan struct{}, what string) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for %s: %w", what, ctx.Err())
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// scheduledDelete is the payload of a delayed "delete" job. Only a hash of
+// the cancellation token is kept.
+type scheduledDelete struct {
+	ID        string `json:"id"`
+	TokenHash string `json:"tokenHash"`
+}
+
+func deleteGraceFromEnv() (time.Duration, error) {
+	v := os.Getenv("DELETE_GRACE_HOURS")
+	if v == "" {
+		return 0, nil
+	}
+	hours, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	if hours < 0 {
+		return 0, fmt.Errorf("%s is negative", v)
+	}
+	return time.Duration(hours * float64(time.Hour)), nil
+}
+
+// deleteKey deletes an ingest key straight away.
+func (s *Server) deleteKey(ctx context.Context, id string) error {
+	unlock, ok, err := s.locks.TryLock(ctx, "key/"+id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errLocked
+	}
+	defer unlock()
+
+	// The audit entry needs the key's account, which can't be looked up
+	// once the key is gone.
+	accountID, err := s.keyAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	responseData, err := s.deleteIngestKeys(ctx, []string{id})
+	if err != nil {
+		return err
+	}
+	if len(responseData.ApiAccessDeleteKeys.Errors) > 0 {
+		return KeyErrors(responseData.ApiAccessDeleteKeys.Errors)
+	}
+	log.Printf("Successfully deleted key %s", id)
+	s.audit.Record(ctx, AuditEntry{Action: "key.delete", AccountID: accountID, KeyID: id})
+	return nil
+}
+
+// keyAccount returns the account a key belongs to, from the registry when
+// the key is in it and from NerdGraph otherwise.
+func (s *Server) keyAccount(ctx context.Context, id string) (int, error) {
+	if record := s.keys.Get(id); record != nil && record.AccountID != 0 {
+		return record.AccountID, nil
+	}
+	key, err := s.getIngestKey(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return key.AccountID, nil
+}
+
+func (s *Server) registerDeleteJobs() {
+	s.jobs.Register("delete", func(ctx context.Context, job *Job) (any, error) {
+		var request scheduledDelete
+		if err := json.Unmarshal(job.Payload, &request); err != nil {
+			return nil, err
+		}
+		if err := s.deleteKey(ctx, request.ID); err != nil {
+			return nil, err
+		}
+		return map[string]string{"deleted_key": request.ID}, nil
+	})
+}
+
+// pendingDelete finds the scheduled deletion of a key, if there is one.
+func (s *Server) pendingDelete(id string) (*Job, scheduledDelete) {
+	var request scheduledDelete
+	job := s.jobs.Find(func(job *Job) bool {
+		if job.Kind != "delete" || job.Status != jobQueued {
+			return false
+		}
+		var payload scheduledDelete
+		return json.Unmarshal(job.Payload, &payload) == nil && payload.ID == id
+	})
+	if job != nil {
+		json.Unmarshal(job.Payload, &request)
+	}
+	return job, request
+}
+
+// scheduleDelete queues the deletion of a key after grace, returning the job
+// and the token that cancels it.
+func (s *Server) scheduleDelete(ctx context.Context, id string, grace time.Duration) (*Job, string, error) {
+	token := newID()
+	hash := sha256.Sum256([]byte(token))
+	job, err := s.jobs.EnqueueAt(ctx, "delete", scheduledDelete{
+		ID:        id,
+		TokenHash: hex.EncodeToString(hash[:]),
+	}, time.Now().Add(grace))
+	if err != nil {
+		return nil, "", err
+	}
+	log.Printf("Scheduled deletion of key %s at %s", id, job.RunAt.Format(time.RFC3339))
+	return job, token, nil
+}
+
+// deleteKeyWithGrace deletes the key now, or schedules the deletion when a
+// grace period applies. The grace_hours query parameter overrides the
+// configured default for one request.
+func (s *Server) deleteKeyWithGrace(w http.ResponseWriter, r *http.Request, id string) {
+	grace := s.deleteGrace
+	if v := r.URL.Query().Get("grace_hours"); v != "" {
+		hours, err := strconv.ParseFloat(v, 64)
+		if err != nil || hours < 0 {
+			http.Error(w, `{"error": "Invalid grace_hours"}`, http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(hours * float64(time.Hour))
+	}
+
+	if s.requireApproval(w, r, "delete", id, grace) {
+		return
+	}
+	if !s.authorizeKey(w, r, id) {
+		return
+	}
+
+	if grace == 0 {
+		if err := s.deleteKey(r.Context(), id); err != nil {
+			writeError(w, "Failed to delete key", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"deleted_key": id,
+		})
+		return
+	}
+
+	if job, _ := s.pendingDelete(id); job != nil {
+		log.Printf("Deletion of key %s is already scheduled, Status Code: %d", id, http.StatusConflict)
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":     "Deletion is already scheduled",
+			"delete_at": job.RunAt,
+		})
+		return
+	}
+
+	job, token, err := s.scheduleDelete(r.Context(), id, grace)
+	if err != nil {
+		log.Printf("Failed to schedule deletion: %v, Status Code: %d", err, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to schedule deletion"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"scheduled_key":      id,
+		"delete_at":          job.RunAt,
+		"cancellation_token": token,
+		"job":                job,
+	})
+}
+
+// Delete an API key by ID
+func (s *Server) deleteKeyByID(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to delete key %s", id)
+	s.deleteKeyWithGrace(w, r, id)
+}
+
+// Cancel a scheduled deletion
+func (s *Server) undeleteKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to undelete key %s", id)
+
+	var request struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, `{"error": "Invalid request: token is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	job, pending := s.pendingDelete(id)
+	if job == nil {
+		http.Error(w, `{"error": "No deletion is scheduled for this key"}`, http.StatusNotFound)
+		return
+	}
+	hash := sha256.Sum256([]byte(request.Token))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(pending.TokenHash)) != 1 {
+		log.Printf("Invalid cancellation token for key %s, Status Code: %d", id, http.StatusForbidden)
+		http.Error(w, `{"error": "Invalid cancellation token"}`, http.StatusForbidden)
+		return
+	}
+
+	cancelled, err := s.jobs.Cancel(job.ID)
+	if err != nil {
+		log.Printf("Failed to cancel job %s: %v", job.ID, err)
+	}
+	if !cancelled {
+		http.Error(w, `{"error": "Deletion has already started"}`, http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"undeleted_key": id,
+	})
+}
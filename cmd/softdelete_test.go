@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+	"github.com/gorilla/mux"
+)
+
+// keyServer is a server whose NerdGraph knows ingest key key-1 in account
+// 1 and deletes it when asked, counting the deletes.
+type keyServer struct {
+	*Server
+	router  *mux.Router
+	deletes atomic.Int32
+}
+
+func newKeyServer(t *testing.T) *keyServer {
+	t.Helper()
+	ks := &keyServer{}
+	nerdGraph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Query string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if strings.Contains(body.Query, "apiAccessDeleteKeys") {
+			ks.deletes.Add(1)
+			w.Write([]byte(`{"data": {"apiAccessDeleteKeys": {"deletedKeys": [{"id": "key-1"}], "errors": []}}}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"actor": {"apiAccess": {"key": {"id": "key-1", "name": "payments", "accountId": 1, "ingestType": "LICENSE"}}}}}`))
+	}))
+	t.Cleanup(nerdGraph.Close)
+
+	dir := t.TempDir()
+	apiKey, _ := newSecretValue("NEW_RELIC_API_KEY", "NRAK-TESTTESTTEST", "")
+	audit, _ := newAuditLog(filepath.Join(dir, "audit.jsonl"))
+	keys, _ := newKeyRegistry(filepath.Join(dir, "registry.json"))
+	jobs, _ := newJobQueue(filepath.Join(dir, "jobs.json"), &authenticator{})
+	search, _ := newSearchIndex()
+	ks.Server = &Server{
+		client: graphql.NewClient(nerdGraph.URL),
+		apiKey: apiKey,
+		locks:  &localLocker{held: map[string]bool{}},
+		auth:   &authenticator{},
+		jobs:   jobs,
+		audit:  audit,
+		keys:   keys,
+		search: search,
+	}
+	ks.registerDeleteJobs()
+	ks.router = mux.NewRouter()
+	ks.router.HandleFunc("/keys/{id}", ks.deleteKeyByID).Methods("DELETE")
+	ks.router.HandleFunc("/keys/{id}/undelete", ks.undeleteKey).Methods("POST")
+	return ks
+}
+
+// do sends a request as caller, or as nobody when caller is nil, and
+// decodes the JSON answer into a map.
+func (ks *keyServer) do(caller *Caller, method, path, body string) (int, map[string]any) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if caller != nil {
+		r = r.WithContext(context.WithValue(r.Context(), callerKey{}, caller))
+	}
+	w := httptest.NewRecorder()
+	ks.router.ServeHTTP(w, r)
+	var answer map[string]any
+	json.Unmarshal(w.Body.Bytes(), &answer)
+	return w.Code, answer
+}
+
+// runScheduled runs the queued jobs as though their time had come.
+func (ks *keyServer) runScheduled() {
+	ks.jobs.mu.Lock()
+	for _, job := range ks.jobs.jobs {
+		job.RunAt = time.Now()
+	}
+	ks.jobs.mu.Unlock()
+	ks.jobs.RunDue(context.Background())
+}
+
+func TestDeleteGraceFromEnv(t *testing.T) {
+	for _, test := range []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"24", 24 * time.Hour, false},
+		{"0.5", 30 * time.Minute, false},
+		{"-1", 0, true},
+		{"a day", 0, true},
+	} {
+		t.Setenv("DELETE_GRACE_HOURS", test.value)
+		got, err := deleteGraceFromEnv()
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("DELETE_GRACE_HOURS=%q: %s, %v; want %s, error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestDeleteWithoutGrace(t *testing.T) {
+	ks := newKeyServer(t)
+	if status, answer := ks.do(nil, http.MethodDelete, "/keys/key-1", ""); status != http.StatusOK || answer["deleted_key"] != "key-1" {
+		t.Fatalf("DELETE answered %d: %v", status, answer)
+	}
+	if got := ks.deletes.Load(); got != 1 {
+		t.Errorf("NerdGraph got %d deletes, want 1", got)
+	}
+	entries, _ := ks.audit.Entries(func(entry *AuditEntry) bool { return entry.Action == "key.delete" })
+	if len(entries) != 1 || entries[0].AccountID != 1 {
+		t.Errorf("audit entries = %+v, want one key.delete in account 1", entries)
+	}
+}
+
+func TestDeleteWithGrace(t *testing.T) {
+	ks := newKeyServer(t)
+	ks.deleteGrace = time.Hour
+
+	status, answer := ks.do(nil, http.MethodDelete, "/keys/key-1", "")
+	if status != http.StatusAccepted {
+		t.Fatalf("DELETE answered %d: %v", status, answer)
+	}
+	token, _ := answer["cancellation_token"].(string)
+	if deleteAt, _ := time.Parse(time.RFC3339, answer["delete_at"].(string)); time.Until(deleteAt) < 59*time.Minute {
+		t.Errorf("delete_at = %v, want an hour from now", answer["delete_at"])
+	}
+
+	for _, step := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"scheduled again", http.MethodDelete, "/keys/key-1", "", http.StatusConflict},
+		{"undelete without a token", http.MethodPost, "/keys/key-1/undelete", `{}`, http.StatusBadRequest},
+		{"undelete with another token", http.MethodPost, "/keys/key-1/undelete", `{"token": "guess"}`, http.StatusForbidden},
+		{"undelete", http.MethodPost, "/keys/key-1/undelete", `{"token": "` + token + `"}`, http.StatusOK},
+		{"undelete twice", http.MethodPost, "/keys/key-1/undelete", `{"token": "` + token + `"}`, http.StatusNotFound},
+	} {
+		if status, answer := ks.do(nil, step.method, step.path, step.body); status != step.want {
+			t.Fatalf("%s: answered %d, want %d: %v", step.name, status, step.want, answer)
+		}
+	}
+	ks.runScheduled()
+	if got := ks.deletes.Load(); got != 0 {
+		t.Errorf("an undeleted key was deleted %d times", got)
+	}
+
+	// Without an undelete the key goes once its time has come, and not
+	// before.
+	if status, _ := ks.do(nil, http.MethodDelete, "/keys/key-1?grace_hours=2", ""); status != http.StatusAccepted {
+		t.Fatalf("DELETE answered %d", status)
+	}
+	ks.jobs.RunDue(context.Background())
+	if got := ks.deletes.Load(); got != 0 {
+		t.Fatalf("key deleted %d times before its grace period ended", got)
+	}
+	ks.runScheduled()
+	if got := ks.deletes.Load(); got != 1 {
+		t.Errorf("NerdGraph got %d deletes, want 1", got)
+	}
+}
+
+func TestDeleteInvalidGrace(t *testing.T) {
+	ks := newKeyServer(t)
+	if status, _ := ks.do(nil, http.MethodDelete, "/keys/key-1?grace_hours=-1", ""); status != http.StatusBadRequest {
+		t.Errorf("negative grace_hours answered %d, want 400", status)
+	}
+}
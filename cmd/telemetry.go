@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequestDuration = newHistogramVec("http_request_duration_seconds",
+		"Time taken to answer requests to the proxy.", "method", "code")
+
+	nerdGraphDuration = newHistogramVec("nerdgraph_request_duration_seconds",
+		"Time taken by NerdGraph to answer each operation, by HTTP status or \"error\" when no response came back.", "operation", "code")
+	nerdGraphErrors = newCounterVec("nerdgraph_errors_total",
+		"Errors NerdGraph reported, by errorClass of GraphQL errors or errorType of mutation errors.", "operation", "error_type")
+	nerdGraphRateLimitRejections = newCounterVec("nerdgraph_rate_limit_rejections_total",
+		"Operations refused locally because NerdGraph's rate limit had not reset yet.", "operation")
+	nerdGraphRateLimit = newGaugeVec("nerdgraph_rate_limit",
+		"Rate limit budget from the last NerdGraph response that advertised one.", "budget")
+)
+
+type operationKey struct{}
+
+// withOperation names the NerdGraph operation made with ctx in metrics.
+func withOperation(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, operationKey{}, name)
+}
+
+func operationFromContext(ctx context.Context) string {
+	if name, _ := ctx.Value(operationKey{}).(string); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// metricsMiddleware times every request to the proxy.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), r.Method, strconv.Itoa(rw.status))
+	})
+}
+
+// telemetryTransport records the latency and errors of every round trip to
+// NerdGraph, so upstream health can be told apart from the proxy's own.
+type telemetryTransport struct {
+	next http.RoundTripper
+}
+
+func (t telemetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation := operationFromContext(req.Context())
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Seconds()
+	if err != nil {
+		nerdGraphDuration.Observe(elapsed, operation, "error")
+		nerdGraphErrors.Inc(operation, "TRANSPORT")
+		return nil, err
+	}
+	nerdGraphDuration.Observe(elapsed, operation, strconv.Itoa(res.StatusCode))
+	for header, budget := range map[string]string{"X-RateLimit-Limit": "limit", "X-RateLimit-Remaining": "remaining"} {
+		if value, err := strconv.ParseFloat(res.Header.Get(header), 64); err == nil {
+			nerdGraphRateLimit.Set(value, budget)
+		}
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		nerdGraphErrors.Inc(operation, "TRANSPORT")
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	errorTypes := responseErrorTypes(body)
+	if len(errorTypes) == 0 && res.StatusCode >= 400 {
+		errorTypes = []string{"HTTP_" + strconv.Itoa(res.StatusCode)}
+	}
+	for _, errorType := range errorTypes {
+		nerdGraphErrors.Inc(operation, errorType)
+	}
+	return res, nil
+}
+
+// responseErrorTypes lists the errorClass of each GraphQL error in a
+// response and the errorType of each error in its data, which is where
+// mutations such as apiAccessCreateKeys report per-item failures.
+func responseErrorTypes(body []byte) []string {
+	var response struct {
+		Data   any `json:"data"`
+		Errors []struct {
+			Extensions struct {
+				ErrorClass string `json:"errorClass"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	var types []string
+	for _, e := range response.Errors {
+		errorClass := e.Extensions.ErrorClass
+		if errorClass == "" {
+			errorClass = "GRAPHQL"
+		}
+		types = append(types, errorClass)
+	}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if errorType, ok := v["errorType"].(string); ok && errorType != "" {
+				types = append(types, errorType)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(response.Data)
+	return types
+}
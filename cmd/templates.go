@@ -0,0 +1,106 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+// The GraphQL documents sent to NerdGraph. Each file is a text/template
+// named after the file without its extension, so documents can share a
+// selection with {{template "mutingRuleFields"}}.
+//
+//go:embed graphql/*.graphql
+var builtinDocuments embed.FS
+
+// documents is the built-in set with any overrides applied.
+var documents = template.Must(parseDocuments(template.New(""), builtinDocuments, "graphql"))
+
+// batchDocument is the template data for documents that run a mutation
+// once per item, each under its own alias.
+type batchDocument struct {
+	Items []batchItem
+}
+
+type batchItem struct {
+	Alias    string
+	Variable string
+}
+
+// keySearchDocument is the template data for searchIngestKeys. Values asks
+// for the key values, which only key validation needs, in place of notes.
+type keySearchDocument struct {
+	Values bool
+}
+
+// mutationDocument is the template data for documents whose mutation is
+// picked at run time.
+type mutationDocument struct {
+	Mutation  string
+	InputType string
+}
+
+// parseDocuments adds every .graphql file in dir to set, replacing any
+// document of the same name.
+func parseDocuments(set *template.Template, fsys fs.FS, dir string) (*template.Template, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.graphql"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		text, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(path.Base(file), ".graphql")
+		if _, err := set.New(name).Parse(string(text)); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+	}
+	return set, nil
+}
+
+// loadDocumentOverrides replaces built-in documents with the files in
+// GRAPHQL_TEMPLATE_DIR, so requested fields can be changed without a new
+// build. It returns the names of the documents overridden.
+func loadDocumentOverrides() ([]string, error) {
+	dir := os.Getenv("GRAPHQL_TEMPLATE_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	set, err := documents.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseDocuments(set, os.DirFS(dir), "."); err != nil {
+		return nil, fmt.Errorf("loading templates from %s: %w", dir, err)
+	}
+	var overridden []string
+	files, _ := fs.Glob(os.DirFS(dir), "*.graphql")
+	for _, file := range files {
+		name := strings.TrimSuffix(file, ".graphql")
+		if documents.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s in %s does not override a known document", file, dir)
+		}
+		overridden = append(overridden, name)
+	}
+	documents = set
+	return overridden, nil
+}
+
+// newRequest renders the named document with data into a request.
+func newRequest(name string, data any) (*graphql.Request, error) {
+	var query strings.Builder
+	if err := documents.ExecuteTemplate(&query, name, data); err != nil {
+		return nil, fmt.Errorf("rendering GraphQL document %s: %w", name, err)
+	}
+	req := graphql.NewRequest(query.String())
+	req.Name = name
+	return req, nil
+}
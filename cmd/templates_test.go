@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchIngestKeysDocument(t *testing.T) {
+	for _, test := range []struct {
+		data     keySearchDocument
+		want     string
+		wantNone string
+	}{
+		{keySearchDocument{}, "notes", "key\n"},
+		{keySearchDocument{Values: true}, "key\n", "notes"},
+	} {
+		req, err := newRequest("searchIngestKeys", test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(req.Query, test.want) || strings.Contains(req.Query, test.wantNone) {
+			t.Errorf("searchIngestKeys with %+v:\n%s\nwant %q and not %q", test.data, req.Query, test.want, test.wantNone)
+		}
+	}
+}
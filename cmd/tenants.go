@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/chinelo-obitube/api-go/internal/graphql"
+)
+
+// Credential is a New Relic key together with the client for its region.
+type Credential struct {
+	Name   string
+	APIKey *secretValue
+	Client *graphql.Client
+}
+
+// Tenant is an isolated New Relic credential that callers are routed to,
+// together with the accounts it may be used against.
+type Tenant struct {
+	Credential
+	Accounts []int
+}
+
+// CanAccess reports whether the tenant may be used against the account. A
+// tenant with no accounts listed is not restricted.
+func (t *Tenant) CanAccess(accountID int) bool {
+	return len(t.Accounts) == 0 || slices.Contains(t.Accounts, accountID)
+}
+
+func newCredential(name, what string, cc CredentialConfig) (*Credential, error) {
+	if cc.APIKey == "" && cc.APIKeyFile == "" {
+		return nil, fmt.Errorf("%s has no apiKey or apiKeyFile", what)
+	}
+	apiKey, err := newSecretValue("apiKey of "+what, cc.APIKey, cc.APIKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	client, err := GetClient(cc.Region)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", what, err)
+	}
+	return &Credential{Name: name, APIKey: apiKey, Client: client}, nil
+}
+
+func buildTenants(config *Config) (map[string]*Tenant, error) {
+	tenants := map[string]*Tenant{}
+	for name, tc := range config.Tenants {
+		credential, err := newCredential(name, "tenant "+name, tc.CredentialConfig)
+		if err != nil {
+			return nil, err
+		}
+		tenants[name] = &Tenant{Credential: *credential, Accounts: tc.Accounts}
+	}
+	return tenants, nil
+}
+
+func buildCredentials(config *Config) (map[string]*Credential, error) {
+	credentials := map[string]*Credential{}
+	for name, cc := range config.Credentials {
+		credential, err := newCredential(name, "credential "+name, cc)
+		if err != nil {
+			return nil, err
+		}
+		credentials[name] = credential
+	}
+	return credentials, nil
+}
+
+type credentialKey struct{}
+
+// credentialFromContext returns the named credential a request selected,
+// or nil when it didn't select one.
+func credentialFromContext(ctx context.Context) *Credential {
+	credential, _ := ctx.Value(credentialKey{}).(*Credential)
+	return credential
+}
+
+// withCredential selects the named credential for NerdGraph calls made
+// with ctx.
+func withCredential(ctx context.Context, credential *Credential) context.Context {
+	return context.WithValue(ctx, credentialKey{}, credential)
+}
+
+// credentialMiddleware lets a request pick one of the named credentials
+// with the X-New-Relic-Credential header or a top-level "credential" field
+// in its JSON body. Callers bound to a tenant always use the tenant's key.
+func (a *authenticator) CredentialMiddleware(next http.Handler) http.Handler {
+	if len(a.credentials) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get("X-New-Relic-Credential")
+		if name == "" {
+			var err error
+			if name, err = credentialFromBody(r); err != nil {
+				http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		credential, ok := a.credentials[name]
+		if !ok {
+			log.Printf("Rejected request for unknown credential %s, Status Code: %d", name, http.StatusBadRequest)
+			http.Error(w, `{"error": "Unknown credential"}`, http.StatusBadRequest)
+			return
+		}
+		if caller := callerFromContext(r.Context()); caller != nil && !caller.CanUseCredential(name) {
+			log.Printf("Caller %s denied credential %s, Status Code: %d", caller.Name, name, http.StatusForbidden)
+			http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withCredential(r.Context(), credential)))
+	})
+}
+
+// credentialFromBody reads the "credential" field of a JSON body and puts
+// the body back for the handler. Bodies that aren't JSON objects select
+// nothing.
+func credentialFromBody(r *http.Request) (string, error) {
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return "", nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var fields struct {
+		Credential string `json:"credential"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", nil
+	}
+	return fields.Credential, nil
+}
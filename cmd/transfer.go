@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ownerPrefix marks the line of a key's notes that names its owning team.
+const ownerPrefix = "owner:"
+
+// TransferRequest hands a key to another team.
+type TransferRequest struct {
+	Owner string `json:"owner"`
+}
+
+// ownerFromNotes returns the team named on the owner line of notes.
+func ownerFromNotes(notes string) string {
+	for _, line := range strings.Split(untracedNotes(notes), "\n") {
+		if len(line) >= len(ownerPrefix) && strings.EqualFold(line[:len(ownerPrefix)], ownerPrefix) {
+			return strings.TrimSpace(line[len(ownerPrefix):])
+		}
+	}
+	return ""
+}
+
+// notesWithOwner replaces the owner line of notes, or appends one. The
+// trace TRACE_IN_NOTES added is dropped, for the caller to trace the
+// transfer instead.
+func notesWithOwner(notes, owner string) string {
+	notes = untracedNotes(notes)
+	lines := strings.Split(notes, "\n")
+	for i, line := range lines {
+		if len(line) >= len(ownerPrefix) && strings.EqualFold(line[:len(ownerPrefix)], ownerPrefix) {
+			lines[i] = ownerPrefix + " " + owner
+			return strings.Join(lines, "\n")
+		}
+	}
+	if strings.TrimSpace(notes) == "" {
+		return ownerPrefix + " " + owner
+	}
+	return strings.TrimRight(notes, "\n") + "\n" + ownerPrefix + " " + owner
+}
+
+// Transfer a key to another owning team
+func (s *Server) transferKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log.Printf("Received request to transfer key %s", id)
+
+	var request TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON request body"}`, http.StatusBadRequest)
+		return
+	}
+	request.Owner = strings.TrimSpace(request.Owner)
+	if request.Owner == "" || strings.Contains(request.Owner, "\n") {
+		http.Error(w, `{"error": "Invalid request: owner is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	unlock, ok := s.lock(w, r, "key/"+id)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	key, err := s.getIngestKey(r.Context(), id)
+	if err != nil {
+		writeError(w, "Failed to look up key", err)
+		return
+	}
+	if !authorizeAccount(w, r, key.AccountID) {
+		return
+	}
+
+	previous := ownerFromNotes(key.Notes)
+	if record := s.keys.Get(id); record != nil && record.Owner != "" {
+		previous = record.Owner
+	}
+	notes := traceNotes(r.Context(), notesWithOwner(key.Notes, request.Owner))
+	if err := s.updateIngestKeyNotes(r.Context(), id, notes); err != nil {
+		writeError(w, "Failed to update key notes", err)
+		return
+	}
+	s.search.Invalidate(key.AccountID)
+	record := s.keys.Update(KeyRecord{ID: key.ID, AccountID: key.AccountID, Name: key.Name}, func(record *KeyRecord) {
+		record.Owner = request.Owner
+	})
+	log.Printf("Transferred key %s from %q to %q", id, previous, request.Owner)
+
+	s.audit.Record(r.Context(), AuditEntry{
+		Action:    "key.transfer",
+		AccountID: key.AccountID,
+		KeyID:     id,
+		Details:   map[string]any{"from": previous, "to": request.Owner},
+	})
+	s.notify(r.Context(), WebhookEvent{
+		Type:      "key.transfer",
+		AccountID: key.AccountID,
+		KeyID:     id,
+		Data:      map[string]any{"name": key.Name, "from": previous, "to": request.Owner},
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"key": record, "notes": notes})
+}
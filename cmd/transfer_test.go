@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNotesWithOwner(t *testing.T) {
+	t.Setenv("TRACE_IN_NOTES", "true")
+	ctx := withRequestID(context.Background(), "req-1")
+	for _, test := range []struct {
+		name  string
+		notes string
+		want  string
+	}{
+		{"empty", "", "owner: checkout"},
+		{"no owner line", "payments", "payments\nowner: checkout"},
+		{"owner line", "payments\nowner: billing", "payments\nowner: checkout"},
+		{"traced", traceNotes(ctx, "payments"), "payments\nowner: checkout"},
+		{"traced owner line", traceNotes(ctx, "payments\nowner: billing"), "payments\nowner: checkout"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := notesWithOwner(test.notes, "checkout"); got != test.want {
+				t.Errorf("notesWithOwner(%q) = %q, want %q", test.notes, got, test.want)
+			}
+			// Transferring again finds the owner and traces only once.
+			traced := traceNotes(ctx, notesWithOwner(test.notes, "checkout"))
+			if got := ownerFromNotes(traced); got != "checkout" {
+				t.Errorf("ownerFromNotes(%q) = %q, want checkout", traced, got)
+			}
+			if got := traceNotes(ctx, notesWithOwner(traced, "checkout")); got != traced {
+				t.Errorf("second transfer gave %q, want %q", got, traced)
+			}
+		})
+	}
+}
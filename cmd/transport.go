@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultMaxIdleConnsPerHost replaces net/http's default of 2, which makes
+// bulk jobs and imports open a new connection for nearly every request to
+// NerdGraph.
+const defaultMaxIdleConnsPerHost = 32
+
+// upstreamTransport is shared by every NerdGraph client, so tenants on the
+// same region share one connection pool.
+var upstreamTransport http.RoundTripper = http.DefaultTransport
+
+// TransportConfig tunes the connections to NerdGraph. Zero values keep the
+// defaults.
+type TransportConfig struct {
+	MaxIdleConns        int      `json:"maxIdleConns"`
+	MaxIdleConnsPerHost int      `json:"maxIdleConnsPerHost"`
+	MaxConnsPerHost     int      `json:"maxConnsPerHost"`
+	IdleConnTimeout     duration `json:"idleConnTimeout"`
+	TLSHandshakeTimeout duration `json:"tlsHandshakeTimeout"`
+	// HTTP2 turns HTTP/2 off when false; it is on by default.
+	HTTP2 *bool `json:"http2"`
+}
+
+// duration is a time.Duration written as a string such as "90s" in JSON.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations are strings such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// build returns the transport for the settings, starting from net/http's
+// default transport.
+func (c TransportConfig) build() (*http.Transport, error) {
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0 || c.IdleConnTimeout < 0 || c.TLSHandshakeTimeout < 0 {
+		return nil, fmt.Errorf("transport settings can't be negative")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = c.MaxConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(c.IdleConnTimeout)
+	}
+	if c.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(c.TLSHandshakeTimeout)
+	}
+	if c.HTTP2 != nil && !*c.HTTP2 {
+		// A non-nil, empty TLSNextProto is how net/http is told not to
+		// negotiate HTTP/2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport, nil
+}
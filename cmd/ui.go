@@ -0,0 +1,26 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The admin UI: a single page that calls the API with the session cookie
+// or a token the user pastes in.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the UI under /ui/. The files are static and public; what
+// the page can do is decided by the API calls it makes.
+func uiHandler() http.Handler {
+	files, _ := fs.Sub(uiFiles, "ui")
+	fileServer := http.StripPrefix("/ui/", http.FileServerFS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		fileServer.ServeHTTP(w, r)
+	})
+}
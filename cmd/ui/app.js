@@ -0,0 +1,262 @@
+"use strict";
+
+// The token is kept for the browser tab only. Without one, requests rely on
+// the session cookie set by signing in.
+const tokenKey = "proxy-token";
+let keys = [];
+let auditCursor = "";
+
+const $ = (id) => document.getElementById(id);
+
+function status(message, error) {
+  $("status").textContent = message;
+  $("status").className = error ? "error" : "";
+}
+
+async function api(method, path, body) {
+  const headers = {};
+  const token = sessionStorage.getItem(tokenKey);
+  if (token) {
+    headers.Authorization = "Bearer " + token;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const response = await fetch(path, {
+    method,
+    headers,
+    credentials: "same-origin",
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const text = await response.text();
+  let data = {};
+  try {
+    data = text ? JSON.parse(text) : {};
+  } catch {
+    data = { error: text.trim() };
+  }
+  if (response.status === 401) {
+    throw new Error("Not signed in: sign in or enter a token");
+  }
+  if (!response.ok) {
+    throw new Error(data.details ? data.error + ": " + data.details : data.error || response.statusText);
+  }
+  return { status: response.status, data };
+}
+
+function cell(row, text) {
+  const td = row.insertCell();
+  td.textContent = text ?? "";
+  return td;
+}
+
+function button(parent, label, onClick, className) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.type = "button";
+  if (className) {
+    b.className = className;
+  }
+  b.addEventListener("click", onClick);
+  parent.append(b);
+}
+
+function renderKeys() {
+  const filter = $("filter").value.toLowerCase();
+  const rows = $("key-rows");
+  rows.replaceChildren();
+  for (const key of keys) {
+    if (filter && !key.name.toLowerCase().includes(filter)) {
+      continue;
+    }
+    const row = rows.insertRow();
+    cell(row, key.name);
+    cell(row, key.ingestType);
+    cell(row, key.id);
+    cell(row, key.notes);
+    cell(row, Object.entries(key.labels || {}).map(([k, v]) => k + "=" + v).join(", "));
+    const actions = cell(row, "");
+    actions.className = "actions";
+    button(actions, "Rotate", () => rotateKey(key));
+    button(actions, "Delete", () => deleteKey(key), "danger");
+  }
+  status(rows.rows.length + " keys");
+}
+
+async function listKeys() {
+  const account = $("account").value.trim();
+  status("Loading keys…");
+  try {
+    const params = new URLSearchParams({ account, limit: "1000" });
+    const listed = [];
+    for (;;) {
+      const { data } = await api("GET", "/keys?" + params);
+      listed.push(...data.keys);
+      if (!data.nextCursor) {
+        break;
+      }
+      params.set("cursor", data.nextCursor);
+    }
+    keys = listed.sort((a, b) => a.name.localeCompare(b.name));
+    renderKeys();
+  } catch (err) {
+    status(err.message, true);
+  }
+}
+
+// confirmAction asks the user to type the key's name before a destructive
+// change, so a misclick can't rotate or delete the wrong key.
+function confirmAction(title, text, name) {
+  $("confirm-title").textContent = title;
+  $("confirm-text").textContent = text;
+  $("confirm-name").value = "";
+  $("confirm-ok").disabled = true;
+  $("confirm-name").oninput = () => {
+    $("confirm-ok").disabled = $("confirm-name").value !== name;
+  };
+  return new Promise((resolve) => {
+    $("confirm").onclose = () => resolve($("confirm").returnValue === "ok");
+    $("confirm").showModal();
+  });
+}
+
+function showKey(title, key) {
+  $("result-title").textContent = title;
+  $("result-key").textContent = key;
+  $("result").showModal();
+}
+
+// describe summarises answers that didn't change the key straight away.
+function describe(status, data, done) {
+  if (data.approval) {
+    return "Waiting for approval " + (data.approval.id || data.approval);
+  }
+  if (status === 202) {
+    return "Scheduled" + (data.job ? " as job " + (data.job.id || data.job) : "");
+  }
+  return done;
+}
+
+async function rotateKey(key) {
+  if (!(await confirmAction("Rotate " + key.name, "A new key replaces " + key.id + ", which is deleted. Agents using it stop sending data until they get the new key.", key.name))) {
+    return;
+  }
+  status("Rotating " + key.name + "…");
+  try {
+    const { status: code, data } = await api("POST", "/keys/" + encodeURIComponent(key.id) + "/rotate");
+    if (data.insert_key) {
+      showKey("Rotated " + key.name, data.insert_key.key);
+    }
+    status(describe(code, data, "Rotated " + key.name));
+    await listKeys();
+  } catch (err) {
+    status(err.message, true);
+  }
+}
+
+async function deleteKey(key) {
+  if (!(await confirmAction("Delete " + key.name, "Key " + key.id + " in account " + key.accountId + " will stop accepting data.", key.name))) {
+    return;
+  }
+  status("Deleting " + key.name + "…");
+  try {
+    const { status: code, data } = await api("DELETE", "/keys/" + encodeURIComponent(key.id));
+    status(describe(code, data, "Deleted " + key.name));
+    await listKeys();
+  } catch (err) {
+    status(err.message, true);
+  }
+}
+
+async function createKey() {
+  const form = $("create").querySelector("form");
+  const request = {
+    account_id: Number($("account").value.trim()),
+    name: form.elements.name.value.trim(),
+    ingestType: form.elements.ingestType.value,
+    notes: form.elements.notes.value.trim(),
+  };
+  status("Creating key…");
+  try {
+    const { status: code, data } = await api("POST", "/createKey", request);
+    if (data.insert_key) {
+      showKey("Created " + data.insert_key.name, data.insert_key.key);
+    }
+    status(describe(code, data, "Created key"));
+    await listKeys();
+  } catch (err) {
+    status(err.message, true);
+  }
+}
+
+async function searchAudit(more) {
+  const params = new URLSearchParams();
+  for (const input of $("audit-filter").elements) {
+    if (input.name && input.value.trim()) {
+      params.set(input.name, input.value.trim());
+    }
+  }
+  if (more) {
+    params.set("cursor", auditCursor);
+  } else {
+    $("audit-rows").replaceChildren();
+  }
+  status("Loading audit log…");
+  try {
+    const { data } = await api("GET", "/audit?" + params);
+    for (const entry of data.entries) {
+      const row = $("audit-rows").insertRow();
+      cell(row, new Date(entry.time).toLocaleString());
+      cell(row, entry.actor);
+      cell(row, entry.action);
+      cell(row, entry.accountId);
+      cell(row, entry.keyId);
+      cell(row, entry.details ? JSON.stringify(entry.details) : "");
+    }
+    auditCursor = data.nextCursor || "";
+    $("more").hidden = !auditCursor;
+    status($("audit-rows").rows.length + " entries");
+  } catch (err) {
+    status(err.message, true);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("auth").addEventListener("submit", (e) => {
+    e.preventDefault();
+    sessionStorage.setItem(tokenKey, $("token").value);
+    $("token").value = "";
+    status("Token set for this tab");
+  });
+  for (const tab of document.querySelectorAll("nav button")) {
+    tab.addEventListener("click", () => {
+      for (const other of document.querySelectorAll("nav button")) {
+        other.classList.toggle("active", other === tab);
+        $(other.dataset.tab).hidden = other !== tab;
+      }
+    });
+  }
+  $("lookup").addEventListener("submit", (e) => {
+    e.preventDefault();
+    listKeys();
+  });
+  $("filter").addEventListener("input", renderKeys);
+  $("new").addEventListener("click", () => {
+    if (!$("account").value.trim()) {
+      status("Enter an account ID first", true);
+      return;
+    }
+    $("create").querySelector("form").reset();
+    $("create").showModal();
+  });
+  $("create").addEventListener("close", () => {
+    if ($("create").returnValue === "ok") {
+      createKey();
+    }
+  });
+  $("audit-filter").addEventListener("submit", (e) => {
+    e.preventDefault();
+    searchAudit(false);
+  });
+  $("more").addEventListener("click", () => searchAudit(true));
+});
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultIngestPricePerGB = 0.30
+	defaultIngestFreeGB     = 100
+)
+
+// nrqlSince limits the since parameter to plain time expressions such as
+// "7 days ago" or "this month", so it can't change the query around it.
+var nrqlSince = regexp.MustCompile(`^[A-Za-z0-9 ]+$`)
+
+// DataTypeUsage is what one type of data cost over the period.
+type DataTypeUsage struct {
+	DataType      string  `json:"dataType"`
+	Gigabytes     float64 `json:"gigabytes"`
+	EstimatedCost float64 `json:"estimatedCost"`
+}
+
+// AccountUsage is the ingest of an account over a period, broken down by
+// data type.
+type AccountUsage struct {
+	AccountID         int             `json:"accountId"`
+	Since             string          `json:"since"`
+	DataTypes         []DataTypeUsage `json:"dataTypes"`
+	TotalGigabytes    float64         `json:"totalGigabytes"`
+	BillableGigabytes float64         `json:"billableGigabytes"`
+	EstimatedCost     float64         `json:"estimatedCost"`
+	PricePerGB        float64         `json:"pricePerGB"`
+}
+
+// ingestPricing reads the price per GB and the free allowance per month.
+func ingestPricing() (pricePerGB, freeGB float64) {
+	pricePerGB, freeGB = defaultIngestPricePerGB, defaultIngestFreeGB
+	if v, err := strconv.ParseFloat(os.Getenv("INGEST_PRICE_PER_GB"), 64); err == nil && v >= 0 {
+		pricePerGB = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("INGEST_FREE_GB"), 64); err == nil && v >= 0 {
+		freeGB = v
+	}
+	return pricePerGB, freeGB
+}
+
+// Get the ingest usage and estimated cost of an account
+func (s *Server) getAccountUsage(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received request for the usage of account %s", mux.Vars(r)["account"])
+	accountID, ok := accountFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// Month to date comes from NrMTDConsumption, which already holds the
+	// running totals the bill is based on. Any other period is summed from
+	// NrConsumption.
+	since := r.URL.Query().Get("since")
+	query := "SELECT latest(GigabytesIngested) AS gb FROM NrMTDConsumption WHERE productLine = 'DataPlatform' FACET usageMetric SINCE today LIMIT MAX"
+	if since == "" {
+		since = "this month"
+	} else if !nrqlSince.MatchString(since) {
+		http.Error(w, `{"error": "Invalid since"}`, http.StatusBadRequest)
+		return
+	} else {
+		query = fmt.Sprintf("SELECT sum(GigabytesIngested) AS gb FROM NrConsumption WHERE productLine = 'DataPlatform' FACET usageMetric SINCE %s LIMIT MAX", since)
+	}
+
+	rows, err := s.runNRQL(r.Context(), accountID, query)
+	if err != nil {
+		writeError(w, "Failed to query usage", err)
+		return
+	}
+
+	pricePerGB, freeGB := ingestPricing()
+	usage := AccountUsage{AccountID: accountID, Since: since, DataTypes: []DataTypeUsage{}, PricePerGB: pricePerGB}
+	for _, row := range rows {
+		dataType, _ := row["usageMetric"].(string)
+		if dataType == "" {
+			dataType, _ = row["facet"].(string)
+		}
+		gb, _ := row["gb"].(float64)
+		usage.DataTypes = append(usage.DataTypes, DataTypeUsage{DataType: dataType, Gigabytes: gb})
+		usage.TotalGigabytes += gb
+	}
+	// The free allowance comes off the account total, so each data type is
+	// charged its share of what is left.
+	usage.BillableGigabytes = max(usage.TotalGigabytes-freeGB, 0)
+	usage.EstimatedCost = usage.BillableGigabytes * pricePerGB
+	for i := range usage.DataTypes {
+		if usage.TotalGigabytes > 0 {
+			usage.DataTypes[i].EstimatedCost = usage.EstimatedCost * usage.DataTypes[i].Gigabytes / usage.TotalGigabytes
+		}
+	}
+	sort.Slice(usage.DataTypes, func(i, j int) bool { return usage.DataTypes[i].Gigabytes > usage.DataTypes[j].Gigabytes })
+
+	writeJSON(w, http.StatusOK, map[string]any{"usage": usage})
+}
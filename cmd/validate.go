@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ValidateKeyRequest asks whether a key is still active. Type is USER or
+// INGEST and is guessed from the key when left out. Ingest keys are looked
+// up in Accounts, which defaults to the accounts the caller is limited to.
+type ValidateKeyRequest struct {
+	Key      string `json:"key"`
+	Type     string `json:"type,omitempty"`
+	Accounts []int  `json:"accounts,omitempty"`
+}
+
+// KeyValidation is the verdict on a key. The key itself is never echoed.
+type KeyValidation struct {
+	Valid      bool   `json:"valid"`
+	Type       string `json:"type"`
+	ID         string `json:"id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	AccountID  int    `json:"account_id,omitempty"`
+	IngestType string `json:"ingestType,omitempty"`
+	UserEmail  string `json:"user_email,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// keyType guesses whether a key is a user key or an ingest key from its
+// format; user keys start with NRAK-.
+func keyType(key string) string {
+	if strings.HasPrefix(key, "NRAK-") {
+		return "USER"
+	}
+	return "INGEST"
+}
+
+// findIngestKey looks through the ingest keys of the accounts for one with
+// the given value. Values are compared in constant time and never kept.
+func (s *Server) findIngestKey(ctx context.Context, accountIDs []int, value string) (*IngestKey, error) {
+	var cursor *string
+	for {
+		req, err := newRequest("searchIngestKeys", keySearchDocument{Values: true})
+		if err != nil {
+			return nil, err
+		}
+		req.Var("accountIds", accountIDs)
+		req.Var("cursor", cursor)
+
+		var responseData struct {
+			Actor struct {
+				APIAccess struct {
+					KeySearch struct {
+						NextCursor *string `json:"nextCursor"`
+						Keys       []struct {
+							IngestKey
+							Key string `json:"key"`
+						} `json:"keys"`
+					} `json:"keySearch"`
+				} `json:"apiAccess"`
+			} `json:"actor"`
+		}
+		if err := s.runGraphQL(ctx, req, &responseData); err != nil {
+			return nil, err
+		}
+		search := responseData.Actor.APIAccess.KeySearch
+		for _, key := range search.Keys {
+			if subtle.ConstantTimeCompare([]byte(key.Key), []byte(value)) == 1 {
+				return &key.IngestKey, nil
+			}
+		}
+		if search.NextCursor == nil || *search.NextCursor == "" {
+			return nil, errKeyNotFound
+		}
+		cursor = search.NextCursor
+	}
+}
+
+// validateUserKey checks a user key with a test call in the caller's region.
+func (s *Server) validateUserKey(ctx context.Context, key string) (*KeyValidation, error) {
+	client, _ := s.credentialFor(ctx)
+	identity, err := s.whoAmI(ctx, client, key)
+	if credentialRejected(err) {
+		return &KeyValidation{Type: "USER", Reason: "NerdGraph rejected the key"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &KeyValidation{Valid: true, Type: "USER", UserEmail: identity.User.Email}, nil
+}
+
+// validateIngestKey checks that an ingest key still exists in one of the
+// accounts.
+func (s *Server) validateIngestKey(ctx context.Context, accountIDs []int, key string) (*KeyValidation, error) {
+	found, err := s.findIngestKey(ctx, accountIDs, key)
+	if errors.Is(err, errKeyNotFound) {
+		return &KeyValidation{Type: "INGEST", Reason: "No active ingest key with this value in the accounts searched"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &KeyValidation{
+		Valid:      true,
+		Type:       "INGEST",
+		ID:         found.ID,
+		Name:       found.Name,
+		AccountID:  found.AccountID,
+		IngestType: found.IngestType,
+	}, nil
+}
+
+// Check whether a key is still active
+func (s *Server) validateKey(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to validate a key")
+
+	var request ValidateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Key == "" {
+		http.Error(w, `{"error": "Invalid request: key is required"}`, http.StatusBadRequest)
+		return
+	}
+	if request.Type == "" {
+		request.Type = keyType(request.Key)
+	}
+
+	var validation *KeyValidation
+	var err error
+	switch strings.ToUpper(request.Type) {
+	case "USER":
+		validation, err = s.validateUserKey(r.Context(), request.Key)
+	case "INGEST":
+		accounts := request.Accounts
+		if caller := callerFromContext(r.Context()); len(accounts) == 0 && caller != nil {
+			accounts = caller.Accounts
+		}
+		if len(accounts) == 0 {
+			http.Error(w, `{"error": "Invalid request: accounts is required to look up an ingest key"}`, http.StatusBadRequest)
+			return
+		}
+		for _, accountID := range accounts {
+			if !authorizeAccount(w, r, accountID) {
+				return
+			}
+		}
+		validation, err = s.validateIngestKey(r.Context(), accounts, request.Key)
+	default:
+		http.Error(w, `{"error": "Invalid request: type must be USER or INGEST"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeError(w, "Failed to validate key", err)
+		return
+	}
+	log.Printf("Validated %s key: valid=%t", validation.Type, validation.Valid)
+	writeJSON(w, http.StatusOK, validation)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// WebhookEvent is posted to every configured webhook when something worth
+// telling other systems about happens to a key.
+type WebhookEvent struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Time      time.Time      `json:"time"`
+	Actor     string         `json:"actor"`
+	AccountID int            `json:"accountId,omitempty"`
+	KeyID     string         `json:"keyId,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// webhookDelivery is the payload of a "webhook" job: one event for one URL,
+// so a failing receiver is retried without repeating the others.
+type webhookDelivery struct {
+	URL   string       `json:"url"`
+	Event WebhookEvent `json:"event"`
+}
+
+// webhookNotifier sends events to the WEBHOOK_URLS. Deliveries go through
+// the job queue, so they are retried with backoff and survive restarts.
+type webhookNotifier struct {
+	urls   []string
+	secret []byte
+	client *http.Client
+}
+
+// newWebhookNotifierFromEnv returns nil when no webhooks are configured.
+func newWebhookNotifierFromEnv() *webhookNotifier {
+	var urls []string
+	for _, url := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	secret := os.Getenv("WEBHOOK_SECRET")
+	secrets.Add(secret)
+	return &webhookNotifier{
+		urls:   urls,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// deliver posts an event, signing the body with WEBHOOK_SECRET when set so
+// receivers can check it came from us.
+func (n *webhookNotifier) deliver(ctx context.Context, delivery webhookDelivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event.Type)
+	req.Header.Set("X-Webhook-ID", delivery.Event.ID)
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", delivery.URL, resp.Status)
+	}
+	return nil
+}
+
+func (s *Server) registerWebhookJobs() {
+	s.jobs.Register("webhook", func(ctx context.Context, job *Job) (any, error) {
+		var delivery webhookDelivery
+		if err := json.Unmarshal(job.Payload, &delivery); err != nil {
+			return nil, err
+		}
+		if s.webhooks == nil {
+			return nil, fmt.Errorf("webhooks are no longer configured")
+		}
+		return nil, retryLater(s.webhooks.deliver(ctx, delivery))
+	})
+}
+
+// notify queues an event for every webhook. Failing to queue it is logged
+// rather than failing the operation it describes.
+func (s *Server) notify(ctx context.Context, event WebhookEvent) {
+	if s.webhooks == nil {
+		return
+	}
+	event.ID = newID()
+	event.Time = time.Now().UTC()
+	event.Actor = actorName(ctx)
+	for _, url := range s.webhooks.urls {
+		if _, err := s.jobs.Enqueue(ctx, "webhook", webhookDelivery{URL: url, Event: event}); err != nil {
+			log.Printf("Failed to queue %s webhook for %s: %v", event.Type, url, err)
+		}
+	}
+}
@@ -1,14 +1,8 @@
-module api
+module github.com/chinelo-obitube/api-go
 
 go 1.24.0
 
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
-	github.com/machinebox/graphql v0.2.2
-)
-
-require (
-	github.com/matryer/is v1.4.1 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
 )
@@ -0,0 +1,268 @@
+// Package graphql is a small GraphQL client for NerdGraph. It sends
+// queries with variables over a caller-supplied http.Client, decodes data
+// into a typed response, and exposes GraphQL errors, including their
+// extensions, as typed values.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Request is a GraphQL document with its variables and the HTTP headers to
+// send it with. Name identifies the request to the caller, for logs and
+// metrics, and is not sent.
+type Request struct {
+	Name      string
+	Query     string
+	Variables map[string]any
+	Header    http.Header
+}
+
+// NewRequest returns a request for the query with no variables set.
+func NewRequest(query string) *Request {
+	return &Request{Query: query, Variables: map[string]any{}, Header: http.Header{}}
+}
+
+// Var sets a variable.
+func (r *Request) Var(key string, value any) {
+	r.Variables[key] = value
+}
+
+// IsMutation reports whether the request is a mutation, which callers
+// usually must not retry blindly. It reads the keyword of the first
+// operation in the document, past comments and fragment definitions.
+func (r *Request) IsMutation() bool {
+	doc := r.Query
+	for {
+		doc = strings.TrimLeft(doc, " \t\r\n,\ufeff")
+		switch {
+		case strings.HasPrefix(doc, "#"):
+			_, doc, _ = strings.Cut(doc, "\n")
+		case keyword(doc, "fragment"):
+			doc = skipSelectionSet(doc)
+		default:
+			return keyword(doc, "mutation")
+		}
+	}
+}
+
+// keyword reports whether doc starts with the name word.
+func keyword(doc, word string) bool {
+	if !strings.HasPrefix(doc, word) || len(doc) == len(word) {
+		return false
+	}
+	next := doc[len(word)]
+	return !(next == '_' || next >= '0' && next <= '9' || next >= 'A' && next <= 'Z' || next >= 'a' && next <= 'z')
+}
+
+// skipSelectionSet returns what follows the first selection set in doc,
+// skipping strings and comments that might hold braces.
+func skipSelectionSet(doc string) string {
+	depth := 0
+	for i := 0; i < len(doc); i++ {
+		switch doc[i] {
+		case '#':
+			for i < len(doc) && doc[i] != '\n' {
+				i++
+			}
+		case '"':
+			for i++; i < len(doc) && doc[i] != '"'; i++ {
+				if doc[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return doc[i+1:]
+			}
+		}
+	}
+	return ""
+}
+
+// Location is a position in the query an error refers to.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error is one entry of the errors list of a response.
+type Error struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return "graphql: " + e.Message
+}
+
+// Extension returns a string extension such as errorClass, or "".
+func (e *Error) Extension(name string) string {
+	value, _ := e.Extensions[name].(string)
+	return value
+}
+
+// Errors is the errors list of a response as a Go error.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return "graphql: " + strings.Join(messages, "; ")
+}
+
+// Unwrap lets errors.As find the individual errors.
+func (e Errors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// HTTPError is a response that wasn't a GraphQL response at all, such as a
+// 502 from a load balancer.
+type HTTPError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("graphql: server returned status %d", e.StatusCode)
+}
+
+// Response is a raw GraphQL response.
+type Response struct {
+	Data       json.RawMessage `json:"data"`
+	Errors     Errors          `json:"errors,omitempty"`
+	Extensions map[string]any  `json:"extensions,omitempty"`
+}
+
+// RetryFunc decides whether a failed attempt is tried again and after how
+// long. attempt counts from 1.
+type RetryFunc func(req *Request, attempt int, err error) (wait time.Duration, retry bool)
+
+// Client sends requests to one GraphQL endpoint.
+type Client struct {
+	endpoint   string
+	httpClient *http.Client
+	retry      RetryFunc
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client requests are sent with, and so its
+// transport.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetry sets the hook consulted after every failed attempt. Without
+// one, requests are tried once.
+func WithRetry(retry RetryFunc) Option {
+	return func(c *Client) { c.retry = retry }
+}
+
+// NewClient returns a client for the endpoint.
+func NewClient(endpoint string, opts ...Option) *Client {
+	c := &Client{endpoint: endpoint, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run sends the request and decodes its data into resp. When the response
+// carries errors, whatever data came with them is still decoded and the
+// errors are returned as Errors.
+func (c *Client) Run(ctx context.Context, req *Request, resp any) error {
+	response, err := c.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp != nil && len(response.Data) > 0 && string(response.Data) != "null" {
+		if err := json.Unmarshal(response.Data, resp); err != nil {
+			return fmt.Errorf("graphql: decoding data: %w", err)
+		}
+	}
+	if len(response.Errors) > 0 {
+		return response.Errors
+	}
+	return nil
+}
+
+// Do sends the request and returns the raw response. Errors in the
+// response body are left for the caller, but they are shown to the retry
+// hook along with transport failures, so it can retry either.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	body, err := json.Marshal(map[string]any{"query": req.Query, "variables": req.Variables})
+	if err != nil {
+		return nil, fmt.Errorf("graphql: encoding request: %w", err)
+	}
+	for attempt := 1; ; attempt++ {
+		response, err := c.do(ctx, req, body)
+		failure := err
+		if err == nil && len(response.Errors) > 0 {
+			failure = response.Errors
+		}
+		if failure == nil || c.retry == nil {
+			return response, err
+		}
+		wait, retry := c.retry(req, attempt, failure)
+		if !retry {
+			return response, err
+		}
+		select {
+		case <-ctx.Done():
+			return response, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, req *Request, body []byte) (*Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.Header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	httpReq.Header.Set("Accept", "application/json; charset=utf-8")
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("graphql: reading response: %w", err)
+	}
+
+	var response Response
+	if err := json.Unmarshal(data, &response); err != nil || (response.Data == nil && len(response.Errors) == 0) {
+		if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+			return nil, &HTTPError{StatusCode: httpResp.StatusCode, Body: data}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("graphql: decoding response: %w", err)
+		}
+	}
+	return &response, nil
+}
@@ -0,0 +1,150 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// server answers each request with the next of the given status and body
+// pairs, repeating the last one, and records the request bodies.
+func server(t *testing.T, responses ...[2]any) (*httptest.Server, *[]map[string]any) {
+	t.Helper()
+	var requests []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		response := responses[min(len(requests), len(responses))-1]
+		w.WriteHeader(response[0].(int))
+		w.Write([]byte(response[1].(string)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestRun(t *testing.T) {
+	srv, requests := server(t, [2]any{http.StatusOK, `{"data": {"actor": {"user": {"email": "a@example.com"}}}}`})
+	req := NewRequest(`query($id: Int!) { actor { user { email } } }`)
+	req.Var("id", 1)
+
+	var resp struct {
+		Actor struct{ User struct{ Email string } }
+	}
+	if err := NewClient(srv.URL).Run(context.Background(), req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Actor.User.Email != "a@example.com" {
+		t.Errorf("decoded %+v", resp)
+	}
+	if got := (*requests)[0]["variables"].(map[string]any)["id"]; got != 1.0 {
+		t.Errorf("sent id %v, want 1", got)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	srv, _ := server(t, [2]any{http.StatusOK, `{
+		"data": {"m0": {"id": "a"}, "m1": null},
+		"errors": [{"message": "bad name", "path": ["m1"], "extensions": {"errorClass": "BAD_USER_INPUT"}}]
+	}`})
+	var resp map[string]*struct{ ID string }
+	err := NewClient(srv.URL).Run(context.Background(), NewRequest(`mutation { m0: a m1: b }`), &resp)
+
+	var gqlErr *Error
+	if !errors.As(err, &gqlErr) || gqlErr.Extension("errorClass") != "BAD_USER_INPUT" || gqlErr.Path[0] != "m1" {
+		t.Fatalf("err = %v, want the BAD_USER_INPUT error on m1", err)
+	}
+	if resp["m0"] == nil || resp["m0"].ID != "a" {
+		t.Errorf("data returned with the errors was not decoded: %+v", resp)
+	}
+}
+
+func TestRunHTTPError(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		status int
+		body   string
+		want   int
+	}{
+		{"gateway page", http.StatusBadGateway, "<html>Bad Gateway</html>", http.StatusBadGateway},
+		{"empty JSON", http.StatusServiceUnavailable, `{}`, http.StatusServiceUnavailable},
+		{"GraphQL errors with a status", http.StatusTooManyRequests, `{"errors": [{"message": "slow down"}]}`, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			srv, _ := server(t, [2]any{test.status, test.body})
+			err := NewClient(srv.URL).Run(context.Background(), NewRequest(`{ a }`), nil)
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) != (test.want != 0) || (httpErr != nil && httpErr.StatusCode != test.want) {
+				t.Errorf("err = %v, want status %d", err, test.want)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	srv, requests := server(t,
+		[2]any{http.StatusBadGateway, "bad gateway"},
+		[2]any{http.StatusOK, `{"errors": [{"message": "timeout", "extensions": {"errorClass": "TIMEOUT"}}]}`},
+		[2]any{http.StatusOK, `{"data": {"a": 1}}`},
+	)
+	var seen []error
+	retry := func(req *Request, attempt int, err error) (time.Duration, bool) {
+		seen = append(seen, err)
+		return time.Millisecond, attempt < 3
+	}
+	var resp struct{ A int }
+	if err := NewClient(srv.URL, WithRetry(retry)).Run(context.Background(), NewRequest(`{ a }`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.A != 1 || len(*requests) != 3 {
+		t.Errorf("got %+v after %d requests, want 1 after 3", resp, len(*requests))
+	}
+	var httpErr *HTTPError
+	var gqlErrs Errors
+	if len(seen) != 2 || !errors.As(seen[0], &httpErr) || !errors.As(seen[1], &gqlErrs) {
+		t.Errorf("retry hook saw %v, want the HTTP error and then the GraphQL errors", seen)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv, requests := server(t, [2]any{http.StatusBadGateway, "bad gateway"})
+	retry := func(req *Request, attempt int, err error) (time.Duration, bool) {
+		return time.Millisecond, !req.IsMutation() && attempt < 2
+	}
+	client := NewClient(srv.URL, WithRetry(retry))
+
+	client.Run(context.Background(), NewRequest(`{ a }`), nil)
+	if len(*requests) != 2 {
+		t.Errorf("query sent %d times, want 2", len(*requests))
+	}
+	client.Run(context.Background(), NewRequest(` mutation { a }`), nil)
+	if len(*requests) != 3 {
+		t.Errorf("mutation sent %d times, want once", len(*requests)-2)
+	}
+}
+
+func TestIsMutation(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  bool
+	}{
+		{`mutation createKeys($keys: [Input!]!) { create(keys: $keys) { id } }`, true},
+		{"\n\tmutation{ a }", true},
+		{"# Override of createKeys\n# kept in sync by hand\nmutation { a }", true},
+		{"fragment Key on ApiAccessKey { id name }\nmutation { create { ...Key } }", true},
+		{`fragment Key on ApiAccessKey { notes(format: "{ not a brace") } mutation { a }`, true},
+		{`query { actor { user { email } } }`, false},
+		{`{ actor { user { email } } }`, false},
+		{"# mutation\nquery { a }", false},
+		{`query mutationLog { a }`, false},
+		{`mutationLog { a }`, false},
+	} {
+		if got := (&Request{Query: test.query}).IsMutation(); got != test.want {
+			t.Errorf("IsMutation(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}